@@ -0,0 +1,280 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 默认的熔断设置。
+const (
+	DefaultBreakerWindow      time.Duration = 10 * time.Second // DefaultBreakerWindow 是默认的统计窗口。
+	DefaultBreakerMinRequests               = 20               // DefaultBreakerMinRequests 是统计窗口内默认最少的请求数，少于这个数不会熔断。
+	DefaultBreakerOpenTimeout time.Duration = 5 * time.Second  // DefaultBreakerOpenTimeout 是熔断后默认经过多久开始探测。
+	DefaultBreakerProbes                    = 3                // DefaultBreakerProbes 是半开状态下默认的探测请求数。
+)
+
+// BreakerState 是熔断器的状态。
+type BreakerState int
+
+// 熔断器的所有状态。
+const (
+	BreakerClosed   BreakerState = iota // BreakerClosed 代表实例正常，所有请求都会发给数据库。
+	BreakerOpen                         // BreakerOpen 代表实例已经熔断，所有请求直接返回 *BreakerOpenError。
+	BreakerHalfOpen                     // BreakerHalfOpen 代表正在探测实例是否恢复，只有少量请求会发给数据库。
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// breakerErrors 是代表实例不可用的 MySQL 错误号，包括连接数过多（1040、1203）和服务器正在关闭（1053）。
+// 其他 MySQL 错误说明服务器可以正常响应，不计入失败。
+var breakerErrors = map[uint16]bool{
+	1040: true,
+	1053: true,
+	1203: true,
+}
+
+// BreakerOpenError 是实例熔断时返回的错误，这时请求不会发给数据库。
+type BreakerOpenError struct {
+	Instance string // Instance 是熔断的实例主库的地址和数据库名。
+}
+
+func (e *BreakerOpenError) Error() string {
+	return "go-mysql: circuit breaker is open for " + e.Instance
+}
+
+// breaker 是一个实例的熔断器。
+//
+// 统计窗口内请求数不少于 MinRequests 并且失败率达到 ErrorRate 时熔断，之后所有请求直接返回 *BreakerOpenError。
+// 熔断 OpenTimeout 之后进入半开状态，允许 Probes 个请求探测实例，全部成功则恢复，任何一个失败则重新熔断。
+// 探测请求可能一直没有结果，半开状态持续 OpenTimeout 之后如果名额已经用完，会重新开始一轮探测。
+// 连接断开、超时以及执行时间超过 Latency 的请求都算作失败，调用者的 ctx 结束导致的错误不参与统计。
+type breaker struct {
+	Name        string
+	ErrorRate   float64
+	Latency     time.Duration
+	Window      time.Duration
+	MinRequests int
+	OpenTimeout time.Duration
+	Probes      int
+
+	mu          sync.Mutex
+	state       BreakerState
+	generation  uint64 // generation 在每次状态变化时加一，用来忽略上一个状态中开始的请求。
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probedAt    time.Time // probedAt 是这一轮探测开始的时间。
+	probing     int       // probing 是半开状态下已经放行的探测请求数。
+	succeeded   int       // succeeded 是半开状态下已经成功的探测请求数。
+}
+
+func newBreaker(name string, config ConfigBreaker) *breaker {
+	if config.ErrorRate <= 0 {
+		return nil
+	}
+
+	b := &breaker{
+		Name:        name,
+		ErrorRate:   config.ErrorRate,
+		Latency:     config.Latency,
+		Window:      config.Window,
+		MinRequests: config.MinRequests,
+		OpenTimeout: config.OpenTimeout,
+		Probes:      config.Probes,
+		windowStart: time.Now(),
+	}
+
+	if b.Window <= 0 {
+		b.Window = DefaultBreakerWindow
+	}
+
+	if b.MinRequests <= 0 {
+		b.MinRequests = DefaultBreakerMinRequests
+	}
+
+	if b.OpenTimeout <= 0 {
+		b.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+
+	if b.Probes <= 0 {
+		b.Probes = DefaultBreakerProbes
+	}
+
+	return b
+}
+
+// State 返回熔断器当前的状态。
+func (b *breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow 判断是否可以发送请求，不可以时返回 *BreakerOpenError。
+// 允许发送的请求结束后必须用返回的 gen 调用 Done。
+func (b *breaker) Allow(ctx context.Context) (gen uint64, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.OpenTimeout {
+		b.transit(ctx, BreakerHalfOpen)
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		err = &BreakerOpenError{Instance: b.Name}
+	case BreakerHalfOpen:
+		if b.probing >= b.Probes && time.Since(b.probedAt) >= b.OpenTimeout {
+			// 之前的探测请求太久没有结果，重新开始探测，这些请求的结果会因为 generation 变化而被忽略。
+			b.transit(ctx, BreakerHalfOpen)
+		}
+
+		if b.probing >= b.Probes {
+			err = &BreakerOpenError{Instance: b.Name}
+			break
+		}
+
+		b.probing++
+	}
+
+	if err != nil {
+		statsForBreakerReject(ctx, b.Name)
+		return
+	}
+
+	gen = b.generation
+	return
+}
+
+// Done 记录一个请求的结果，outer 是调用者的 ctx，start 是请求开始的时间。
+func (b *breaker) Done(outer context.Context, gen uint64, start time.Time, err error) {
+	if b == nil {
+		return
+	}
+
+	failed := b.failed(time.Since(start), err)
+
+	// 调用者的 ctx 结束导致的错误与实例无关。
+	ignored := err != nil && outer.Err() != nil
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gen != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if ignored {
+			return
+		}
+
+		now := time.Now()
+
+		if now.Sub(b.windowStart) >= b.Window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+
+		b.requests++
+
+		if failed {
+			b.failures++
+		}
+
+		if b.requests >= b.MinRequests && float64(b.failures) >= b.ErrorRate*float64(b.requests) {
+			b.transit(outer, BreakerOpen)
+		}
+
+	case BreakerHalfOpen:
+		if ignored {
+			// 探测没有结果，让出名额给其他请求。
+			b.probing--
+			return
+		}
+
+		if failed {
+			b.transit(outer, BreakerOpen)
+			return
+		}
+
+		b.succeeded++
+
+		if b.succeeded >= b.Probes {
+			b.transit(outer, BreakerClosed)
+		}
+	}
+}
+
+// failed 判断一个请求是否说明实例不可用。
+func (b *breaker) failed(elapsed time.Duration, err error) bool {
+	if b.Latency > 0 && elapsed > b.Latency {
+		return true
+	}
+
+	if err == nil {
+		return false
+	}
+
+	if err == driver.ErrBadConn || err == mysql.ErrInvalidConn || err == context.DeadlineExceeded {
+		return true
+	}
+
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		return breakerErrors[e.Number]
+	case net.Error:
+		return true
+	}
+
+	return false
+}
+
+// transit 切换到 state，调用者必须持有 b.mu。
+func (b *breaker) transit(ctx context.Context, state BreakerState) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probing = 0
+	b.succeeded = 0
+
+	switch state {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerHalfOpen:
+		b.probedAt = time.Now()
+	case BreakerClosed:
+		b.windowStart = time.Now()
+		b.requests = 0
+		b.failures = 0
+	}
+
+	statsForBreakerState(ctx, b.Name, from, state)
+}
@@ -0,0 +1,133 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/altstory/go-runner"
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+func TestBreaker(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		Breaker: ConfigBreaker{
+			ErrorRate:   0.5,
+			Latency:     30 * time.Millisecond,
+			MinRequests: 4,
+			OpenTimeout: 50 * time.Millisecond,
+			Probes:      2,
+		},
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+	stats := &runner.Stats{}
+	ctx := runner.WithStats(context.Background(), stats)
+	m := initTable(ctx, t, f, "breaker", "id BIGINT PRIMARY KEY")
+	a.Equal(m.BreakerState(), BreakerClosed)
+
+	// 普通的 SQL 错误不会熔断。
+	_, err := m.Exec("INSERT INTO breaker VALUES (1)")
+	a.NilError(err)
+
+	for i := 0; i < 4; i++ {
+		_, err = m.Exec("INSERT INTO breaker VALUES (1)")
+		a.Equal(err.(*mysql.MySQLError).Number, uint16(1062))
+	}
+
+	a.Equal(m.BreakerState(), BreakerClosed)
+
+	// 调用者的 ctx 结束也不会熔断。
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	for i := 0; i < 4; i++ {
+		_, err = f.New(cancelled).Exec("INSERT INTO breaker VALUES (2)")
+		a.Equal(err, context.Canceled)
+	}
+
+	a.Equal(m.BreakerState(), BreakerClosed)
+
+	// 连接断开达到失败率后熔断，所有请求直接返回错误。
+	memdb.Inject(testDB, 20, mysql.ErrInvalidConn)
+
+	for m.BreakerState() == BreakerClosed {
+		_, err = m.Exec("INSERT INTO breaker VALUES (2)")
+		a.Equal(err, mysql.ErrInvalidConn)
+	}
+
+	a.Equal(m.BreakerState(), BreakerOpen)
+	memdb.Drop(testDB) // 清理没有用完的错误。
+	var v int64
+	_, err = m.Exec("INSERT INTO breaker VALUES (2)")
+	a.Equal(err, &BreakerOpenError{Instance: memdb.Scheme + testDB})
+	_, err = m.Query("SELECT 1")
+	a.Equal(err, &BreakerOpenError{Instance: memdb.Scheme + testDB})
+	a.Equal(m.QueryScalar(&v, "SELECT 1"), &BreakerOpenError{Instance: memdb.Scheme + testDB})
+	_, err = m.BeginTx(nil)
+	a.Equal(err, &BreakerOpenError{Instance: memdb.Scheme + testDB})
+	a.Equal(statsValue(stats, mysqlBreakerRejectStatsKey), 4)
+
+	// 经过 OpenTimeout 之后探测请求全部成功则恢复。
+	time.Sleep(60 * time.Millisecond)
+	a.NilError(m.QueryScalar(&v, "SELECT 1"))
+	a.Equal(m.BreakerState(), BreakerHalfOpen)
+	a.NilError(m.QueryScalar(&v, "SELECT 1"))
+	a.Equal(m.BreakerState(), BreakerClosed)
+
+	// 慢请求也算作失败。
+	for m.BreakerState() == BreakerClosed {
+		a.NilError(m.QueryScalar(&v, "SELECT SLEEP(0.04)"))
+	}
+
+	a.Equal(m.BreakerState(), BreakerOpen)
+
+	// 探测请求失败时重新熔断。
+	time.Sleep(60 * time.Millisecond)
+	memdb.Inject(testDB, 1, mysql.ErrInvalidConn)
+	a.Equal(m.QueryScalar(&v, "SELECT 1"), mysql.ErrInvalidConn)
+	a.Equal(m.BreakerState(), BreakerOpen)
+}
+
+func TestBreakerLostProbes(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	b := newBreaker("lost_probes", ConfigBreaker{
+		ErrorRate:   0.5,
+		MinRequests: 1,
+		OpenTimeout: 30 * time.Millisecond,
+		Probes:      2,
+	})
+
+	gen, err := b.Allow(ctx)
+	a.NilError(err)
+	b.Done(ctx, gen, time.Now(), mysql.ErrInvalidConn)
+	a.Equal(b.State(), BreakerOpen)
+
+	// 探测请求一直没有调用 Done，名额用完之后拒绝其他请求。
+	time.Sleep(40 * time.Millisecond)
+	lost, err := b.Allow(ctx)
+	a.NilError(err)
+	_, err = b.Allow(ctx)
+	a.NilError(err)
+	_, err = b.Allow(ctx)
+	a.Equal(err, &BreakerOpenError{Instance: "lost_probes"})
+	a.Equal(b.State(), BreakerHalfOpen)
+
+	// 经过 OpenTimeout 之后重新开始探测，之前的探测结果被忽略。
+	time.Sleep(40 * time.Millisecond)
+	gen1, err := b.Allow(ctx)
+	a.NilError(err)
+	gen2, err := b.Allow(ctx)
+	a.NilError(err)
+	b.Done(ctx, lost, time.Now(), mysql.ErrInvalidConn)
+	a.Equal(b.State(), BreakerHalfOpen)
+	b.Done(ctx, gen1, time.Now(), nil)
+	b.Done(ctx, gen2, time.Now(), nil)
+	a.Equal(b.State(), BreakerClosed)
+}
@@ -0,0 +1,243 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+// 默认的批量写入设置。
+const (
+	DefaultBulkMaxRows  = 1000    // DefaultBulkMaxRows 是每条语句默认最多写入的行数。
+	DefaultBulkMaxBytes = 1 << 20 // DefaultBulkMaxBytes 是每条语句默认的最大字节数，需要小于 MySQL 的 max_allowed_packet。
+)
+
+// maxPlaceholders 是 MySQL 一条预处理语句最多可以使用的参数个数。
+const maxPlaceholders = 65535
+
+// BulkInsertMode 是批量写入遇到重复数据时的处理方式。
+type BulkInsertMode int
+
+// 所有的批量写入模式。
+const (
+	BulkInsertDefault BulkInsertMode = iota // BulkInsertDefault 使用 INSERT，遇到重复数据时报错。
+	BulkInsertIgnore                        // BulkInsertIgnore 使用 INSERT IGNORE，忽略重复数据。
+	BulkInsertUpdate                        // BulkInsertUpdate 使用 INSERT ... ON DUPLICATE KEY UPDATE，更新重复数据。
+	BulkInsertReplace                       // BulkInsertReplace 使用 REPLACE，删除重复数据后重新写入。
+)
+
+// BulkInsertOptions 是批量写入的设置。
+type BulkInsertOptions struct {
+	Mode     BulkInsertMode // Mode 是遇到重复数据时的处理方式。
+	Update   []string       // Update 是 BulkInsertUpdate 模式下需要更新的列，默认更新所有写入的列。
+	MaxRows  int            // MaxRows 是每条语句最多写入的行数，默认是 DefaultBulkMaxRows。
+	MaxBytes int            // MaxBytes 是每条语句估算的最大字节数，默认是 DefaultBulkMaxBytes。
+}
+
+// BulkSource 是批量写入的数据来源。
+type BulkSource interface {
+	// Next 返回下一行数据，值的顺序与列的顺序一致，没有更多数据时返回 io.EOF。
+	Next() ([]interface{}, error)
+}
+
+type bulkValues struct {
+	rows [][]interface{}
+}
+
+// BulkValues 返回一个按顺序读取 rows 的 BulkSource。
+func BulkValues(rows [][]interface{}) BulkSource {
+	return &bulkValues{
+		rows: rows,
+	}
+}
+
+func (bv *bulkValues) Next() ([]interface{}, error) {
+	if len(bv.rows) == 0 {
+		return nil, io.EOF
+	}
+
+	row := bv.rows[0]
+	bv.rows = bv.rows[1:]
+	return row, nil
+}
+
+// BulkInsert 将 source 中的所有数据写入 table，返回总的影响行数。
+//
+// 数据会按照 opts.MaxRows 和 opts.MaxBytes 拆分成多条 INSERT 语句依次执行，
+// 每条语句的影响行数都会计入 mysql_affected_rows 统计。
+// 不在事务中时每条语句单独提交，出错时之前的语句已经写入，需要原子性的话请使用 Tx#BulkInsert。
+// table 和 columns 会原样拼接到 SQL 里，不能使用外部输入的数据。
+func (mysql *MySQL) BulkInsert(table string, columns []string, source BulkSource, opts *BulkInsertOptions) (affected int64, err error) {
+	return bulkInsert(mysql.ctx, mysql, table, columns, source, opts)
+}
+
+// BulkInsert 在事务中将 source 中的所有数据写入 table，详见 MySQL#BulkInsert。
+func (tx *Tx) BulkInsert(table string, columns []string, source BulkSource, opts *BulkInsertOptions) (affected int64, err error) {
+	return bulkInsert(tx.ctx, tx, table, columns, source, opts)
+}
+
+func bulkInsert(ctx context.Context, db db, table string, columns []string, source BulkSource, opts *BulkInsertOptions) (affected int64, err error) {
+	if table == "" || len(columns) == 0 {
+		err = errors.New("go-mysql: table and columns are required by bulk insert")
+		return
+	}
+
+	var o BulkInsertOptions
+
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.MaxRows <= 0 {
+		o.MaxRows = DefaultBulkMaxRows
+	}
+
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = DefaultBulkMaxBytes
+	}
+
+	if max := maxPlaceholders / len(columns); o.MaxRows > max {
+		o.MaxRows = max
+	}
+
+	prefix, suffix, err := bulkInsertClauses(table, columns, &o)
+
+	if err != nil {
+		return
+	}
+
+	placeholders := "(" + strings.Repeat("?, ", len(columns)-1) + "?)"
+	buf := &strings.Builder{}
+	var args []interface{}
+	var rows, size int
+
+	flush := func() error {
+		buf.WriteString(suffix)
+		res, err := db.Exec(buf.String(), args...)
+
+		if err != nil {
+			log.Errorf(ctx, "err=%v||table=%v||rows=%v||affected=%v||go-mysql: fail to bulk insert", err, table, rows, affected)
+			return err
+		}
+
+		n, _ := res.RowsAffected()
+		affected += n
+		buf.Reset()
+		args = nil
+		rows = 0
+		return nil
+	}
+
+	for {
+		var row []interface{}
+
+		if row, err = source.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+				break
+			}
+
+			return
+		}
+
+		if len(row) != len(columns) {
+			err = fmt.Errorf("go-mysql: expected %v values in bulk insert row, not %v", len(columns), len(row))
+			return
+		}
+
+		rowSize := len(placeholders) + 2
+
+		for _, v := range row {
+			rowSize += estimateSize(v)
+		}
+
+		// 单独一行超过 MaxBytes 时也只能单独写入。
+		if rows > 0 && (rows >= o.MaxRows || size+rowSize > o.MaxBytes) {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+
+		if rows == 0 {
+			buf.WriteString(prefix)
+			size = len(prefix) + len(suffix)
+		} else {
+			buf.WriteString(", ")
+		}
+
+		buf.WriteString(placeholders)
+		args = append(args, row...)
+		size += rowSize
+		rows++
+	}
+
+	if rows > 0 {
+		err = flush()
+	}
+
+	return
+}
+
+// bulkInsertClauses 返回批量写入语句中 VALUES 之前和之后的部分。
+func bulkInsertClauses(table string, columns []string, o *BulkInsertOptions) (prefix, suffix string, err error) {
+	verb := "INSERT INTO "
+
+	switch o.Mode {
+	case BulkInsertDefault, BulkInsertUpdate:
+	case BulkInsertIgnore:
+		verb = "INSERT IGNORE INTO "
+	case BulkInsertReplace:
+		verb = "REPLACE INTO "
+	default:
+		err = fmt.Errorf("go-mysql: invalid bulk insert mode %v", o.Mode)
+		return
+	}
+
+	prefix = verb + table + " (" + strings.Join(columns, ", ") + ") VALUES "
+
+	if o.Mode == BulkInsertUpdate {
+		update := o.Update
+
+		if len(update) == 0 {
+			update = columns
+		}
+
+		assignments := make([]string, 0, len(update))
+
+		for _, col := range update {
+			assignments = append(assignments, col+" = VALUES("+col+")")
+		}
+
+		suffix = " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	}
+
+	return
+}
+
+// estimateSize 估算 v 在请求中占用的字节数。
+func estimateSize(v interface{}) int {
+	switch val := v.(type) {
+	case nil:
+		return 4
+	case string:
+		return len(val) + 2
+	case []byte:
+		return len(val) + 2
+	case time.Time:
+		return 28
+	case driver.Valuer:
+		if dv, err := val.Value(); err == nil {
+			if _, ok := dv.(driver.Valuer); !ok {
+				return estimateSize(dv)
+			}
+		}
+	}
+
+	return 20
+}
@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huandu/go-assert"
+)
+
+func TestBulkInsert(t *testing.T) {
+	a := assert.New(t)
+	f := memFactory(t)
+	defer f.Close()
+	ctx := context.Background()
+	m := initTable(ctx, t, f, "bulk", "id BIGINT PRIMARY KEY", "name VARCHAR(16) NOT NULL", "score INT NOT NULL")
+	columns := []string{"id", "name", "score"}
+	var rows [][]interface{}
+
+	for id := int64(1); id <= 10; id++ {
+		rows = append(rows, []interface{}{id, "n", id * 10})
+	}
+
+	affected, err := m.BulkInsert("bulk", columns, BulkValues(rows), &BulkInsertOptions{
+		MaxRows: 3,
+	})
+	a.NilError(err)
+	a.Equal(affected, int64(10))
+
+	var count int64
+	a.NilError(m.QueryScalar(&count, "SELECT COUNT(*) FROM bulk"))
+	a.Equal(count, int64(10))
+
+	// 重复数据。
+	dup := [][]interface{}{
+		{int64(11), "n", 110},
+		{int64(1), "dup", 1},
+		{int64(12), "n", 120},
+	}
+	affected, err = m.BulkInsert("bulk", columns, BulkValues(dup), &BulkInsertOptions{
+		Mode: BulkInsertIgnore,
+	})
+	a.NilError(err)
+	a.Equal(affected, int64(2))
+
+	affected, err = m.BulkInsert("bulk", columns, BulkValues(dup), &BulkInsertOptions{
+		Mode:   BulkInsertUpdate,
+		Update: []string{"score"},
+	})
+	a.NilError(err)
+	a.Equal(affected, int64(2))
+
+	var name string
+	var score int64
+	row, err := m.QueryRow("SELECT name, score FROM bulk WHERE id = 1")
+	a.NilError(err)
+	a.NilError(row.Scan(&name, &score))
+	a.Equal(name, "n")
+	a.Equal(score, int64(1))
+
+	affected, err = m.BulkInsert("bulk", columns, BulkValues(dup[1:2]), &BulkInsertOptions{
+		Mode: BulkInsertReplace,
+	})
+	a.NilError(err)
+	a.Equal(affected, int64(2))
+	a.NilError(m.QueryScalar(&name, "SELECT name FROM bulk WHERE id = 1"))
+	a.Equal(name, "dup")
+
+	// 按照字节数拆分，出错之前的语句已经写入。
+	affected, err = m.BulkInsert("bulk", columns, BulkValues([][]interface{}{
+		{int64(13), "n", 130},
+		{int64(1), "n", 1},
+	}), &BulkInsertOptions{
+		MaxBytes: 1,
+	})
+	a.NonNilError(err)
+	a.Equal(affected, int64(1))
+	a.NilError(m.QueryScalar(&count, "SELECT COUNT(*) FROM bulk"))
+	a.Equal(count, int64(13))
+
+	// 在事务中写入。
+	a.NilError(m.Transaction(nil, func(tx *Tx) error {
+		affected, err := tx.BulkInsert("bulk", columns, BulkValues([][]interface{}{
+			{int64(14), "n", 140},
+			{int64(15), "n", 150},
+		}), &BulkInsertOptions{
+			MaxRows: 1,
+		})
+		a.Equal(affected, int64(2))
+		return err
+	}))
+	a.NilError(m.QueryScalar(&count, "SELECT COUNT(*) FROM bulk"))
+	a.Equal(count, int64(15))
+
+	// 参数错误。
+	_, err = m.BulkInsert("bulk", columns, BulkValues([][]interface{}{{1}}), nil)
+	a.NonNilError(err)
+	_, err = m.BulkInsert("bulk", nil, BulkValues(rows), nil)
+	a.NonNilError(err)
+	_, err = m.BulkInsert("bulk", columns, BulkValues(rows), &BulkInsertOptions{Mode: -1})
+	a.NonNilError(err)
+
+	affected, err = m.BulkInsert("bulk", columns, BulkValues(nil), nil)
+	a.NilError(err)
+	a.Equal(affected, int64(0))
+}
@@ -8,25 +8,133 @@ const (
 
 	// DefaultMaxIdleConns 代表默认的最大空闲连接数，当前设置为 10。
 	DefaultMaxIdleConns = 10
+
+	// DefaultSlaveCheckInterval 代表默认的从库健康检查间隔，当前设置为 5s。
+	DefaultSlaveCheckInterval time.Duration = 5 * time.Second
+
+	// DefaultDrainTimeout 代表默认的旧连接最长等待时间，当前设置为 30s。
+	DefaultDrainTimeout time.Duration = 30 * time.Second
 )
 
 // Config 代表 MySQL 的配置。
 type Config struct {
-	DSN      string `config:"dsn"`       // DSN 是 MySQL 主库的连接字符串。
-	DSNSlave string `config:"dsn_slave"` // DSNSlave 是从库的 MySQL 连接字符串，所有只读的 Query/QueryRow 都会走这个连接，默认与 DSN 相同。
+	DSN      string        `config:"dsn"`       // DSN 是 MySQL 主库的连接字符串。
+	DSNSlave string        `config:"dsn_slave"` // DSNSlave 是从库的 MySQL 连接字符串，所有只读的 Query/QueryRow 都会走这个连接，默认与 DSN 相同。
+	Slaves   []ConfigSlave `config:"slaves"`    // Slaves 是多个从库的配置，只读请求会按照权重分配到各个从库，可以与 DSNSlave 同时使用。
+
+	SlaveBalance       string        `config:"slave_balance"`        // SlaveBalance 是从库的负载均衡策略，可选 BalanceRoundRobin 和 BalanceLeastConn，默认是 BalanceRoundRobin。
+	SlaveCheckInterval time.Duration `config:"slave_check_interval"` // SlaveCheckInterval 是从库健康检查的间隔，默认是 DefaultSlaveCheckInterval，设置为负数则不检查。
+
+	MaxSlaveLag         time.Duration `config:"max_slave_lag"`         // MaxSlaveLag 是从库允许的最大复制延迟，延迟超过这个值的从库不会接收读请求，默认不检查延迟。
+	SlaveHeartbeatTable string        `config:"slave_heartbeat_table"` // SlaveHeartbeatTable 是心跳表的表名，设置后会通过表中最新的 ts 列计算延迟，否则使用 SHOW SLAVE STATUS。
 
 	Mod       int64            `config:"mod"`       // Mod 是 hash 分桶的余数，比如设置为 10 就会将 hash%10 来计算命中哪一个实例，默认不分桶。
 	Instances []ConfigInstance `config:"instances"` // Instances 是分桶后的数据库连接配置。
 
+	Sharding     string `config:"sharding"`      // Sharding 是分片策略，可选 ShardingMod、ShardingConsistentHash、ShardingRange 或者通过 RegisterShardFunc 注册的函数名，默认是 ShardingMod。
+	VirtualNodes int    `config:"virtual_nodes"` // VirtualNodes 是一致性哈希中每个实例的虚拟节点数，默认是 DefaultVirtualNodes。
+
+	Migration ConfigMigration `config:"migration"` // Migration 是迁移的目标分片配置，设置了 Migration.Instances 后进入双写迁移模式。
+
 	ConnMaxLifetime time.Duration `config:"conn_max_life_time"` // ConnMaxLifetime 设置连接的最大保持时间，默认是 DefaultConnMaxLifetime。
 	MaxIdleConns    int           `config:"max_idle_conns"`     // MaxIdleConns 设置最多保持多少个空闲连接，默认是 DefaultMaxIdleConns。
 	MaxOpenConns    int           `config:"max_open_conns"`     // MaxOpenConns 设置最大同时连接数，默认是不限制。
+
+	Retry   ConfigRetry   `config:"retry"`   // Retry 是遇到临时错误时的重试策略，默认不重试。
+	Timeout ConfigTimeout `config:"timeout"` // Timeout 是默认的超时时间，默认只受 ctx 本身的 deadline 限制。
+
+	Breaker ConfigBreaker `config:"breaker"` // Breaker 是每个实例的熔断设置，设置了 Breaker.ErrorRate 才会开启熔断。
+
+	SlowQuery ConfigSlowQuery `config:"slow_query"` // SlowQuery 是慢查询日志的设置，设置了 SlowQuery.Threshold 才会记录慢查询。
+
+	KillOnCancel bool `config:"kill_on_cancel"` // KillOnCancel 表示 ctx 结束时是否通过单独的控制连接执行 KILL QUERY 终止服务端仍在执行的语句，开启后每条语句或事务需要额外查询一次 CONNECTION_ID()，默认关闭。
+
+	StmtCacheSize        int `config:"stmt_cache_size"`         // StmtCacheSize 是主库和每个从库上最多缓存的预处理语句数，开启后 Stmt 会使用服务端预处理语句执行，默认不缓存。
+	StmtCacheMaxPrepared int `config:"stmt_cache_max_prepared"` // StmtCacheMaxPrepared 是主库和每个从库的连接池上最多的服务端预处理语句数，设置后缓存大小不超过 StmtCacheMaxPrepared / MaxOpenConns，需要同时设置 MaxOpenConns，默认不限制。
+
+	UnmappedColumns string `config:"unmapped_columns"` // UnmappedColumns 是 ScanStruct 遇到结构体中没有对应字段的列时的处理方式，可选 UnmappedColumnsIgnore、UnmappedColumnsWarn 和 UnmappedColumnsError，默认是 UnmappedColumnsIgnore。
+
+	LocalInfile bool `config:"local_infile"` // LocalInfile 表示是否允许使用 MySQL#LoadData 执行 LOAD DATA LOCAL INFILE，MySQL 服务器也需要开启 local_infile，默认关闭。
+
+	XA ConfigXA `config:"xa"` // XA 是分布式事务的设置，设置了 XA.LogDir 才能使用 Factory#BeginXA。
+
+	DrainTimeout   time.Duration `config:"drain_timeout"`   // DrainTimeout 是重新建立连接后，等待旧连接上的查询结束的最长时间，默认是 DefaultDrainTimeout。
+	ReloadInterval time.Duration `config:"reload_interval"` // ReloadInterval 是 Register 检查配置文件是否修改的间隔，修改后会自动调用 Factory#Reload，默认不检查。
 }
 
 // ConfigInstance 代表一组 MySQL 实例的连接字符串。
 type ConfigInstance struct {
-	DSN      string `config:"dsn"`       // DSN 是 MySQL 主库的连接字符串。
-	DSNSlave string `config:"dsn_slave"` // DSNSlave 是从库的 MySQL 连接字符串，所有只读的 Query/QueryRow 都会走这个连接，默认与 DSN 相同。
+	DSN      string        `config:"dsn"`       // DSN 是 MySQL 主库的连接字符串。
+	DSNSlave string        `config:"dsn_slave"` // DSNSlave 是从库的 MySQL 连接字符串，所有只读的 Query/QueryRow 都会走这个连接，默认与 DSN 相同。
+	Slaves   []ConfigSlave `config:"slaves"`    // Slaves 是多个从库的配置，只读请求会按照权重分配到各个从库，可以与 DSNSlave 同时使用。
+
+	Buckets []int64       `config:"buckets"` // Buckets 表示这个实例对应的 bucket 号，可以是多个号，比如 [0, 1, 2]，仅用于 ShardingMod。
+	Ranges  []ConfigRange `config:"ranges"`  // Ranges 表示这个实例负责的 idx 区间，可以是多个区间，仅用于 ShardingRange。
+	Name    string        `config:"name"`    // Name 是实例在一致性哈希环上的名字，默认使用 DSN 中的地址和数据库名，仅用于 ShardingConsistentHash。
+}
+
+// ConfigMigration 代表迁移的目标分片配置，各个字段的含义与 Config 中的同名字段相同。
+// 迁移期间写请求会同时写入新旧两套分片，读请求在 bucket 切换前走旧分片，切换后走新分片。
+type ConfigMigration struct {
+	Mod          int64            `config:"mod"`
+	Instances    []ConfigInstance `config:"instances"`
+	Sharding     string           `config:"sharding"`
+	VirtualNodes int              `config:"virtual_nodes"`
+
+	Buckets int64   `config:"buckets"` // Buckets 是迁移的 bucket 数，按照 idx%buckets 逐个 bucket 切换，默认与 Mod 相同。
+	Cutover []int64 `config:"cutover"` // Cutover 是已经切换到新分片的 bucket。
+}
+
+// ConfigRetry 代表遇到临时错误时的重试策略，仅对不在事务中的 MySQL 和 Stmt 以及 MySQL#Transaction 生效。
+type ConfigRetry struct {
+	MaxAttempts int           `config:"max_attempts"` // MaxAttempts 是最多执行的次数，包括第一次执行，小于等于 1 代表不重试。
+	Backoff     time.Duration `config:"backoff"`      // Backoff 是第一次重试前的等待时间，之后每次重试翻倍，默认是 DefaultRetryBackoff。
+	MaxBackoff  time.Duration `config:"max_backoff"`  // MaxBackoff 是两次重试之间最长的等待时间，默认是 DefaultRetryMaxBackoff。
+	Errors      []uint16      `config:"errors"`       // Errors 是可以重试的 MySQL 错误号，默认是 DefaultRetryErrors，连接断开总是可以重试。
+	RetryWrites bool          `config:"retry_writes"` // RetryWrites 表示是否重试 Exec，写请求在连接断开时可能已经执行成功，默认只重试读请求。
+
+	TxMaxAttempts int `config:"tx_max_attempts"` // TxMaxAttempts 是 MySQL#Transaction 遇到死锁时最多执行的次数，默认是 DefaultTxMaxAttempts，设置为 1 代表不重试。
+}
+
+// ConfigTimeout 代表默认的超时时间，实际的超时时间是这里的设置与 ctx 本身的 deadline 中较早的一个。
+type ConfigTimeout struct {
+	Read  time.Duration `config:"read"`  // Read 是 Query/QueryRow 每条语句默认的超时时间，包括重试的时间，可以通过 WithQueryTimeout 修改。
+	Write time.Duration `config:"write"` // Write 是 Exec 每条语句默认的超时时间，包括重试的时间，可以通过 WithQueryTimeout 修改。
+	Tx    time.Duration `config:"tx"`    // Tx 是事务从开始到提交的最长时间，超时后事务会被回滚。
+}
+
+// ConfigBreaker 代表每个实例的熔断设置，Config.Instances 中的每个实例以及默认的 DSN 都有独立的熔断器。
+// 熔断只对不在事务中的 Query/QueryRow/Exec 和 BeginTx 生效，已经开始的事务不受影响。
+type ConfigBreaker struct {
+	ErrorRate   float64       `config:"error_rate"`   // ErrorRate 是熔断的失败率阈值，取值范围是 (0, 1]，统计窗口内的失败率达到这个值时熔断，默认不熔断。
+	Latency     time.Duration `config:"latency"`      // Latency 是慢请求的阈值，执行时间超过这个值的请求也算作失败，默认不检查执行时间。
+	Window      time.Duration `config:"window"`       // Window 是统计失败率的窗口，默认是 DefaultBreakerWindow。
+	MinRequests int           `config:"min_requests"` // MinRequests 是统计窗口内最少的请求数，请求太少时不熔断，默认是 DefaultBreakerMinRequests。
+	OpenTimeout time.Duration `config:"open_timeout"` // OpenTimeout 是熔断后经过多久进入半开状态开始探测，默认是 DefaultBreakerOpenTimeout。
+	Probes      int           `config:"probes"`       // Probes 是半开状态下放行的探测请求数，全部成功后恢复，默认是 DefaultBreakerProbes。
+}
+
+// ConfigSlowQuery 代表慢查询日志的设置，Config.Instances 中的每个实例以及默认的 DSN 都分别统计。
+type ConfigSlowQuery struct {
+	Threshold   time.Duration `config:"threshold"`    // Threshold 是慢查询的阈值，执行时间超过这个值的语句会以 warn 级别记录日志，参数不会出现在日志中，默认不记录。
+	ExplainRate float64       `config:"explain_rate"` // ExplainRate 是对慢 SELECT 执行 EXPLAIN 的采样率，取值范围是 [0, 1]，EXPLAIN 会在后台使用从库执行，默认不执行。
+	TopN        int           `config:"top_n"`        // TopN 是每个实例在内存中保留的最慢的语句数，可以通过 Factory#SlowQueries 查询，默认是 DefaultSlowQueryTopN。
+}
+
+// ConfigXA 代表 XA 分布式事务的设置。
+type ConfigXA struct {
+	LogDir string `config:"log_dir"` // LogDir 是恢复日志的目录，记录所有已经决定提交的 XA 事务，服务重启后据此处理悬挂的事务，每个进程必须使用独立的目录。
+	Node   string `config:"node"`    // Node 是当前节点的名字，会写入 XA 事务的 xid，恢复时只处理本节点发起的事务，每个进程必须不同，默认是 LogDir 中保存的随机名字。
+}
+
+// ConfigRange 代表一个左闭右开的 idx 区间 [Start, End)。
+type ConfigRange struct {
+	Start int64 `config:"start"` // Start 是区间的起点，包含在区间内。
+	End   int64 `config:"end"`   // End 是区间的终点，不包含在区间内。
+}
 
-	Buckets []int64 `config:"buckets"` // Buckets 表示这个实例对应的 bucket 号，可以是多个号，比如 [0, 1, 2]。
+// ConfigSlave 代表一个从库的配置。
+type ConfigSlave struct {
+	DSN    string `config:"dsn"`    // DSN 是从库的 MySQL 连接字符串。
+	Weight int    `config:"weight"` // Weight 是从库的权重，默认是 1。
 }
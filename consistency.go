@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+// writeTracker 记录一个 ctx 中写过哪些主库，用于实现读己之写。
+type writeTracker struct {
+	window time.Duration
+	gtid   bool
+
+	mu     sync.Mutex
+	writes map[*dbInstance]*writeMark
+}
+
+type writeMark struct {
+	At      time.Time
+	GTID    string          // GTID 是写入后主库的 gtid_executed，仅在 GTID 模式下使用。
+	Applied map[*slave]bool // Applied 记录已经确认执行过 GTID 的从库，避免重复检查。
+}
+
+func newWriteTracker(window time.Duration, gtid bool) *writeTracker {
+	return &writeTracker{
+		window: window,
+		gtid:   gtid,
+		writes: make(map[*dbInstance]*writeMark),
+	}
+}
+
+// Wrote 记录 ins 的主库刚刚完成了一次写入。
+func (t *writeTracker) Wrote(ctx context.Context, ins *dbInstance) {
+	mark := &writeMark{
+		At: time.Now(),
+	}
+
+	if t.gtid {
+		// 拿不到 GTID 时 mark.GTID 为空，后续读请求都会走主库，保证不会读到旧数据。
+		if err := ins.Master.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_executed").Scan(&mark.GTID); err != nil {
+			log.Errorf(ctx, "err=%v||go-mysql: fail to read gtid_executed from master", err)
+		}
+
+		mark.Applied = make(map[*slave]bool)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writes[ins] = mark
+}
+
+// Readable 判断从库 s 是否已经能读到这个 ctx 在 ins 上写入的数据。
+func (t *writeTracker) Readable(ctx context.Context, ins *dbInstance, s *slave) bool {
+	t.mu.Lock()
+	mark := t.writes[ins]
+
+	if mark == nil {
+		t.mu.Unlock()
+		return true
+	}
+
+	if !t.gtid {
+		defer t.mu.Unlock()
+
+		if t.window <= 0 || time.Since(mark.At) < t.window {
+			return false
+		}
+
+		delete(t.writes, ins)
+		return true
+	}
+
+	gtid := mark.GTID
+	applied := mark.Applied[s]
+	t.mu.Unlock()
+
+	if applied {
+		return true
+	}
+
+	if gtid == "" {
+		return false
+	}
+
+	// 查询期间不持有锁，查询结束后需要确认这期间没有新的写入。
+	if err := s.DB.QueryRowContext(ctx, "SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)", gtid).Scan(&applied); err != nil {
+		log.Errorf(ctx, "err=%v||slave=%v||go-mysql: fail to check gtid on slave", err, s.Name)
+		return false
+	}
+
+	if !applied {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if mark == t.writes[ins] {
+		mark.Applied[s] = true
+	}
+
+	return true
+}
@@ -0,0 +1,94 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/huandu/go-assert"
+)
+
+func TestReadYourWritesWindow(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN:                memdb.Scheme + testDB,
+		DSNSlave:           memdb.Scheme + testDB + "_slave1",
+		SlaveCheckInterval: -1,
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+
+	conn := f.conn()
+	master, slave := conn.Master, conn.Slaves.slaves[0].DB
+
+	// 没有开启读己之写时，写入后依然读从库。
+	m := f.New(context.Background())
+	a.NilError(m.Exec("CREATE TABLE t (id INT PRIMARY KEY)"))
+	a.Equal(m.db(false), slave)
+
+	ctx := WithReadYourWrites(context.Background(), 50*time.Millisecond)
+	m = f.New(ctx)
+	a.Equal(m.db(false), slave)
+	a.NilError(m.Exec("INSERT INTO t VALUES (1)"))
+	a.Equal(m.db(false), master)
+	a.Equal(m.UseMaster().db(false), master)
+
+	time.Sleep(60 * time.Millisecond)
+	a.Equal(m.db(false), slave)
+
+	// 只读事务和只设置过 SAVEPOINT 的事务提交后依然读从库。
+	ctx = WithReadYourWrites(context.Background(), 0)
+	m = f.New(ctx)
+	var cnt int
+	a.NilError(m.Transaction(nil, func(tx *Tx) error {
+		return tx.Transaction(func(nested *Tx) error {
+			return nested.QueryScalar(&cnt, "SELECT COUNT(*) FROM t")
+		})
+	}))
+	a.Equal(m.db(false), slave)
+
+	// window 为 0 时，事务提交后一直读主库。
+	tx, err := m.BeginTx(nil)
+	a.NilError(err)
+	a.NilError(tx.Exec("INSERT INTO t VALUES (2)"))
+	a.Equal(m.db(false), slave)
+	a.NilError(tx.Commit())
+	a.Equal(m.db(false), master)
+}
+
+func TestReadYourWritesGTID(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	memdb.Drop(testDB + "_slave1")
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		Slaves: []ConfigSlave{
+			// 与主库同名的内存数据库共享数据，相当于一个没有延迟的从库。
+			{DSN: memdb.Scheme + testDB},
+			{DSN: memdb.Scheme + testDB + "_slave1"},
+		},
+		SlaveCheckInterval: -1,
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+
+	conn := f.conn()
+	master := conn.Master
+	synced, lagged := conn.Slaves.slaves[0], conn.Slaves.slaves[1]
+
+	ctx := WithReadYourWritesGTID(context.Background())
+	m := f.New(ctx)
+	a.NilError(m.Exec("CREATE TABLE t (id INT PRIMARY KEY)"))
+
+	tracker := writeTrackerFromContext(ctx)
+	a.Assert(tracker.Readable(ctx, &conn.dbInstance, synced))
+	a.Assert(!tracker.Readable(ctx, &conn.dbInstance, lagged))
+
+	for i := 0; i < 10; i++ {
+		db := m.db(false)
+		a.Assert(db == synced.DB || db == master)
+		a.Assert(db != lagged.DB)
+	}
+}
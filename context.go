@@ -1,10 +1,19 @@
 package mysql
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type mysqlIndex struct{}
+type mysqlWriteTracker struct{}
+type mysqlTx struct{}
+type mysqlQueryTimeout struct{}
 
 var keyMySQLIndex mysqlIndex
+var keyMySQLWriteTracker mysqlWriteTracker
+var keyMySQLTx mysqlTx
+var keyMySQLQueryTimeout mysqlQueryTimeout
 
 // WithIndex 在 ctx 中设置 idx，用来选择使用哪个 MySQL 实例。
 func WithIndex(ctx context.Context, idx int64) context.Context {
@@ -22,3 +31,65 @@ func indexFromContext(ctx context.Context) (idx int64, ok bool) {
 	ok = true
 	return
 }
+
+// WithQueryTimeout 设置通过 ctx 执行的每条语句的超时时间，覆盖 Config.Timeout 中默认的读写超时。
+// 如果 timeout 小于等于 0，则不使用默认的超时时间，只受 ctx 本身的 deadline 限制。
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, keyMySQLQueryTimeout, timeout)
+}
+
+func queryTimeoutFromContext(ctx context.Context) (timeout time.Duration, ok bool) {
+	v := ctx.Value(keyMySQLQueryTimeout)
+
+	if v == nil {
+		return
+	}
+
+	timeout = v.(time.Duration)
+	ok = true
+	return
+}
+
+// WithReadYourWrites 在 ctx 中开启读己之写模式。
+// 开启后，一旦通过这个 ctx 写过主库（`MySQL#Exec` 或者 `Tx#Commit` 成功），
+// 之后 window 时间内的所有读请求都会走主库，避免因为从库复制延迟读不到刚写入的数据。
+// 如果 window 小于等于 0，写入后这个 ctx 的所有读请求都会走主库。
+func WithReadYourWrites(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, keyMySQLWriteTracker, newWriteTracker(window, false))
+}
+
+// WithReadYourWritesGTID 在 ctx 中开启基于 GTID 的读己之写模式。
+// 开启后，每次写主库成功后都会记录主库的 gtid_executed，
+// 之后的读请求只会发给已经执行完这些 GTID 的从库，如果选中的从库还没有追上，则改走主库。
+// 这个模式需要主从都开启 GTID，每次写入和读取会额外产生一次查询。
+func WithReadYourWritesGTID(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyMySQLWriteTracker, newWriteTracker(0, true))
+}
+
+func writeTrackerFromContext(ctx context.Context) *writeTracker {
+	v := ctx.Value(keyMySQLWriteTracker)
+
+	if v == nil {
+		return nil
+	}
+
+	return v.(*writeTracker)
+}
+
+// WithTx 在 ctx 中绑定事务 tx。
+// 之后通过这个 ctx 调用 New 或者 Factory#New 得到的 MySQL 实例会在 tx 中执行所有的读写请求，
+// 调用 MySQL#Transaction 会开启嵌套事务。这些请求使用的是 tx 自身的 ctx。
+// 只有 ctx 选中的实例与 tx 所在的实例相同时才会使用 tx，否则依然返回普通的 MySQL 实例。
+func WithTx(ctx context.Context, tx *Tx) context.Context {
+	return context.WithValue(ctx, keyMySQLTx, tx)
+}
+
+func txFromContext(ctx context.Context) *Tx {
+	v := ctx.Value(keyMySQLTx)
+
+	if v == nil {
+		return nil
+	}
+
+	return v.(*Tx)
+}
@@ -0,0 +1,225 @@
+package mysql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// convertAssign 将查询出来的值 src 设置到 dest 中，规则与 database/sql 的 Rows#Scan 一致，
+// 只是 *sql.RawBytes 会得到一份拷贝，不需要在下一次 Scan 之前用完。
+// src 只可能是 nil、int64、float64、bool、[]byte、string 或 time.Time。
+func convertAssign(dest, src interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+
+	switch d := dest.(type) {
+	case *interface{}:
+		if b, ok := src.([]byte); ok {
+			src = append([]byte(nil), b...)
+		}
+
+		*d = src
+		return nil
+
+	case *string:
+		switch s := src.(type) {
+		case nil:
+			return errors.New("go-mysql: converting NULL to string is unsupported")
+		case string:
+			*d = s
+		case []byte:
+			*d = string(s)
+		case time.Time:
+			*d = s.Format(time.RFC3339Nano)
+		default:
+			*d = asString(src)
+		}
+
+		return nil
+
+	case *[]byte:
+		*d = asBytes(src)
+		return nil
+
+	case *sql.RawBytes:
+		*d = asBytes(src)
+		return nil
+
+	case *time.Time:
+		if t, ok := src.(time.Time); ok {
+			*d = t
+			return nil
+		}
+
+	case *bool:
+		v, err := driver.Bool.ConvertValue(src)
+
+		if err != nil {
+			return fmt.Errorf("go-mysql: fail to convert %#v to bool: %v", src, err)
+		}
+
+		*d = v.(bool)
+		return nil
+	}
+
+	dv := reflect.ValueOf(dest)
+
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return errors.New("go-mysql: destination is not a non-nil pointer")
+	}
+
+	dv = dv.Elem()
+	sv := reflect.ValueOf(src)
+
+	// 类型可以直接赋值或者只是类型名不同的值直接设置，比如 json.RawMessage、time.Duration 这类自定义类型。
+	if sv.IsValid() && sv.Type().AssignableTo(dv.Type()) {
+		if b, ok := src.([]byte); ok {
+			sv = reflect.ValueOf(append([]byte(nil), b...))
+		}
+
+		dv.Set(sv)
+		return nil
+	}
+
+	if sv.IsValid() && dv.Kind() == sv.Kind() && sv.Type().ConvertibleTo(dv.Type()) {
+		dv.Set(sv.Convert(dv.Type()))
+		return nil
+	}
+
+	if dv.Kind() == reflect.Ptr {
+		if src == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+
+		dv.Set(reflect.New(dv.Type().Elem()))
+		return convertAssign(dv.Interface(), src)
+	}
+
+	switch dv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		if src == nil {
+			return fmt.Errorf("go-mysql: converting NULL to %v is unsupported", dv.Kind())
+		}
+	}
+
+	s := asString(src)
+
+	switch dv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, dv.Type().Bits())
+
+		if err != nil {
+			return fmt.Errorf("go-mysql: fail to convert %#v to %v: %v", s, dv.Kind(), err)
+		}
+
+		dv.SetInt(i)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, dv.Type().Bits())
+
+		if err != nil {
+			return fmt.Errorf("go-mysql: fail to convert %#v to %v: %v", s, dv.Kind(), err)
+		}
+
+		dv.SetUint(u)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, dv.Type().Bits())
+
+		if err != nil {
+			return fmt.Errorf("go-mysql: fail to convert %#v to %v: %v", s, dv.Kind(), err)
+		}
+
+		dv.SetFloat(f)
+		return nil
+
+	case reflect.String:
+		// 与 database/sql 一样，只有字符串可以设置到自定义的字符串类型里。
+		if b, ok := src.([]byte); ok {
+			dv.SetString(string(b))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("go-mysql: unsupported Scan, storing %T into type %T", src, dest)
+}
+
+// asBytes 将 src 转化成一份新的 []byte，NULL 会转化成 nil。
+func asBytes(src interface{}) []byte {
+	switch s := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return append([]byte(nil), s...)
+	case string:
+		return []byte(s)
+	}
+
+	return []byte(asString(src))
+}
+
+func asString(src interface{}) string {
+	switch v := src.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprint(src)
+}
+
+// isIntegerType 判断 typeName 是否是整数类型，typeName 是 sql.ColumnType#DatabaseTypeName 的返回值。
+func isIntegerType(typeName string) bool {
+	switch baseTypeName(typeName) {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT":
+		return true
+	}
+
+	return false
+}
+
+// isNumericType 判断 typeName 是否是数字类型，包括整数、定点数和浮点数。
+func isNumericType(typeName string) bool {
+	if isIntegerType(typeName) {
+		return true
+	}
+
+	switch baseTypeName(typeName) {
+	case "DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL":
+		return true
+	}
+
+	return false
+}
+
+// baseTypeName 去掉 typeName 中的 UNSIGNED 和长度等修饰，返回大写的类型名，比如 "UNSIGNED BIGINT" 返回 "BIGINT"。
+func baseTypeName(typeName string) string {
+	typeName = strings.TrimPrefix(strings.ToUpper(typeName), "UNSIGNED ")
+
+	if idx := strings.IndexAny(typeName, "( "); idx >= 0 {
+		typeName = typeName[:idx]
+	}
+
+	return typeName
+}
@@ -0,0 +1,92 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/huandu/go-assert"
+)
+
+type testName string
+type testFlag bool
+
+// TestConvertAssign 确认 convertAssign 与 database/sql 的 Rows#Scan 结果一致。
+func TestConvertAssign(t *testing.T) {
+	a := assert.New(t)
+	f := memFactory(t)
+	defer f.Close()
+	ctx := context.Background()
+	db := f.conn().Master
+
+	srcs := []interface{}{
+		nil,
+		int64(1),
+		int64(-1),
+		int64(300),
+		int64(1 << 40),
+		float64(1.5),
+		float64(1e300),
+		[]byte("42"),
+		[]byte("-1"),
+		[]byte("300"),
+		[]byte("1.5"),
+		[]byte("abc"),
+		time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+	dests := []func() interface{}{
+		func() interface{} { return new(int8) },
+		func() interface{} { return new(int64) },
+		func() interface{} { return new(uint8) },
+		func() interface{} { return new(uint64) },
+		func() interface{} { return new(float32) },
+		func() interface{} { return new(float64) },
+		func() interface{} { return new(bool) },
+		func() interface{} { return new(string) },
+		func() interface{} { return new([]byte) },
+		func() interface{} { return new(sql.RawBytes) },
+		func() interface{} { return new(interface{}) },
+		func() interface{} { return new(*int64) },
+		func() interface{} { return new(*string) },
+		func() interface{} { return new(time.Time) },
+		func() interface{} { return new(time.Duration) },
+		func() interface{} { return new(json.RawMessage) },
+		func() interface{} { return new(testName) },
+		func() interface{} { return new(testFlag) },
+		func() interface{} { return new(sql.NullString) },
+		func() interface{} { return new(sql.NullInt64) },
+		func() interface{} { return new(sql.NullFloat64) },
+		func() interface{} { return new(sql.NullBool) },
+	}
+
+	for _, arg := range srcs {
+		for _, newDest := range dests {
+			expected := newDest()
+			actual := newDest()
+			c := struct {
+				Src  interface{}
+				Dest string
+			}{arg, fmt.Sprintf("%T", expected)}
+			a.Use(&c)
+
+			rows, err := db.QueryContext(ctx, "SELECT ?, ?", arg, arg)
+			a.NilError(err)
+			a.Assert(rows.Next())
+
+			var src interface{}
+			expectedErr := rows.Scan(&src, expected)
+			actualErr := convertAssign(actual, src)
+			a.Equal(actualErr == nil, expectedErr == nil)
+
+			if expectedErr == nil {
+				a.Equal(reflect.ValueOf(actual).Elem().Interface(), reflect.ValueOf(expected).Elem().Interface())
+			}
+
+			a.NilError(rows.Close())
+		}
+	}
+}
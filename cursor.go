@@ -0,0 +1,352 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+// DefaultCursorBatchSize 是 Cursor 每一批默认查询的行数。
+const DefaultCursorBatchSize = 1000
+
+// cursorRetry 是 Cursor 查询每一批数据时的重试策略，只在连接断开时重试一次。
+var cursorRetry = &retryPolicy{
+	MaxAttempts: 2,
+	Backoff:     DefaultRetryBackoff,
+	MaxBackoff:  DefaultRetryMaxBackoff,
+	ConnErrors:  true,
+}
+
+// CursorOptions 是 Cursor 的查询条件。
+//
+// Table、Columns 和 Key 会原样拼接到 SQL 里，不能使用外部输入的数据。
+type CursorOptions struct {
+	Table   string        // Table 是需要遍历的表名，必填。
+	Columns []string      // Columns 是需要查询的列，默认是 *，结果中必须包含 Key 这一列。
+	Key     string        // Key 是用来分页的列，必须是唯一且有索引的列，默认是 id。
+	Where   string        // Where 是额外的查询条件，比如 "status = ?"，可以为空。
+	Args    []interface{} // Args 是 Where 中的参数。
+	Desc    bool          // Desc 表示按照 Key 从大到小遍历，默认从小到大。
+	Start   interface{}   // Start 是开始遍历的位置（不含），可以用 CursorProgress.LastKey 从上次中断的位置继续遍历。
+
+	BatchSize int           // BatchSize 是每一批查询的行数，默认是 DefaultCursorBatchSize。
+	Interval  time.Duration // Interval 是两批查询之间的等待时间，用于降低对数据库的压力，默认不等待。
+}
+
+// CursorProgress 是 Cursor 当前的遍历进度。
+type CursorProgress struct {
+	Batches int         // Batches 是已经查询的批数。
+	Rows    int         // Rows 是已经通过 Next 遍历的行数。
+	LastKey interface{} // LastKey 是最后一行 Key 的值，整数列是 int64（超出 int64 范围的 BIGINT UNSIGNED 是 uint64），其他列的 []byte 会转化成 string。
+}
+
+// Cursor 按照主键分批遍历一张表，适合遍历大量数据。
+//
+// 每一批数据都会完整读取到内存里，读取完成后立即释放连接，
+// 因此遍历过程中处理数据的速度不会影响数据库连接，也不会长时间占用连接。
+// 查询某一批数据时如果连接断开会自动重新查询一次。
+type Cursor struct {
+	ctx   context.Context
+	mysql *MySQL
+	opts  CursorOptions
+
+	columns []string
+	keyIdx  int
+	keyType string // keyType 是 Key 这一列的数据库类型，比如 BIGINT。
+	rows    [][]interface{}
+	current int
+	eof     bool
+	err     error
+
+	progress CursorProgress
+	mapping  *structMapping
+}
+
+// Cursor 创建一个按照 opts.Key 分批遍历 opts.Table 的 Cursor，典型用法如下。
+//
+//	cursor, err := mysql.Cursor(&CursorOptions{Table: "user", BatchSize: 500})
+//
+//	if err != nil {
+//	    return err
+//	}
+//
+//	for cursor.Next() {
+//	    var u User
+//
+//	    if err := cursor.ScanStruct(&u); err != nil {
+//	        return err
+//	    }
+//
+//	    // 处理 u。
+//	}
+//
+//	return cursor.Err()
+func (mysql *MySQL) Cursor(opts *CursorOptions) (*Cursor, error) {
+	if opts == nil || opts.Table == "" {
+		return nil, errors.New("go-mysql: table is required by cursor")
+	}
+
+	c := &Cursor{
+		ctx:   mysql.ctx,
+		mysql: mysql,
+		opts:  *opts,
+	}
+
+	if c.opts.Key == "" {
+		c.opts.Key = "id"
+	}
+
+	if c.opts.BatchSize <= 0 {
+		c.opts.BatchSize = DefaultCursorBatchSize
+	}
+
+	c.progress.LastKey = c.opts.Start
+	return c, nil
+}
+
+// Next 移动到下一行，当前这一批遍历完后会查询下一批。如果已经没有更多结果或者出错，返回 false。
+func (c *Cursor) Next() bool {
+	if c.err != nil {
+		return false
+	}
+
+	if c.current >= len(c.rows) {
+		if c.eof || !c.fetch() {
+			return false
+		}
+	}
+
+	row := c.rows[c.current]
+	key, err := cursorKey(c.keyType, row[c.keyIdx])
+
+	if err != nil {
+		c.err = err
+		return false
+	}
+
+	c.current++
+	c.progress.Rows++
+	c.progress.LastKey = key
+	return true
+}
+
+// cursorKey 将 Key 这一列的值转化成下一批查询的参数。
+//
+// 没有参数的第一批查询使用文本协议，所有值都是 []byte，直接作为参数会被当成字符串，
+// 而 MySQL 会将整数列与字符串按照浮点数比较，超过 2^53 的值会丢失精度，导致分页时跳过或者重复一些行。
+// 因此整数列的值需要转化成 int64 或者 uint64，其他列的 []byte 转化成 string。
+func cursorKey(typeName string, v interface{}) (interface{}, error) {
+	b, ok := v.([]byte)
+
+	if !ok {
+		return v, nil
+	}
+
+	if !isIntegerType(typeName) {
+		return string(b), nil
+	}
+
+	s := string(b)
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+
+	u, err := strconv.ParseUint(s, 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("go-mysql: fail to parse cursor key %#v as %v: %v", s, typeName, err)
+	}
+
+	return u, nil
+}
+
+// fetch 查询下一批数据，如果已经没有更多结果或者出错，返回 false。
+func (c *Cursor) fetch() bool {
+	if c.progress.Batches > 0 && c.opts.Interval > 0 {
+		timer := time.NewTimer(c.opts.Interval)
+		defer timer.Stop()
+
+		select {
+		case <-c.ctx.Done():
+			c.err = c.ctx.Err()
+			return false
+		case <-timer.C:
+		}
+	}
+
+	if err := c.ctx.Err(); err != nil {
+		c.err = err
+		return false
+	}
+
+	query, args := c.buildQuery()
+	var columns []string
+	var types []*sql.ColumnType
+	var rows [][]interface{}
+
+	err := cursorRetry.Do(c.ctx, false, query, func() (err error) {
+		rs, err := c.mysql.Query(query, args...)
+
+		if err != nil {
+			return
+		}
+
+		if types, err = rs.ColumnTypes(); err != nil {
+			rs.Close()
+			return
+		}
+
+		columns, rows, err = readValues(rs)
+		return
+	})
+
+	if err != nil {
+		log.Errorf(c.ctx, "err=%v||sql=%v||last_key=%v||go-mysql: fail to fetch cursor batch", err, query, c.progress.LastKey)
+		c.err = err
+		return false
+	}
+
+	if c.columns == nil {
+		c.keyIdx = -1
+
+		for i, col := range columns {
+			if strings.EqualFold(col, c.opts.Key) {
+				c.keyIdx = i
+				break
+			}
+		}
+
+		if c.keyIdx < 0 {
+			c.err = fmt.Errorf("go-mysql: key column %v is not found in cursor result", c.opts.Key)
+			return false
+		}
+
+		c.columns = columns
+		c.keyType = types[c.keyIdx].DatabaseTypeName()
+	}
+
+	c.rows = rows
+	c.current = 0
+	c.eof = len(rows) < c.opts.BatchSize
+	c.progress.Batches++
+	statsForCursor(c.ctx, c.opts.Table, len(rows))
+	return len(rows) > 0
+}
+
+func (c *Cursor) buildQuery() (query string, args []interface{}) {
+	buf := &strings.Builder{}
+	buf.WriteString("SELECT ")
+
+	if len(c.opts.Columns) == 0 {
+		buf.WriteString("*")
+	} else {
+		buf.WriteString(strings.Join(c.opts.Columns, ", "))
+	}
+
+	buf.WriteString(" FROM ")
+	buf.WriteString(c.opts.Table)
+	var conds []string
+
+	if c.opts.Where != "" {
+		conds = append(conds, "("+c.opts.Where+")")
+		args = append(args, c.opts.Args...)
+	}
+
+	if c.progress.LastKey != nil {
+		op := " > ?"
+
+		if c.opts.Desc {
+			op = " < ?"
+		}
+
+		conds = append(conds, c.opts.Key+op)
+		args = append(args, c.progress.LastKey)
+	}
+
+	if len(conds) > 0 {
+		buf.WriteString(" WHERE ")
+		buf.WriteString(strings.Join(conds, " AND "))
+	}
+
+	buf.WriteString(" ORDER BY ")
+	buf.WriteString(c.opts.Key)
+
+	if c.opts.Desc {
+		buf.WriteString(" DESC")
+	}
+
+	fmt.Fprintf(buf, " LIMIT %v", c.opts.BatchSize)
+	return buf.String(), args
+}
+
+// Columns 返回所有列名，必须在 Next 返回 true 之后调用。
+func (c *Cursor) Columns() []string {
+	return c.columns
+}
+
+// Scan 将当前这一行设置到 dest 里面，规则与 Rows#Scan 一致。
+func (c *Cursor) Scan(dest ...interface{}) error {
+	if c.current == 0 {
+		return errors.New("go-mysql: Scan called without calling Next")
+	}
+
+	row := c.rows[c.current-1]
+
+	if len(dest) != len(row) {
+		return fmt.Errorf("go-mysql: expected %v destination arguments in Scan, not %v", len(row), len(dest))
+	}
+
+	for i, v := range row {
+		if err := convertAssign(dest[i], v); err != nil {
+			return fmt.Errorf("go-mysql: fail to scan column %v: %v", c.columns[i], err)
+		}
+	}
+
+	return nil
+}
+
+// ScanStruct 将当前这一行按照列名设置到 dest 指向的结构体里，规则与 Rows#ScanStruct 一致。
+func (c *Cursor) ScanStruct(dest interface{}) (err error) {
+	if c.current == 0 {
+		return errors.New("go-mysql: ScanStruct called without calling Next")
+	}
+
+	if c.mapping == nil || c.mapping.typ != reflect.TypeOf(dest) {
+		if c.mapping, err = newColumnsMapping(c.ctx, c.columns, dest, c.mysql.ins.Unmapped); err != nil {
+			return
+		}
+	}
+
+	row := c.rows[c.current-1]
+	targets := c.mapping.Targets(dest)
+
+	for i, v := range row {
+		if c.mapping.fields[i] == nil {
+			continue
+		}
+
+		if err = convertAssign(targets[i], v); err != nil {
+			return fmt.Errorf("go-mysql: fail to scan column %v: %v", c.columns[i], err)
+		}
+	}
+
+	return nil
+}
+
+// Err 返回遍历过程中遇到的错误。
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Progress 返回当前的遍历进度。
+func (c *Cursor) Progress() CursorProgress {
+	return c.progress
+}
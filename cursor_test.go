@@ -0,0 +1,168 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+func TestCursor(t *testing.T) {
+	a := assert.New(t)
+	f := memFactory(t)
+	defer f.Close()
+	ctx := context.Background()
+	m := initTable(ctx, t, f, "cursor", "id BIGINT PRIMARY KEY", "name VARCHAR(16) NOT NULL", "score INT NOT NULL")
+
+	for id := int64(1); id <= 10; id++ {
+		a.NilError(m.Exec("INSERT INTO cursor VALUES (?, ?, ?)", id, "n", id*10))
+	}
+
+	// 按照 id 分批遍历。
+	cursor, err := m.Cursor(&CursorOptions{
+		Table:     "cursor",
+		Columns:   []string{"id", "score"},
+		Where:     "score >= ?",
+		Args:      []interface{}{20},
+		BatchSize: 3,
+	})
+	a.NilError(err)
+	var ids []int64
+
+	for cursor.Next() {
+		var id, score int64
+		a.NilError(cursor.Scan(&id, &score))
+		a.Equal(score, id*10)
+		ids = append(ids, id)
+	}
+
+	a.NilError(cursor.Err())
+	a.Equal(ids, []int64{2, 3, 4, 5, 6, 7, 8, 9, 10})
+	progress := cursor.Progress()
+	a.Equal(progress.Batches, 4)
+	a.Equal(progress.Rows, 9)
+	a.Equal(progress.LastKey, int64(10))
+
+	// 从上次的位置倒序遍历，并映射到结构体。
+	cursor, err = m.Cursor(&CursorOptions{
+		Table:     "cursor",
+		Desc:      true,
+		Start:     int64(5),
+		BatchSize: 2,
+	})
+	a.NilError(err)
+	var scores []int64
+
+	for cursor.Next() {
+		var s TestScanScore
+		a.NilError(cursor.ScanStruct(&s))
+		scores = append(scores, s.Score)
+	}
+
+	a.NilError(cursor.Err())
+	a.Equal(scores, []int64{40, 30, 20, 10})
+	a.Equal(cursor.Progress().Batches, 3)
+
+	// 连接断开时重新查询一次。
+	cursor, err = m.Cursor(&CursorOptions{
+		Table:     "cursor",
+		BatchSize: 4,
+	})
+	a.NilError(err)
+	a.Assert(cursor.Next())
+	memdb.Inject(testDB, 1, mysql.ErrInvalidConn)
+	ids = nil
+
+	for cursor.Next() {
+		var row struct {
+			ID int64 `db:"id"`
+		}
+		a.NilError(cursor.ScanStruct(&row))
+		ids = append(ids, row.ID)
+	}
+
+	a.NilError(cursor.Err())
+	a.Equal(ids, []int64{2, 3, 4, 5, 6, 7, 8, 9, 10})
+
+	// 连续断开两次则返回错误。
+	cursor, err = m.Cursor(&CursorOptions{
+		Table: "cursor",
+	})
+	a.NilError(err)
+	memdb.Inject(testDB, 2, mysql.ErrInvalidConn)
+	a.Assert(!cursor.Next())
+	a.Equal(cursor.Err(), mysql.ErrInvalidConn)
+
+	// Key 必须在结果中。
+	cursor, err = m.Cursor(&CursorOptions{
+		Table:   "cursor",
+		Columns: []string{"name"},
+	})
+	a.NilError(err)
+	a.Assert(!cursor.Next())
+	a.NonNilError(cursor.Err())
+
+	_, err = m.Cursor(&CursorOptions{})
+	a.NonNilError(err)
+}
+
+func TestCursorLargeKey(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+
+	// 与 go-sql-driver 一样，没有参数的第一批查询使用文本协议，返回的 Key 是 []byte。
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB + "?textProtocol=true",
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+	ctx := context.Background()
+	m := initTable(ctx, t, f, "cursor_large_key", "id BIGINT PRIMARY KEY", "name VARCHAR(16) NOT NULL")
+
+	// 超过 2^53 的 id 按照浮点数比较会丢失精度，9007199254740995 会被当成 9007199254740996。
+	const base = int64(1<<53 + 1)
+	var expected []int64
+
+	for id := base; id < base+6; id++ {
+		a.NilError(m.Exec("INSERT INTO cursor_large_key VALUES (?, ?)", id, "n"))
+		expected = append(expected, id)
+	}
+
+	cursor, err := m.Cursor(&CursorOptions{
+		Table:     "cursor_large_key",
+		BatchSize: 3,
+	})
+	a.NilError(err)
+	var ids []int64
+
+	for cursor.Next() {
+		var id int64
+		var name string
+		a.NilError(cursor.Scan(&id, &name))
+		ids = append(ids, id)
+	}
+
+	a.NilError(cursor.Err())
+	a.Equal(ids, expected)
+	a.Equal(cursor.Progress().LastKey, base+5)
+
+	// 字符串列的 Key 不会以 []byte 的形式暴露给调用者。
+	cursor, err = m.Cursor(&CursorOptions{
+		Table:   "cursor_large_key",
+		Columns: []string{"name"},
+		Key:     "name",
+	})
+	a.NilError(err)
+	a.Assert(cursor.Next())
+	a.Equal(cursor.Progress().LastKey, "n")
+
+	a.Assert(isIntegerType("BIGINT"))
+	a.Assert(isIntegerType("UNSIGNED BIGINT"))
+	a.Assert(isIntegerType("int(11)"))
+	a.Assert(!isIntegerType("DECIMAL"))
+	key, err := cursorKey("UNSIGNED BIGINT", []byte("18446744073709551615"))
+	a.NilError(err)
+	a.Equal(key, uint64(18446744073709551615))
+}
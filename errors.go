@@ -0,0 +1,129 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 常见的错误分类，MySQL、Tx、Row 和 Stmt 返回的错误都可以通过 Is 或者对应的 IsXxx 函数判断属于哪一类，
+// 不需要自己断言 *mysql.MySQLError 并比较错误号。
+var (
+	ErrDuplicateKey    = errors.New("go-mysql: duplicate key")       // ErrDuplicateKey 代表主键或唯一索引冲突（1062、1022、1586）。
+	ErrDeadlock        = errors.New("go-mysql: deadlock")            // ErrDeadlock 代表死锁（1213）。
+	ErrLockWaitTimeout = errors.New("go-mysql: lock wait timeout")   // ErrLockWaitTimeout 代表锁等待超时（1205）。
+	ErrReadOnly        = errors.New("go-mysql: server is read-only") // ErrReadOnly 代表服务器或事务是只读的（1290、1792、1836），一般是写请求发到了从库或者正在主从切换。
+	ErrConnectionLost  = errors.New("go-mysql: connection is lost")  // ErrConnectionLost 代表连接断开，比如 MySQL server has gone away。
+	ErrNoRows          = sql.ErrNoRows                               // ErrNoRows 代表 QueryRow 之类的查询没有任何结果，与 sql.ErrNoRows 是同一个错误。
+)
+
+// Is 判断 err 是否属于 target 代表的错误分类，target 一般是这个包定义的 ErrXxx，也可以是任何其他错误。
+//
+// 如果 err 实现了 `Unwrap() error` 或者 `Cause() error`，会逐层检查被包装的错误，
+// 因此业务代码包装过的错误以及 *ShardError 都可以直接判断。
+func Is(err, target error) bool {
+	for ; err != nil; err = unwrap(err) {
+		if err == target || matches(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsDuplicateKey 判断 err 是否是主键或唯一索引冲突。
+func IsDuplicateKey(err error) bool {
+	return Is(err, ErrDuplicateKey)
+}
+
+// IsDeadlock 判断 err 是否是死锁，遇到死锁时通常需要重试整个事务。
+func IsDeadlock(err error) bool {
+	return Is(err, ErrDeadlock)
+}
+
+// IsLockWaitTimeout 判断 err 是否是锁等待超时。
+func IsLockWaitTimeout(err error) bool {
+	return Is(err, ErrLockWaitTimeout)
+}
+
+// IsReadOnly 判断 err 是否是因为服务器或事务只读而无法写入。
+func IsReadOnly(err error) bool {
+	return Is(err, ErrReadOnly)
+}
+
+// IsConnectionLost 判断 err 是否是连接断开，这时写请求可能已经执行成功了。
+func IsConnectionLost(err error) bool {
+	return Is(err, ErrConnectionLost)
+}
+
+// IsNoRows 判断 err 是否是查询没有任何结果。
+func IsNoRows(err error) bool {
+	return Is(err, ErrNoRows)
+}
+
+// ErrorNumber 返回 err 或者被 err 包装的 *mysql.MySQLError 的错误号，不是 MySQL 错误时返回 0。
+func ErrorNumber(err error) uint16 {
+	for ; err != nil; err = unwrap(err) {
+		if e, ok := err.(*mysql.MySQLError); ok {
+			return e.Number
+		}
+	}
+
+	return 0
+}
+
+func unwrap(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Cause() error }:
+		return e.Cause()
+	}
+
+	return nil
+}
+
+// matches 判断 err 本身是否属于 target 代表的错误分类，不检查被包装的错误。
+func matches(err, target error) bool {
+	var num uint16
+	var msg string
+
+	if e, ok := err.(*mysql.MySQLError); ok {
+		num, msg = e.Number, e.Message
+	}
+
+	switch target {
+	case ErrDuplicateKey:
+		return num == 1062 || num == 1022 || num == 1586
+	case ErrDeadlock:
+		return num == 1213
+	case ErrLockWaitTimeout:
+		return num == 1205
+	case ErrReadOnly:
+		// 1290 代表语句被某个启动参数禁止了，只有 --read-only 和 --super-read-only 属于只读。
+		return num == 1290 && strings.Contains(msg, "read-only") || num == 1792 || num == 1836
+	case ErrConnectionLost:
+		if err == driver.ErrBadConn || err == mysql.ErrInvalidConn {
+			return true
+		}
+
+		// context.DeadlineExceeded 也实现了 net.Error，但它只是超时，连接并没有断开。
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return false
+		}
+
+		if _, ok := err.(net.Error); ok {
+			return true
+		}
+
+		// 1927 是连接被 KILL，2006 和 2013 是客户端的连接断开错误，一些代理会原样返回。
+		return num == 1927 || num == 2006 || num == 2013
+	}
+
+	return false
+}
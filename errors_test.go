@@ -0,0 +1,108 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+type testWrappedError struct {
+	err error
+}
+
+func (e *testWrappedError) Error() string {
+	return fmt.Sprintf("wrapped: %v", e.err)
+}
+
+func (e *testWrappedError) Unwrap() error {
+	return e.err
+}
+
+type testCausedError struct {
+	err error
+}
+
+func (e *testCausedError) Error() string {
+	return fmt.Sprintf("caused: %v", e.err)
+}
+
+func (e *testCausedError) Cause() error {
+	return e.err
+}
+
+func TestErrors(t *testing.T) {
+	a := assert.New(t)
+	f := memFactory(t)
+	defer f.Close()
+	ctx := context.Background()
+	m := initTable(ctx, t, f, "errors", "id BIGINT PRIMARY KEY")
+
+	// MySQL、Tx、Row 和 Stmt 返回的错误。
+	_, err := m.Exec("INSERT INTO errors VALUES (1)")
+	a.NilError(err)
+	_, err = m.Exec("INSERT INTO errors VALUES (1)")
+	a.Assert(IsDuplicateKey(err))
+	a.Assert(!IsDeadlock(err))
+	a.Equal(ErrorNumber(err), uint16(1062))
+
+	a.NilError(m.Transaction(nil, func(tx *Tx) error {
+		_, err := tx.Exec("INSERT INTO errors VALUES (1)")
+		a.Assert(IsDuplicateKey(err))
+
+		row, err := tx.QueryRow("SELECT id FROM errors WHERE id = 2")
+		a.NilError(err)
+		var id int64
+		a.Assert(IsNoRows(row.Scan(&id)))
+		return nil
+	}))
+
+	stmt, err := m.Prepare("SELECT id FROM errors WHERE id = ?")
+	a.NilError(err)
+	row, err := stmt.QueryRow(2)
+	a.NilError(err)
+	var id int64
+	err = row.Scan(&id)
+	a.Assert(IsNoRows(err))
+	a.Assert(err == ErrNoRows)
+	a.Equal(ErrorNumber(err), uint16(0))
+
+	memdb.Inject(testDB, 1, &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"})
+	_, err = m.Query("SELECT id FROM errors")
+	a.Assert(IsDeadlock(err))
+	a.Assert(Is(err, ErrDeadlock))
+
+	memdb.Inject(testDB, 1, mysql.ErrInvalidConn)
+	_, err = m.Exec("DELETE FROM errors")
+	a.Assert(IsConnectionLost(err))
+
+	// 包装过的错误。
+	lockWait := &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	a.Assert(IsLockWaitTimeout(&testWrappedError{lockWait}))
+	a.Assert(IsLockWaitTimeout(&testCausedError{&testWrappedError{lockWait}}))
+	a.Assert(IsLockWaitTimeout(&ShardError{Shard: 1, Err: lockWait}))
+	a.Equal(ErrorNumber(&testWrappedError{lockWait}), uint16(1205))
+	a.Assert(Is(&testWrappedError{lockWait}, lockWait))
+	a.Assert(IsConnectionLost(&testWrappedError{driver.ErrBadConn}))
+	a.Assert(!IsConnectionLost(&testWrappedError{errors.New("unknown")}))
+	a.Assert(IsConnectionLost(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}))
+
+	// ctx 结束不是连接断开。
+	a.Assert(!IsConnectionLost(context.Canceled))
+	a.Assert(!IsConnectionLost(context.DeadlineExceeded))
+	a.Assert(!IsConnectionLost(&testWrappedError{context.DeadlineExceeded}))
+
+	// 只有 --read-only 引起的 1290 才是只读错误。
+	a.Assert(IsReadOnly(&mysql.MySQLError{Number: 1290, Message: "The MySQL server is running with the --read-only option so it cannot execute this statement"}))
+	a.Assert(!IsReadOnly(&mysql.MySQLError{Number: 1290, Message: "The MySQL server is running with the --secure-file-priv option so it cannot execute this statement"}))
+	a.Assert(IsReadOnly(&mysql.MySQLError{Number: 1792, Message: "Cannot execute statement in a READ ONLY transaction."}))
+
+	a.Assert(!Is(nil, ErrDeadlock))
+	a.Assert(!IsNoRows(nil))
+}
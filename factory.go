@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -25,16 +27,48 @@ var (
 type Factory struct {
 	unavailable bool // 用来标记 Factory 是否完全不可用，方便 Register 能安全的工作。
 
+	mu sync.RWMutex // mu 保护 factoryOptions，Reload 时会整体替换。
+	factoryOptions
+
+	connPtr unsafe.Pointer
+}
+
+// factoryOptions 是 Factory 从 Config 中得到的所有设置。
+type factoryOptions struct {
 	dsn       string
 	dsnSlave  string
+	slaves    []ConfigSlave
 	mod       int64
 	instances []ConfigInstance
 
+	sharding     string
+	virtualNodes int
+	migration    ConfigMigration
+
+	slaveBalance        string
+	slaveCheckInterval  time.Duration
+	maxSlaveLag         time.Duration
+	slaveHeartbeatTable string
+
 	connMaxLifeTime time.Duration
 	maxIdleConns    int
 	maxOpenConns    int
 
-	connPtr unsafe.Pointer
+	drainTimeout time.Duration
+	retry        *retryPolicy
+	txRetry      *retryPolicy
+	timeout      ConfigTimeout
+	breaker      ConfigBreaker
+	slowQuery    ConfigSlowQuery
+	killOnCancel bool
+
+	xaLogDir string
+	xaNode   string
+
+	stmtCacheSize        int
+	stmtCacheMaxPrepared int
+	unmappedColumns      string
+	localInfile          bool
 }
 
 // NewFactory 实例化一个工厂。
@@ -48,117 +82,217 @@ func NewFactory(config *Config) *Factory {
 		config.MaxIdleConns = DefaultMaxIdleConns
 	}
 
-	return &Factory{
-		dsn:       config.DSN, // 这里不检查合法性，等到 Conn 的时候自然知道有没有问题。
-		dsnSlave:  config.DSNSlave,
-		mod:       config.Mod,
-		instances: config.Instances,
+	if config.SlaveBalance == "" {
+		config.SlaveBalance = BalanceRoundRobin
+	}
+
+	if config.SlaveCheckInterval == 0 {
+		config.SlaveCheckInterval = DefaultSlaveCheckInterval
+	}
+
+	if config.Sharding == "" {
+		config.Sharding = ShardingMod
+	}
+
+	if config.VirtualNodes == 0 {
+		config.VirtualNodes = DefaultVirtualNodes
+	}
 
-		connMaxLifeTime: config.ConnMaxLifetime,
-		maxIdleConns:    config.MaxIdleConns,
-		maxOpenConns:    config.MaxOpenConns,
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = DefaultDrainTimeout
+	}
+
+	if config.UnmappedColumns == "" {
+		config.UnmappedColumns = UnmappedColumnsIgnore
+	}
+
+	return &Factory{
+		factoryOptions: factoryOptions{
+			dsn:       config.DSN, // 这里不检查合法性，等到 Conn 的时候自然知道有没有问题。
+			dsnSlave:  config.DSNSlave,
+			slaves:    config.Slaves,
+			mod:       config.Mod,
+			instances: config.Instances,
+
+			sharding:     config.Sharding,
+			virtualNodes: config.VirtualNodes,
+			migration:    config.Migration,
+
+			slaveBalance:        config.SlaveBalance,
+			slaveCheckInterval:  config.SlaveCheckInterval,
+			maxSlaveLag:         config.MaxSlaveLag,
+			slaveHeartbeatTable: config.SlaveHeartbeatTable,
+
+			connMaxLifeTime: config.ConnMaxLifetime,
+			maxIdleConns:    config.MaxIdleConns,
+			maxOpenConns:    config.MaxOpenConns,
+
+			drainTimeout: config.DrainTimeout,
+			retry:        newRetryPolicy(config.Retry),
+			txRetry:      newTxRetryPolicy(config.Retry),
+			timeout:      config.Timeout,
+			breaker:      config.Breaker,
+			slowQuery:    config.SlowQuery,
+			killOnCancel: config.KillOnCancel,
+
+			xaLogDir: config.XA.LogDir,
+			xaNode:   config.XA.Node,
+
+			stmtCacheSize:        config.StmtCacheSize,
+			stmtCacheMaxPrepared: config.StmtCacheMaxPrepared,
+			unmappedColumns:      config.UnmappedColumns,
+			localInfile:          config.LocalInfile,
+		},
 	}
 }
 
 // Conn 建立 MySQL 连接。
+// 如果之前已经建立过连接，旧连接会在所有正在进行的查询结束后关闭。
 func (f *Factory) Conn(ctx context.Context) (err error) {
 	if f.unavailable {
 		return errors.New("go-mysql: factory is not initialized")
 	}
 
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	conn, err := f.open(ctx)
+
+	if err != nil {
+		return
+	}
+
+	f.swap(ctx, conn)
+	return nil
+}
+
+// open 根据当前设置建立所有连接，调用者必须持有 f.mu。
+func (f *Factory) open(ctx context.Context) (conn *dbConn, err error) {
 	// 先检查配置的合法性。
-	// 如果设置了 instances，那么就得设置合法的 mod，并且 buckets 需要能覆盖 mod 所有情况、
-	if err = f.validateInstances(); err != nil {
+	// 如果设置了 instances，那么就得根据分片策略设置合法的 mod、buckets 或 ranges。
+	sharder, err := newSharder(f.sharding, f.mod, f.virtualNodes, f.instances)
+
+	if err != nil {
 		return
 	}
 
-	conn := &dbConn{
-		Instances: make(map[int64]*dbInstance),
+	if f.slaveBalance != BalanceRoundRobin && f.slaveBalance != BalanceLeastConn {
+		return nil, fmt.Errorf("go-mysql: invalid slave balance %v", f.slaveBalance)
+	}
+
+	if f.unmappedColumns != UnmappedColumnsIgnore && f.unmappedColumns != UnmappedColumnsWarn && f.unmappedColumns != UnmappedColumnsError {
+		return nil, fmt.Errorf("go-mysql: invalid unmapped columns option %v", f.unmappedColumns)
+	}
+
+	// 连接数不限制时无法限制服务端预处理语句的总数。
+	if f.stmtCacheSize > 0 && f.stmtCacheMaxPrepared > 0 && f.maxOpenConns <= 0 {
+		return nil, errors.New("go-mysql: max_open_conns is required by stmt_cache_max_prepared")
+	}
+
+	conn = &dbConn{
+		Sharder: sharder,
 	}
 
 	if f.dsn != "" {
-		err = conn.openDBConn(ctx, f, f.dsn, f.dsnSlave)
+		conn.Label = defaultInstanceLabel
+		err = conn.openDBConn(ctx, f, f.dsn, f.dsnSlave, f.slaves)
 
 		if err != nil {
-			return
+			conn.Close()
+			return nil, err
 		}
 	}
 
-	for _, ins := range f.instances {
-		db := &dbInstance{}
-		err = db.openDBConn(ctx, f, ins.DSN, ins.DSNSlave)
+	for i, ins := range f.instances {
+		db := &dbInstance{
+			Label: strconv.Itoa(i),
+		}
+		err = db.openDBConn(ctx, f, ins.DSN, ins.DSNSlave, ins.Slaves)
 
 		if err != nil {
-			return
+			db.Close()
+			conn.Close()
+			return nil, err
 		}
 
-		for _, b := range ins.Buckets {
-			conn.Instances[b] = db
-		}
+		conn.Instances = append(conn.Instances, db)
 	}
 
-	old := (*dbConn)(atomic.SwapPointer(&f.connPtr, unsafe.Pointer(conn)))
+	if len(f.migration.Instances) > 0 {
+		conn.Migration, err = f.openMigration(ctx, conn)
 
-	if old != nil {
-		old.Close()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
 
-	return nil
+	return
 }
 
-func (f *Factory) validateInstances() error {
-	if len(f.instances) == 0 {
-		return nil
-	}
+// stmtCacheLimit 返回每个 *sql.DB 上实际的预处理语句缓存大小。
+// database/sql 会在连接池的每个连接上分别预处理缓存的语句，因此服务端的预处理语句数最多是缓存大小乘以 maxOpenConns。
+func (opts *factoryOptions) stmtCacheLimit() int {
+	size := opts.stmtCacheSize
 
-	if f.mod <= 0 {
-		return errors.New("go-mysql: mod should not be 0 when instances are set")
+	if opts.stmtCacheMaxPrepared > 0 && opts.maxOpenConns > 0 {
+		if limit := opts.stmtCacheMaxPrepared / opts.maxOpenConns; limit < size {
+			size = limit
+		}
 	}
 
-	buckets := map[int64]struct{}{}
-
-	for _, ins := range f.instances {
-		for _, b := range ins.Buckets {
-			if b >= f.mod {
-				return fmt.Errorf("go-mysql: invalid bucket index %v which is larger than mod %v", b, f.mod)
-			}
+	return size
+}
 
-			if _, ok := buckets[b]; ok {
-				return fmt.Errorf("go-mysql: bucket index %v is defined more than once", b)
-			}
+// swap 使用 conn 替换当前连接，旧连接会在后台等待所有查询结束后关闭。
+func (f *Factory) swap(ctx context.Context, conn *dbConn) {
+	old := (*dbConn)(atomic.SwapPointer(&f.connPtr, unsafe.Pointer(conn)))
 
-			buckets[b] = struct{}{}
-		}
+	if old != nil {
+		go old.Drain(ctx, f.drainTimeout)
 	}
+}
 
-	if int64(len(buckets)) != f.mod {
-		missing := []int64{}
+func (f *Factory) openDB(ctx context.Context, dsn string) (db *sql.DB, err error) {
+	db, err = f.newDB(ctx, dsn)
 
-		for i := int64(0); i < f.mod; i++ {
-			if _, ok := buckets[i]; !ok {
-				missing = append(missing, i)
-			}
-		}
+	if err != nil {
+		return
+	}
 
-		return fmt.Errorf("go-mysql: indice of buckets in instances are missing %v", missing)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		db = nil
+		log.Errorf(ctx, "err=%v||dsn=%v||go-mysql: fail to ping MySQL", err, dsn)
+		return
 	}
 
-	return nil
+	return
 }
 
-func (f *Factory) openDB(ctx context.Context, dsn string) (db *sql.DB, err error) {
-	// 检查 DSN 是否合法。
-	cfg, err := mysql.ParseDSN(dsn)
+// newDB 创建一个 *sql.DB，但不检查连接是否可用。
+func (f *Factory) newDB(ctx context.Context, dsn string) (db *sql.DB, err error) {
+	source := dsn
 
-	if err != nil {
-		log.Errorf(ctx, "err=%v||dsn=%v||go-mysql: MySQL dsn is invalid", err, dsn)
-		return
+	// 内存数据库的 DSN 格式与 MySQL 不同，不需要检查和修改。
+	if !driver.IsMemDSN(dsn) {
+		var cfg *mysql.Config
+
+		// 检查 DSN 是否合法。
+		cfg, err = mysql.ParseDSN(dsn)
+
+		if err != nil {
+			log.Errorf(ctx, "err=%v||dsn=%v||go-mysql: MySQL dsn is invalid", err, dsn)
+			return
+		}
+
+		// 为了支持解析 DATETIME 类型到 time.Time，设置这个标记。
+		cfg.ParseTime = true
+		cfg.Loc = time.Local
+		source = cfg.FormatDSN()
 	}
 
-	// 为了支持解析 DATETIME 类型到 time.Time，设置这个标记。
-	cfg.ParseTime = true
-	cfg.Loc = time.Local
-	db, err = sql.Open(driver.Name, cfg.FormatDSN())
+	db, err = sql.Open(driver.Name, source)
 
 	if err != nil {
 		log.Errorf(ctx, "err=%v||dsn=%v||go-mysql: fail to open MySQL connection", err, dsn)
@@ -168,18 +302,39 @@ func (f *Factory) openDB(ctx context.Context, dsn string) (db *sql.DB, err error
 	db.SetConnMaxLifetime(f.connMaxLifeTime)
 	db.SetMaxIdleConns(f.maxIdleConns)
 	db.SetMaxOpenConns(f.maxOpenConns)
+	return
+}
 
-	if err = db.Ping(); err != nil {
-		db = nil
-		log.Errorf(ctx, "err=%v||dsn=%v||go-mysql: fail to ping MySQL", err, dsn)
-		return
+// dsnName 返回 dsn 中的地址和数据库名，用于日志和监控，避免泄露密码。
+func dsnName(dsn string) string {
+	if driver.IsMemDSN(dsn) {
+		return dsn
 	}
 
-	return
+	cfg, err := mysql.ParseDSN(dsn)
+
+	if err != nil {
+		return "invalid"
+	}
+
+	return cfg.Addr + "/" + cfg.DBName
 }
 
 // New 建立新的 MySQL 实例，供业务代码使用。
+// 如果 ctx 中绑定了事务（WithTx 或者 Tx#Context），并且事务与 ctx 选中的是同一个实例，
+// 返回的 MySQL 实例的 Exec/Query/QueryRow/Prepare 都会在这个事务中执行。
 func (f *Factory) New(ctx context.Context) *MySQL {
+	mysql := f.route(ctx)
+
+	if tx := txFromContext(ctx); tx != nil && tx.ins == mysql.ins {
+		mysql.tx = tx
+	}
+
+	return mysql
+}
+
+// route 根据 ctx 中的 idx 选择实例。
+func (f *Factory) route(ctx context.Context) *MySQL {
 	if f.unavailable {
 		panic(errors.New("go-mysql: factory is not initialized"))
 	}
@@ -187,7 +342,8 @@ func (f *Factory) New(ctx context.Context) *MySQL {
 	conn := f.conn()
 
 	if conn == nil {
-		log.Errorf(ctx, "dsn=%v||go-mysql: MySQL factory is not connected (forgot to call `f.Conn`?)", f.dsn)
+		opts := f.options()
+		log.Errorf(ctx, "dsn=%v||go-mysql: MySQL factory is not connected (forgot to call `f.Conn`?)", opts.dsn)
 		panic(errors.New("go-mysql: factory is not connected"))
 	}
 
@@ -195,7 +351,8 @@ func (f *Factory) New(ctx context.Context) *MySQL {
 
 	if !ok || len(conn.Instances) == 0 {
 		if conn.Master == nil {
-			log.Errorf(ctx, "dsn=%v||instances=%v||go-mysql: no default master DSN for MySQL factory", f.dsn, f.instances)
+			opts := f.options()
+			log.Errorf(ctx, "dsn=%v||instances=%v||go-mysql: no default master DSN for MySQL factory", opts.dsn, opts.instances)
 
 			if len(conn.Instances) > 0 {
 				panic(errors.New("go-mysql: missing instance index (forgot to call WithIndex?)"))
@@ -204,17 +361,32 @@ func (f *Factory) New(ctx context.Context) *MySQL {
 			}
 		}
 
-		return newMySQL(ctx, conn.Master, conn.Slave)
+		if ok && conn.Migration != nil {
+			return conn.Migration.New(ctx, idx, &conn.dbInstance)
+		}
+
+		return newMySQL(ctx, &conn.dbInstance)
 	}
 
 	if len(conn.Instances) == 0 {
-		log.Errorf(ctx, "dsn=%v||instances=%v||go-mysql: no cluster instance is connected", f.dsn, f.instances)
+		opts := f.options()
+		log.Errorf(ctx, "dsn=%v||instances=%v||go-mysql: no cluster instance is connected", opts.dsn, opts.instances)
 		panic(errors.New("go-mysql: no cluster instance nor default master DSN"))
 	}
 
-	idx = idx % f.mod
-	ins := conn.Instances[idx]
-	return newMySQL(ctx, ins.Master, ins.Slave)
+	i := conn.Sharder.Shard(idx)
+
+	if i < 0 || i >= len(conn.Instances) {
+		opts := f.options()
+		log.Errorf(ctx, "dsn=%v||idx=%v||sharding=%v||go-mysql: no instance is found for the index", opts.dsn, idx, opts.sharding)
+		panic(fmt.Errorf("go-mysql: no instance is found for index %v", idx))
+	}
+
+	if conn.Migration != nil {
+		return conn.Migration.New(ctx, idx, conn.Instances[i])
+	}
+
+	return newMySQL(ctx, conn.Instances[i])
 }
 
 // Close 关闭数据库连接，一般没有调用的必要。
@@ -232,6 +404,13 @@ func (f *Factory) Close() error {
 	return conn.Close()
 }
 
+// options 返回当前的设置，由于 Reload 可能同时修改设置，需要加锁读取。
+func (f *Factory) options() factoryOptions {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.factoryOptions
+}
+
 func (f *Factory) conn() *dbConn {
 	return (*dbConn)(atomic.LoadPointer(&f.connPtr))
 }
@@ -249,6 +428,7 @@ func Register(section string) **Factory {
 			return fmt.Errorf("go-mysql: missing MySQL config `[%v]`", section)
 		}
 
+		raw := *config
 		f := NewFactory(config)
 
 		if err := f.Conn(ctx); err != nil {
@@ -259,6 +439,18 @@ func Register(section string) **Factory {
 		log.Tracef(ctx, "dsn=%v||section=%v||go-mysql: mysql is connected", config.DSN, section)
 		initMetrics()
 		factory = f
+
+		// 悬挂的 XA 事务会一直持有锁，启动时尽早处理，失败了也不影响服务启动。
+		if config.XA.LogDir != "" {
+			if err := f.RecoverXA(ctx); err != nil {
+				log.Errorf(ctx, "err=%v||section=%v||go-mysql: fail to recover XA transactions", err, section)
+			}
+		}
+
+		if config.ReloadInterval > 0 {
+			watchConfig(ctx, f, section, raw, config.ReloadInterval)
+		}
+
 		return nil
 	})
 	return &factory
@@ -266,12 +458,38 @@ func Register(section string) **Factory {
 
 type dbConn struct {
 	dbInstance
-	Instances map[int64]*dbInstance
+	Instances []*dbInstance // Instances 与 Config.Instances 一一对应。
+	Sharder   sharder
+	Migration *migration
 }
 
 type dbInstance struct {
-	Master *sql.DB
-	Slave  *sql.DB
+	Name    string // Name 是主库的地址和数据库名，用于日志和监控。
+	Label   string // Label 是实例在监控中的标签，默认的 DSN 是 default，Config.Instances 中的实例是它的下标，迁移时新打开的实例是 migration_ 加上它的下标。
+	Master  *sql.DB
+	Slaves  *slavePool
+	Retry   *retryPolicy
+	TxRetry *retryPolicy
+	Timeout ConfigTimeout
+	Stmts   map[*sql.DB]*stmtCache // Stmts 是主库和每个从库上的预处理语句缓存，没有开启缓存时为 nil。
+	Breaker *breaker               // Breaker 是实例的熔断器，没有开启熔断时为 nil。
+	SlowLog *slowLog               // SlowLog 是实例的慢查询日志，没有设置 Config.SlowQuery.Threshold 时为 nil。
+	Killers map[*sql.DB]*killer    // Killers 是主库和每个从库对应的 KILL QUERY 控制连接，没有开启 Config.KillOnCancel 时为 nil。
+
+	Unmapped    string // Unmapped 是 ScanStruct 遇到没有对应字段的列时的处理方式。
+	LocalInfile bool   // LocalInfile 表示是否允许使用 LOAD DATA LOCAL INFILE。
+}
+
+// defaultInstanceLabel 是默认 DSN 在监控中的标签。
+const defaultInstanceLabel = "default"
+
+// label 返回实例在监控中的标签，格式是 Label:master 或者 Label:slave。
+func (db *dbInstance) label(master bool) string {
+	if master {
+		return db.Label + ":master"
+	}
+
+	return db.Label + ":slave"
 }
 
 func (conn *dbConn) Close() error {
@@ -289,38 +507,124 @@ func (conn *dbConn) Close() error {
 		}
 	}
 
+	if conn.Migration != nil {
+		return conn.Migration.Close()
+	}
+
 	return nil
 }
 
-func (db *dbInstance) openDBConn(ctx context.Context, f *Factory, dsn, dsnSlave string) (err error) {
+func (db *dbInstance) openDBConn(ctx context.Context, f *Factory, dsn, dsnSlave string, slaves []ConfigSlave) (err error) {
 	db.Master, err = f.openDB(ctx, dsn)
 
 	if err != nil {
 		return
 	}
 
-	if dsnSlave == "" {
-		db.Slave = db.Master
-	} else {
-		db.Slave, err = f.openDB(ctx, dsnSlave)
+	db.Name = dsnName(dsn)
+
+	db.Slaves = newSlavePool(db.Master, f.slaveBalance, f.maxSlaveLag, f.slaveHeartbeatTable)
+	db.Retry = f.retry
+	db.TxRetry = f.txRetry
+	db.Timeout = f.timeout
+	db.Breaker = newBreaker(db.Name, f.breaker)
+	db.SlowLog = newSlowLog(db, f.slowQuery)
+	db.Unmapped = f.unmappedColumns
+	db.LocalInfile = f.localInfile
+
+	if dsnSlave != "" {
+		slaves = append([]ConfigSlave{{DSN: dsnSlave}}, slaves...)
+	}
+
+	for _, cs := range slaves {
+		var slave *sql.DB
+		slave, err = f.newDB(ctx, cs.DSN)
 
 		if err != nil {
 			return
 		}
+
+		// 从库暂时不可用不影响服务启动，健康检查会在从库恢复后自动将它加回来。
+		healthy := true
+
+		if e := slave.Ping(); e != nil {
+			healthy = false
+			log.Errorf(ctx, "err=%v||dsn=%v||go-mysql: fail to ping MySQL slave", e, cs.DSN)
+		}
+
+		db.Slaves.add(cs.DSN, slave, cs.Weight, healthy)
+	}
+
+	if size := f.stmtCacheLimit(); size > 0 {
+		db.Stmts = map[*sql.DB]*stmtCache{
+			db.Master: newStmtCache(db.Master, size),
+		}
+
+		for _, s := range db.Slaves.slaves {
+			db.Stmts[s.DB] = newStmtCache(s.DB, size)
+		}
+	} else if f.stmtCacheSize > 0 {
+		log.Warnf(ctx, "max_prepared=%v||max_open_conns=%v||go-mysql: stmt_cache_max_prepared is less than max_open_conns and statement cache is disabled", f.stmtCacheMaxPrepared, f.maxOpenConns)
+	}
+
+	if f.killOnCancel {
+		db.Killers = map[*sql.DB]*killer{}
+		dsns := map[*sql.DB]string{
+			db.Master: dsn,
+		}
+
+		for _, s := range db.Slaves.slaves {
+			dsns[s.DB] = s.DSN
+		}
+
+		for sqldb, dsn := range dsns {
+			var k *killer
+
+			if k, err = newKiller(ctx, f, dsn); err != nil {
+				return
+			}
+
+			db.Killers[sqldb] = k
+		}
 	}
 
+	// MaxSlaveLag 只是延迟的阈值，查询延迟的超时时间与后台健康检查一样使用检查间隔。
+	if f.maxSlaveLag > 0 {
+		timeout := f.slaveCheckInterval
+
+		if timeout <= 0 {
+			timeout = DefaultSlaveCheckInterval
+		}
+
+		db.Slaves.CheckLag(ctx, timeout)
+	}
+
+	db.Slaves.Start(f.slaveCheckInterval)
 	return
 }
 
 func (db *dbInstance) Close() error {
+	if db.Master == nil {
+		return nil
+	}
+
+	// 连接关闭后缓存的预处理语句就失效了，重新建立连接时会创建新的缓存。
+	for _, cache := range db.Stmts {
+		cache.Close()
+	}
+
+	for _, k := range db.Killers {
+		k.Close()
+	}
+
 	err := db.Master.Close()
 
 	if err != nil {
 		return err
 	}
 
-	if db.Master != db.Slave {
-		err = db.Slave.Close()
+	if db.Slaves != nil {
+		err = db.Slaves.Close()
 
 		if err != nil {
 			return err
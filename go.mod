@@ -3,6 +3,7 @@ module github.com/altstory/go-mysql
 go 1.12
 
 require (
+	github.com/altstory/go-config v1.0.5
 	github.com/altstory/go-log v1.0.5
 	github.com/altstory/go-metrics v1.0.7
 	github.com/altstory/go-runner v1.1.8
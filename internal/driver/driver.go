@@ -2,7 +2,10 @@ package driver
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"io"
 
+	"github.com/altstory/go-mysql/internal/memdb"
 	"github.com/go-sql-driver/mysql"
 )
 
@@ -10,6 +13,38 @@ import (
 const Name = "altstory-mysql"
 
 func init() {
-	// 当前只是简单的使用了开源的 driver，后续要实现测试用内存数据库时，会改为一个自行实现的版本。
-	sql.Register(Name, &mysql.MySQLDriver{})
+	sql.Register(Name, &mysqlDriver{})
+}
+
+// mysqlDriver 根据 DSN 选择实际使用的 driver：
+// `mem://` 开头的 DSN 使用测试用的内存数据库，其他的使用开源的 MySQL driver。
+type mysqlDriver struct {
+	mysql mysql.MySQLDriver
+	mem   memdb.Driver
+}
+
+func (d *mysqlDriver) Open(dsn string) (driver.Conn, error) {
+	if IsMemDSN(dsn) {
+		return d.mem.Open(dsn)
+	}
+
+	return d.mysql.Open(dsn)
+}
+
+// IsMemDSN 判断 dsn 是否指向测试用的内存数据库。
+func IsMemDSN(dsn string) bool {
+	return memdb.IsDSN(dsn)
+}
+
+// RegisterReaderHandler 同时在 MySQL driver 和内存数据库上注册一个 io.Reader，
+// 之后可以使用 `LOAD DATA LOCAL INFILE 'Reader::<name>'` 从这个 io.Reader 读取数据。
+func RegisterReaderHandler(name string, handler func() io.Reader) {
+	mysql.RegisterReaderHandler(name, handler)
+	memdb.RegisterReaderHandler(name, handler)
+}
+
+// DeregisterReaderHandler 删除 RegisterReaderHandler 注册的 io.Reader。
+func DeregisterReaderHandler(name string) {
+	mysql.DeregisterReaderHandler(name)
+	memdb.DeregisterReaderHandler(name)
 }
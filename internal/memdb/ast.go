@@ -0,0 +1,233 @@
+package memdb
+
+type statement interface{}
+
+type expr interface{}
+
+type literalExpr struct {
+	Value value
+}
+
+type paramExpr struct {
+	Index int
+}
+
+type columnExpr struct {
+	Table string
+	Name  string
+}
+
+type unaryExpr struct {
+	Op string // "-" 或者 "NOT"。
+	X  expr
+}
+
+type binaryExpr struct {
+	Op   string
+	L, R expr
+}
+
+type isNullExpr struct {
+	X   expr
+	Not bool
+}
+
+type inExpr struct {
+	X    expr
+	List []expr
+	Not  bool
+}
+
+type likeExpr struct {
+	X, Pattern expr
+	Not        bool
+}
+
+type betweenExpr struct {
+	X, Lo, Hi expr
+	Not       bool
+}
+
+type tupleExpr struct {
+	List []expr
+}
+
+// variableExpr 是一个系统变量，比如 @@GLOBAL.gtid_executed。
+type variableExpr struct {
+	Name string // Name 永远是小写，不包含 @@ 和 GLOBAL/SESSION 前缀。
+}
+
+type funcExpr struct {
+	Name     string // Name 永远是大写。
+	Args     []expr
+	Star     bool // Star 表示参数是 *，仅用于 COUNT(*)。
+	Distinct bool
+}
+
+type columnType int
+
+const (
+	typeInt columnType = iota
+	typeFloat
+	typeString
+	typeBytes
+	typeDateTime
+	typeDate
+)
+
+type columnDef struct {
+	Name          string
+	Type          columnType
+	TypeName      string
+	NotNull       bool
+	AutoIncrement bool
+	Default       expr
+	Primary       bool
+	Unique        bool
+}
+
+type createTableStmt struct {
+	Table       string
+	IfNotExists bool
+	Columns     []*columnDef
+	PrimaryKey  []string
+	UniqueKeys  []uniqueKeyDef
+}
+
+type uniqueKeyDef struct {
+	Name    string
+	Columns []string
+}
+
+type dropTableStmt struct {
+	Tables   []string
+	IfExists bool
+}
+
+type truncateStmt struct {
+	Table string
+}
+
+type assignment struct {
+	Column string
+	Value  expr
+}
+
+type insertStmt struct {
+	Table       string
+	Replace     bool
+	Ignore      bool
+	Columns     []string
+	Rows        [][]expr
+	OnDuplicate []assignment
+}
+
+type selectItem struct {
+	Expr  expr
+	Text  string // Text 是 Expr 在 SQL 里的原文，没有别名时作为列名。
+	Alias string
+	Star  bool // Star 表示这一项是 * 或者 t.*。
+}
+
+type orderItem struct {
+	Expr expr
+	Desc bool
+}
+
+type selectStmt struct {
+	Distinct bool
+	Items    []selectItem
+	Table    string
+	Where    expr
+	GroupBy  []expr
+	Having   expr
+	OrderBy  []orderItem
+	Limit    expr
+	Offset   expr
+}
+
+type updateStmt struct {
+	Table   string
+	Sets    []assignment
+	Where   expr
+	OrderBy []orderItem
+	Limit   expr
+}
+
+type deleteStmt struct {
+	Table   string
+	Where   expr
+	OrderBy []orderItem
+	Limit   expr
+}
+
+type beginStmt struct{}
+
+type commitStmt struct{}
+
+type rollbackStmt struct {
+	Savepoint string // Savepoint 为空代表回滚整个事务。
+}
+
+type savepointStmt struct {
+	Name string
+}
+
+type releaseStmt struct {
+	Name string
+}
+
+// xaStmt 代表 XA START/END/PREPARE/COMMIT/ROLLBACK/RECOVER 语句。
+type xaStmt struct {
+	Action   string // Action 是 XA 后面的动作，比如 START、PREPARE、RECOVER，永远是大写。
+	XID      xid
+	OnePhase bool // OnePhase 代表 XA COMMIT xid ONE PHASE。
+}
+
+// xid 是 XA 事务的标识，格式与 MySQL 一致。
+type xid struct {
+	Gtrid    string
+	Bqual    string
+	FormatID int64
+}
+
+// loadDataStmt 代表 LOAD DATA [LOCAL] INFILE 语句。
+type loadDataStmt struct {
+	File    string
+	Local   bool
+	Replace bool
+	Ignore  bool
+	Table   string
+	Columns []string
+
+	FieldsTerminated   string
+	Enclosed           string
+	OptionallyEnclosed bool
+	Escaped            string
+	LinesStarting      string
+	LinesTerminated    string
+	IgnoreLines        int64
+}
+
+// explainStmt 代表 EXPLAIN SELECT 语句。
+type explainStmt struct {
+	Select *selectStmt
+}
+
+// killStmt 代表 KILL [CONNECTION | QUERY] id 语句。
+type killStmt struct {
+	ID    int64
+	Query bool // Query 表示只中断连接上正在执行的语句，否则关闭整个连接。
+}
+
+type showStmt struct {
+	What string // What 是 SHOW 后面的内容，比如 TABLES、SLAVE STATUS、WARNINGS，永远是大写。
+}
+
+// setInsertIDStmt 代表 SET insert_id = n 语句，指定下一条 INSERT 语句的第一个自增值。
+type setInsertIDStmt struct {
+	ID int64
+}
+
+// ignoredStmt 代表内存数据库不关心的语句，比如 SET NAMES，执行时什么都不做。
+type ignoredStmt struct{}
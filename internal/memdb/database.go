@@ -0,0 +1,369 @@
+package memdb
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// database 是一个内存数据库，同名的 DSN 会共享同一个 database。
+type database struct {
+	Name string
+
+	UUID string // UUID 是这个数据库的 server_uuid，用于生成 GTID。
+
+	mu       sync.Mutex
+	tables   map[string]*table
+	prepared map[string]*preparedXA // prepared 是所有已经 XA PREPARE 的事务，与连接无关，直到 XA COMMIT 或 XA ROLLBACK。
+
+	lastConnID int64
+	lastTxnID  int64 // lastTxnID 是最后一个写入事务的 GTID 序号。
+	stmts      int64 // stmts 是所有连接上还没有关闭的预处理语句数。
+
+	sessionMu sync.Mutex
+	sessions  map[int64]*session // sessions 是所有打开的连接，用于 KILL。
+
+	faultMu sync.Mutex
+	faults  []error
+}
+
+var registry = struct {
+	sync.Mutex
+	databases map[string]*database
+}{
+	databases: make(map[string]*database),
+}
+
+func lookupDatabase(name string) *database {
+	registry.Lock()
+	defer registry.Unlock()
+
+	db, ok := registry.databases[name]
+
+	if !ok {
+		sum := md5.Sum([]byte(name))
+		id := hex.EncodeToString(sum[:])
+		db = &database{
+			Name:     name,
+			UUID:     id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:],
+			tables:   make(map[string]*table),
+			sessions: make(map[int64]*session),
+		}
+		registry.databases[name] = db
+	}
+
+	return db
+}
+
+// Drop 删除名为 name 的内存数据库里的所有数据，一般用于测试前清理环境。
+// 已经打开的连接依然可以使用，但看到的是一个空的数据库。
+func Drop(name string) {
+	registry.Lock()
+	db, ok := registry.databases[name]
+	registry.Unlock()
+
+	if !ok {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.tables = make(map[string]*table)
+	db.prepared = nil
+
+	db.faultMu.Lock()
+	defer db.faultMu.Unlock()
+	db.faults = nil
+}
+
+// Inject 让名为 name 的内存数据库接下来执行的 n 条语句都返回 err，用于在测试中模拟死锁、连接断开等错误。
+func Inject(name string, n int, err error) {
+	db := lookupDatabase(name)
+	db.faultMu.Lock()
+	defer db.faultMu.Unlock()
+
+	for i := 0; i < n; i++ {
+		db.faults = append(db.faults, err)
+	}
+}
+
+// PreparedStmts 返回名为 name 的内存数据库上所有连接中还没有关闭的预处理语句数，
+// 与 MySQL 的 Prepared_stmt_count 状态一致，连接关闭时上面的语句也会被关闭。
+func PreparedStmts(name string) int {
+	return int(atomic.LoadInt64(&lookupDatabase(name).stmts))
+}
+
+// fault 返回下一个注入的错误，没有则返回 nil。
+func (db *database) fault() error {
+	db.faultMu.Lock()
+	defer db.faultMu.Unlock()
+
+	if len(db.faults) == 0 {
+		return nil
+	}
+
+	err := db.faults[0]
+	db.faults = db.faults[1:]
+	return err
+}
+
+// wrote 记录一个写入事务，为其分配一个 GTID。
+func (db *database) wrote() {
+	atomic.AddInt64(&db.lastTxnID, 1)
+}
+
+// GTIDExecuted 返回所有已经执行过的 GTID 集合，格式与 MySQL 的 @@GLOBAL.gtid_executed 一致。
+func (db *database) GTIDExecuted() string {
+	n := atomic.LoadInt64(&db.lastTxnID)
+
+	if n == 0 {
+		return ""
+	}
+
+	return db.UUID + ":1-" + strconv.FormatInt(n, 10)
+}
+
+// apply 将事务中修改过的行合并到 database 里，调用者必须持有 db.mu。
+// 其他连接在事务期间提交的修改都会保留，如果事务修改过的行同时也被其他连接修改了，
+// 提交失败并且不写入任何数据，详见 table#merge。
+func (db *database) apply(tx *txState) error {
+	merged := make(map[string]*table, len(tx.tables))
+
+	for key, t := range tx.tables {
+		current := db.tables[key]
+
+		// 如果事务期间这张表被删除或者重建了，那么事务里的修改就没有意义了。
+		if current == nil || current.ID != t.ID {
+			continue
+		}
+
+		m, err := t.merge(tx.base[key], current)
+
+		if err != nil {
+			return err
+		}
+
+		merged[key] = m
+	}
+
+	for key, t := range merged {
+		db.tables[key] = t
+	}
+
+	if len(tx.tables) > 0 {
+		db.wrote()
+	}
+
+	return nil
+}
+
+// session 是一个连接上的会话状态。
+type session struct {
+	db           *database
+	id           int64
+	tx           *txState
+	lastInsertID int64
+	insertID     int64 // insertID 是 SET insert_id 设置的值，只对下一条语句有效。
+	stmts        int64 // stmts 是这个连接上还没有关闭的预处理语句数。
+
+	xid     *xid   // xid 是当前连接上正在进行的 XA 事务，XA PREPARE 之后会脱离连接。
+	xaState string // xaState 是当前 XA 事务的状态，可能是 xaActive 或者 xaIdle。
+
+	warnings []warning // warnings 是上一条语句产生的警告，用于 SHOW WARNINGS。
+
+	killMu sync.Mutex
+	cancel context.CancelFunc // cancel 用来中断正在执行的语句，没有语句在执行时为 nil。
+	killed bool               // killed 表示正在执行的语句被 KILL 中断了。
+	dead   bool               // dead 表示连接已经被 KILL CONNECTION 关闭。
+}
+
+type txState struct {
+	tables     map[string]*table // tables 是事务中修改过的表，提交时将修改过的行合并到 database。
+	base       map[string]*table // base 是事务第一次修改每张表时 database 里的版本，用来计算事务修改过的行。
+	savepoints []savepoint
+}
+
+type savepoint struct {
+	name   string
+	tables map[string]*table
+}
+
+func newSession(db *database) *session {
+	s := &session{
+		db: db,
+		id: atomic.AddInt64(&db.lastConnID, 1),
+	}
+
+	db.sessionMu.Lock()
+	defer db.sessionMu.Unlock()
+	db.sessions[s.id] = s
+	return s
+}
+
+// prepared 记录这个连接上预处理语句数的变化。
+func (s *session) prepared(delta int64) {
+	s.stmts += delta
+	atomic.AddInt64(&s.db.stmts, delta)
+}
+
+// close 在连接关闭时回滚未提交的事务并关闭所有预处理语句，并且不再接受 KILL。
+func (s *session) close() {
+	s.rollback()
+	s.prepared(-s.stmts)
+
+	s.db.sessionMu.Lock()
+	defer s.db.sessionMu.Unlock()
+	delete(s.db.sessions, s.id)
+}
+
+// lookup 查找表，调用者必须持有 db.mu。
+func (s *session) lookup(name string) (t *table, err error) {
+	key := strings.ToLower(name)
+
+	if s.tx != nil {
+		if t = s.tx.tables[key]; t != nil {
+			return
+		}
+	}
+
+	t = s.db.tables[key]
+
+	if t == nil {
+		err = newErrorf(errNoSuchTable, "Table '%v.%v' doesn't exist", s.db.Name, name)
+	}
+
+	return
+}
+
+// store 保存修改后的表，调用者必须持有 db.mu。
+func (s *session) store(t *table) {
+	key := strings.ToLower(t.Name)
+
+	if s.tx != nil {
+		if _, ok := s.tx.base[key]; !ok {
+			s.tx.base[key] = s.db.tables[key]
+		}
+
+		s.tx.tables[key] = t
+		s.shareAutoIncrement(t)
+		return
+	}
+
+	s.db.tables[key] = t
+	s.db.wrote()
+}
+
+// shareAutoIncrement 让事务中的表与 database 共用自增值，调用者必须持有 db.mu。
+// 与 MySQL 一样，自增值不受事务控制，事务回滚之后已经分配的值也不会被重用，这样并发的事务不会分配到相同的值。
+func (s *session) shareAutoIncrement(t *table) {
+	current := s.db.tables[strings.ToLower(t.Name)]
+
+	if current == nil || current.ID != t.ID {
+		return
+	}
+
+	if current.AutoIncrement > t.AutoIncrement {
+		t.AutoIncrement = current.AutoIncrement
+	} else {
+		current.AutoIncrement = t.AutoIncrement
+	}
+}
+
+func (s *session) begin() (err error) {
+	if s.tx != nil {
+		// 与 MySQL 一样，开始新事务会隐式提交当前事务。
+		err = s.commit()
+	}
+
+	s.tx = &txState{
+		tables: make(map[string]*table),
+		base:   make(map[string]*table),
+	}
+	return
+}
+
+// commit 提交当前事务，与其他连接的修改冲突时返回错误，这时事务已经被回滚。
+func (s *session) commit() (err error) {
+	if s.tx == nil {
+		return
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	err = s.db.apply(s.tx)
+	s.tx = nil
+	s.endXA()
+	return
+}
+
+func (s *session) rollback() {
+	s.tx = nil
+	s.endXA()
+}
+
+func (s *session) savepoint(name string) {
+	if s.tx == nil {
+		return
+	}
+
+	s.releaseSavepoint(name)
+	tables := make(map[string]*table, len(s.tx.tables))
+
+	for k, v := range s.tx.tables {
+		tables[k] = v
+	}
+
+	s.tx.savepoints = append(s.tx.savepoints, savepoint{
+		name:   name,
+		tables: tables,
+	})
+}
+
+func (s *session) findSavepoint(name string) int {
+	if s.tx == nil {
+		return -1
+	}
+
+	for i := len(s.tx.savepoints) - 1; i >= 0; i-- {
+		if strings.EqualFold(s.tx.savepoints[i].name, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (s *session) rollbackTo(name string) error {
+	idx := s.findSavepoint(name)
+
+	if idx < 0 {
+		return newErrorf(errSPDoesNotExist, "SAVEPOINT %v does not exist", name)
+	}
+
+	sp := s.tx.savepoints[idx]
+	tables := make(map[string]*table, len(sp.tables))
+
+	for k, v := range sp.tables {
+		tables[k] = v
+	}
+
+	s.tx.tables = tables
+	s.tx.savepoints = s.tx.savepoints[:idx+1]
+	return nil
+}
+
+func (s *session) releaseSavepoint(name string) bool {
+	idx := s.findSavepoint(name)
+
+	if idx < 0 {
+		return false
+	}
+
+	s.tx.savepoints = s.tx.savepoints[:idx]
+	return true
+}
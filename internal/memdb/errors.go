@@ -0,0 +1,42 @@
+package memdb
+
+import (
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 内存数据库会返回与 MySQL 一致的错误号，方便业务代码用同样的逻辑处理错误。
+const (
+	errDupEntry          = 1062
+	errDeadlock          = 1213
+	errTableExists       = 1050
+	errBadTable          = 1051
+	errNoSuchTable       = 1146
+	errBadField          = 1054
+	errSyntax            = 1064
+	errBadNull           = 1048
+	errNoDefault         = 1364
+	errWrongValueCount   = 1136
+	errSPDoesNotExist    = 1305
+	errQueryInterrupted  = 1317
+	errNoSuchThread      = 1094
+	errNotSupported      = 1235
+	errTruncatedWrongVal = 1292
+	errUnknownSysVar     = 1193
+	errXAERNota          = 1397
+	errXAERRmfail        = 1399
+	errXAEROutside       = 1400
+	errXAERDupID         = 1440
+)
+
+func newError(number uint16, message string) error {
+	return &mysql.MySQLError{
+		Number:  number,
+		Message: message,
+	}
+}
+
+func newErrorf(number uint16, format string, args ...interface{}) error {
+	return newError(number, fmt.Sprintf(format, args...))
+}
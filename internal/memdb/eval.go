@@ -0,0 +1,805 @@
+package memdb
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+)
+
+// env 是计算表达式时的上下文。
+type env struct {
+	ctx     context.Context
+	s       *session
+	args    []value
+	table   *table
+	row     []value
+	group   [][]value        // group 不为 nil 时代表正在计算聚合函数。
+	insert  []value          // insert 是 INSERT ... ON DUPLICATE KEY UPDATE 时待插入的行，用于 VALUES(col)。
+	aliases map[string]value // aliases 是 SELECT 里的别名，仅在 HAVING 和 ORDER BY 里可用。
+}
+
+var aggregateFuncs = map[string]bool{
+	"COUNT": true, "SUM": true, "MIN": true, "MAX": true, "AVG": true, "GROUP_CONCAT": true,
+}
+
+func hasAggregate(e expr) bool {
+	switch x := e.(type) {
+	case *funcExpr:
+		if aggregateFuncs[x.Name] {
+			return true
+		}
+
+		for _, arg := range x.Args {
+			if hasAggregate(arg) {
+				return true
+			}
+		}
+	case *unaryExpr:
+		return hasAggregate(x.X)
+	case *binaryExpr:
+		return hasAggregate(x.L) || hasAggregate(x.R)
+	case *isNullExpr:
+		return hasAggregate(x.X)
+	case *inExpr:
+		if hasAggregate(x.X) {
+			return true
+		}
+
+		for _, item := range x.List {
+			if hasAggregate(item) {
+				return true
+			}
+		}
+	case *likeExpr:
+		return hasAggregate(x.X) || hasAggregate(x.Pattern)
+	case *betweenExpr:
+		return hasAggregate(x.X) || hasAggregate(x.Lo) || hasAggregate(x.Hi)
+	case *tupleExpr:
+		for _, item := range x.List {
+			if hasAggregate(item) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (e *env) eval(x expr) (value, error) {
+	switch x := x.(type) {
+	case *literalExpr:
+		return x.Value, nil
+
+	case *paramExpr:
+		if x.Index >= len(e.args) {
+			return nil, newError(1210, "Incorrect arguments to mysqld_stmt_execute")
+		}
+
+		return e.args[x.Index], nil
+
+	case *columnExpr:
+		return e.column(x)
+
+	case *variableExpr:
+		return e.variable(x)
+
+	case *unaryExpr:
+		v, err := e.eval(x.X)
+
+		if err != nil || v == nil {
+			return nil, err
+		}
+
+		if x.Op == "NOT" {
+			b, _ := toBool(v)
+			return boolValue(!b), nil
+		}
+
+		if i, ok := v.(int64); ok {
+			return -i, nil
+		}
+
+		return -toFloat(v), nil
+
+	case *binaryExpr:
+		return e.evalBinary(x)
+
+	case *isNullExpr:
+		v, err := e.eval(x.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return boolValue((v == nil) != x.Not), nil
+
+	case *inExpr:
+		return e.evalIn(x)
+
+	case *likeExpr:
+		v, err := e.eval(x.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pattern, err := e.eval(x.Pattern)
+
+		if err != nil || v == nil || pattern == nil {
+			return nil, err
+		}
+
+		return boolValue(matchLike(toString(v), toString(pattern)) != x.Not), nil
+
+	case *betweenExpr:
+		v, err := e.eval(x.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lo, err := e.eval(x.Lo)
+
+		if err != nil {
+			return nil, err
+		}
+
+		hi, err := e.eval(x.Hi)
+
+		if err != nil || v == nil || lo == nil || hi == nil {
+			return nil, err
+		}
+
+		return boolValue((compare(v, lo) >= 0 && compare(v, hi) <= 0) != x.Not), nil
+
+	case *tupleExpr:
+		return nil, newError(1241, "Operand should contain 1 column(s)")
+
+	case *funcExpr:
+		if aggregateFuncs[x.Name] {
+			return e.evalAggregate(x)
+		}
+
+		return e.evalFunc(x)
+	}
+
+	return nil, newError(errNotSupported, "This version of MySQL doesn't yet support this expression")
+}
+
+func (e *env) column(x *columnExpr) (value, error) {
+	if x.Table == "" && e.aliases != nil {
+		if v, ok := e.aliases[strings.ToLower(x.Name)]; ok {
+			return v, nil
+		}
+	}
+
+	if e.table == nil {
+		return nil, newErrorf(errBadField, "Unknown column '%v' in 'field list'", x.Name)
+	}
+
+	idx, ok := e.table.column(x.Name)
+
+	if !ok {
+		return nil, newErrorf(errBadField, "Unknown column '%v' in 'field list'", x.Name)
+	}
+
+	if e.row == nil {
+		return nil, nil
+	}
+
+	return e.row[idx], nil
+}
+
+func (e *env) variable(x *variableExpr) (value, error) {
+	switch x.Name {
+	case "gtid_executed":
+		return e.s.db.GTIDExecuted(), nil
+	case "server_uuid":
+		return e.s.db.UUID, nil
+	case "version":
+		return Version, nil
+	case "autocommit":
+		return boolValue(e.s.tx == nil), nil
+	}
+
+	return nil, newErrorf(errUnknownSysVar, "Unknown system variable '%v'", x.Name)
+}
+
+func (e *env) evalBinary(x *binaryExpr) (value, error) {
+	switch x.Op {
+	case "AND", "OR":
+		l, err := e.eval(x.L)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lb, lnull := toBool(l)
+
+		// 短路求值。
+		if !lnull && (x.Op == "AND" && !lb || x.Op == "OR" && lb) {
+			return boolValue(lb), nil
+		}
+
+		r, err := e.eval(x.R)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rb, rnull := toBool(r)
+
+		if x.Op == "AND" {
+			switch {
+			case !rnull && !rb:
+				return boolValue(false), nil
+			case lnull || rnull:
+				return nil, nil
+			}
+
+			return boolValue(true), nil
+		}
+
+		switch {
+		case !rnull && rb:
+			return boolValue(true), nil
+		case lnull || rnull:
+			return nil, nil
+		}
+
+		return boolValue(false), nil
+	}
+
+	if lt, ok := x.L.(*tupleExpr); ok {
+		rt, ok := x.R.(*tupleExpr)
+
+		if !ok || len(lt.List) != len(rt.List) {
+			return nil, newErrorf(1241, "Operand should contain %v column(s)", len(lt.List))
+		}
+
+		return e.compareTuple(x.Op, lt, rt)
+	}
+
+	l, err := e.eval(x.L)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := e.eval(x.R)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if x.Op == "<=>" {
+		if l == nil || r == nil {
+			return boolValue(l == nil && r == nil), nil
+		}
+
+		return boolValue(compare(l, r) == 0), nil
+	}
+
+	if l == nil || r == nil {
+		return nil, nil
+	}
+
+	switch x.Op {
+	case "=", "<>", "<", "<=", ">", ">=":
+		return boolValue(compareOp(x.Op, compare(l, r))), nil
+	}
+
+	return arithmetic(x.Op, l, r), nil
+}
+
+func compareOp(op string, c int) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+// compareTuple 按照字典序比较两个 tuple，比如 (a, b) > (1, 2)。
+func (e *env) compareTuple(op string, lt, rt *tupleExpr) (value, error) {
+	for i := range lt.List {
+		l, err := e.eval(lt.List[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := e.eval(rt.List[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if l == nil || r == nil {
+			if op == "<=>" {
+				if l == nil && r == nil {
+					continue
+				}
+
+				return boolValue(false), nil
+			}
+
+			return nil, nil
+		}
+
+		c := compare(l, r)
+
+		if c != 0 || i == len(lt.List)-1 {
+			if op == "<=>" {
+				op = "="
+			}
+
+			return boolValue(compareOp(op, c)), nil
+		}
+	}
+
+	return boolValue(op == "=" || op == "<=>" || op == "<=" || op == ">="), nil
+}
+
+func arithmetic(op string, l, r value) value {
+	li, lok := l.(int64)
+	ri, rok := r.(int64)
+
+	if lok && rok {
+		switch op {
+		case "+":
+			return li + ri
+		case "-":
+			return li - ri
+		case "*":
+			return li * ri
+		case "DIV", "%":
+			if ri == 0 {
+				return nil
+			}
+
+			if op == "DIV" {
+				return li / ri
+			}
+
+			return li % ri
+		}
+	}
+
+	lf, rf := toFloat(l), toFloat(r)
+
+	switch op {
+	case "+":
+		return lf + rf
+	case "-":
+		return lf - rf
+	case "*":
+		return lf * rf
+	case "/":
+		if rf == 0 {
+			return nil
+		}
+
+		return lf / rf
+	case "DIV":
+		if rf == 0 {
+			return nil
+		}
+
+		return int64(lf / rf)
+	case "%":
+		if rf == 0 {
+			return nil
+		}
+
+		return math.Mod(lf, rf)
+	}
+
+	return nil
+}
+
+func (e *env) evalIn(x *inExpr) (value, error) {
+	v, err := e.eval(x.X)
+
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	null := false
+
+	for _, item := range x.List {
+		iv, err := e.eval(item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if iv == nil {
+			null = true
+			continue
+		}
+
+		if compare(v, iv) == 0 {
+			return boolValue(!x.Not), nil
+		}
+	}
+
+	if null {
+		return nil, nil
+	}
+
+	return boolValue(x.Not), nil
+}
+
+func (e *env) evalAggregate(x *funcExpr) (value, error) {
+	if e.group == nil {
+		return nil, newError(1111, "Invalid use of group function")
+	}
+
+	if !x.Star && len(x.Args) == 0 {
+		return nil, newErrorf(1582, "Incorrect parameter count in the call to native function '%v'", x.Name)
+	}
+
+	var values []value
+	seen := map[string]bool{}
+	sub := *e
+	sub.group = nil
+
+	for _, row := range e.group {
+		if x.Star {
+			values = append(values, int64(1))
+			continue
+		}
+
+		sub.row = row
+		v, err := sub.eval(x.Args[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if v == nil {
+			continue
+		}
+
+		if x.Distinct {
+			key := toString(v)
+
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+		}
+
+		values = append(values, v)
+	}
+
+	switch x.Name {
+	case "COUNT":
+		return int64(len(values)), nil
+
+	case "SUM", "AVG":
+		if len(values) == 0 {
+			return nil, nil
+		}
+
+		var isum int64
+		var fsum float64
+		isFloat := false
+
+		for _, v := range values {
+			if i, ok := v.(int64); ok && !isFloat {
+				isum += i
+				continue
+			}
+
+			if !isFloat {
+				isFloat = true
+				fsum = float64(isum)
+			}
+
+			fsum += toFloat(v)
+		}
+
+		if x.Name == "AVG" {
+			if !isFloat {
+				fsum = float64(isum)
+			}
+
+			return fsum / float64(len(values)), nil
+		}
+
+		if isFloat {
+			return fsum, nil
+		}
+
+		return isum, nil
+
+	case "MIN", "MAX":
+		var result value
+
+		for _, v := range values {
+			if result == nil {
+				result = v
+				continue
+			}
+
+			c := compare(v, result)
+
+			if x.Name == "MIN" && c < 0 || x.Name == "MAX" && c > 0 {
+				result = v
+			}
+		}
+
+		return result, nil
+
+	case "GROUP_CONCAT":
+		if len(values) == 0 {
+			return nil, nil
+		}
+
+		strs := make([]string, 0, len(values))
+
+		for _, v := range values {
+			strs = append(strs, toString(v))
+		}
+
+		return strings.Join(strs, ","), nil
+	}
+
+	return nil, nil
+}
+
+func (e *env) evalArgs(x *funcExpr) (args []value, err error) {
+	args = make([]value, 0, len(x.Args))
+
+	for _, arg := range x.Args {
+		v, err := e.eval(arg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, v)
+	}
+
+	return
+}
+
+func (e *env) evalFunc(x *funcExpr) (value, error) {
+	if x.Name == "VALUES" {
+		if len(x.Args) != 1 {
+			return nil, newError(errSyntax, "You have an error in your SQL syntax near 'VALUES'")
+		}
+
+		col, ok := x.Args[0].(*columnExpr)
+
+		if !ok {
+			return nil, newError(errSyntax, "You have an error in your SQL syntax near 'VALUES'")
+		}
+
+		if e.insert == nil {
+			return nil, nil
+		}
+
+		idx, ok := e.table.column(col.Name)
+
+		if !ok {
+			return nil, newErrorf(errBadField, "Unknown column '%v' in 'field list'", col.Name)
+		}
+
+		return e.insert[idx], nil
+	}
+
+	args, err := e.evalArgs(x)
+
+	if err != nil {
+		return nil, err
+	}
+
+	argc := func(min, max int) error {
+		if len(args) < min || max >= 0 && len(args) > max {
+			return newErrorf(1582, "Incorrect parameter count in the call to native function '%v'", x.Name)
+		}
+
+		return nil
+	}
+
+	switch x.Name {
+	case "NOW", "CURRENT_TIMESTAMP", "SYSDATE", "LOCALTIMESTAMP", "LOCALTIME":
+		return time.Now().Truncate(time.Second), nil
+
+	case "UNIX_TIMESTAMP":
+		if len(args) == 0 {
+			return time.Now().Unix(), nil
+		}
+
+		t, ok := toTime(args[0])
+
+		if !ok {
+			return nil, nil
+		}
+
+		return t.Unix(), nil
+
+	case "CONNECTION_ID":
+		return e.s.id, nil
+
+	case "LAST_INSERT_ID":
+		return e.s.lastInsertID, nil
+
+	case "DATABASE", "SCHEMA":
+		return e.s.db.Name, nil
+
+	case "VERSION":
+		return Version, nil
+
+	case "GTID_SUBSET":
+		if err := argc(2, 2); err != nil {
+			return nil, err
+		}
+
+		if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+
+		sub, err := parseGTIDSet(toString(args[0]))
+
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := parseGTIDSet(toString(args[1]))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return boolValue(sub.subsetOf(set)), nil
+
+	case "SLEEP":
+		if err := argc(1, 1); err != nil {
+			return nil, err
+		}
+
+		return sleep(e.ctx, toFloat(args[0]))
+
+	case "IFNULL", "COALESCE":
+		for _, arg := range args {
+			if arg != nil {
+				return arg, nil
+			}
+		}
+
+		return nil, nil
+
+	case "IF":
+		if err := argc(3, 3); err != nil {
+			return nil, err
+		}
+
+		if b, _ := toBool(args[0]); b {
+			return args[1], nil
+		}
+
+		return args[2], nil
+
+	case "LOWER", "LCASE", "UPPER", "UCASE", "LENGTH", "CHAR_LENGTH":
+		if err := argc(1, 1); err != nil {
+			return nil, err
+		}
+
+		if args[0] == nil {
+			return nil, nil
+		}
+
+		s := toString(args[0])
+
+		switch x.Name {
+		case "LOWER", "LCASE":
+			return strings.ToLower(s), nil
+		case "UPPER", "UCASE":
+			return strings.ToUpper(s), nil
+		case "LENGTH":
+			return int64(len(s)), nil
+		default:
+			return int64(len([]rune(s))), nil
+		}
+
+	case "CONCAT":
+		buf := &strings.Builder{}
+
+		for _, arg := range args {
+			if arg == nil {
+				return nil, nil
+			}
+
+			buf.WriteString(toString(arg))
+		}
+
+		return buf.String(), nil
+
+	case "ABS", "FLOOR", "CEIL", "CEILING", "ROUND":
+		if err := argc(1, 1); err != nil {
+			return nil, err
+		}
+
+		if args[0] == nil {
+			return nil, nil
+		}
+
+		if i, ok := args[0].(int64); ok {
+			if x.Name == "ABS" && i < 0 {
+				return -i, nil
+			}
+
+			return i, nil
+		}
+
+		f := toFloat(args[0])
+
+		switch x.Name {
+		case "ABS":
+			return math.Abs(f), nil
+		case "FLOOR":
+			return int64(math.Floor(f)), nil
+		case "CEIL", "CEILING":
+			return int64(math.Ceil(f)), nil
+		default:
+			return int64(math.Round(f)), nil
+		}
+
+	case "GREATEST", "LEAST":
+		if err := argc(1, -1); err != nil {
+			return nil, err
+		}
+
+		result := args[0]
+
+		for _, arg := range args {
+			if arg == nil {
+				return nil, nil
+			}
+
+			c := compare(arg, result)
+
+			if x.Name == "GREATEST" && c > 0 || x.Name == "LEAST" && c < 0 {
+				result = arg
+			}
+		}
+
+		return result, nil
+	}
+
+	return nil, newErrorf(1305, "FUNCTION %v.%v does not exist", e.s.db.Name, strings.ToLower(x.Name))
+}
+
+// sleep 实现 SLEEP(n)，与 MySQL 一样，被中断时返回 1，否则返回 0。
+func sleep(ctx context.Context, seconds float64) (value, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return int64(0), nil
+	case <-ctx.Done():
+		return int64(1), nil
+	}
+}
@@ -0,0 +1,1132 @@
+package memdb
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// result 是一条语句的执行结果。
+type result struct {
+	Columns      []string
+	Types        []string
+	Rows         [][]value
+	Affected     int64
+	LastInsertID int64
+}
+
+// exec 执行一条已经解析过的语句。
+func (s *session) exec(ctx context.Context, stmt statement, args []value) (res *result, err error) {
+	if err = s.db.fault(); err != nil {
+		return
+	}
+
+	if show, ok := stmt.(*showStmt); !ok || show.What != "WARNINGS" {
+		s.warnings = nil
+	}
+
+	switch stmt.(type) {
+	case *beginStmt, *commitStmt, *rollbackStmt:
+		// 与 MySQL 一样，XA 事务中不能使用普通的事务语句。
+		if s.xid != nil {
+			return nil, s.xaStateError()
+		}
+	case *xaStmt:
+	default:
+		// XA END 之后直到 PREPARE 之前不能再执行任何语句。
+		if s.xaState == xaIdle {
+			return nil, s.xaStateError()
+		}
+	}
+
+	// 与 MySQL 一样，insert_id 只对下一条语句有效。
+	if set, ok := stmt.(*setInsertIDStmt); ok {
+		s.insertID = set.ID
+		return &result{}, nil
+	}
+
+	defer func() {
+		s.insertID = 0
+	}()
+
+	switch stmt := stmt.(type) {
+	case *xaStmt:
+		return s.execXA(stmt)
+	case *beginStmt:
+		return &result{}, s.begin()
+	case *commitStmt:
+		return &result{}, s.commit()
+	case *rollbackStmt:
+		if stmt.Savepoint != "" {
+			return &result{}, s.rollbackTo(stmt.Savepoint)
+		}
+
+		s.rollback()
+		return &result{}, nil
+	case *savepointStmt:
+		s.savepoint(stmt.Name)
+		return &result{}, nil
+	case *releaseStmt:
+		if !s.releaseSavepoint(stmt.Name) {
+			return nil, newErrorf(errSPDoesNotExist, "SAVEPOINT %v does not exist", stmt.Name)
+		}
+
+		return &result{}, nil
+	case *ignoredStmt:
+		return &result{}, nil
+	case *killStmt:
+		return s.execKill(stmt)
+	case *loadDataStmt:
+		// 读取数据时不能持有锁，execLoadData 会自己加锁。
+		return s.execLoadData(ctx, stmt)
+	case *showStmt:
+		if stmt.What == "WARNINGS" {
+			return s.execShowWarnings(), nil
+		}
+	case *selectStmt:
+		if stmt.Table == "" {
+			// 没有 FROM 的查询不需要访问任何表，不加锁，这样 SLEEP 之类的函数不会阻塞其他连接。
+			return s.execSelect(ctx, stmt, args)
+		}
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		return s.execSelect(ctx, stmt, args)
+	case *insertStmt:
+		return s.execInsert(ctx, stmt, args)
+	case *updateStmt:
+		return s.execUpdate(ctx, stmt, args)
+	case *deleteStmt:
+		return s.execDelete(ctx, stmt, args)
+	case *createTableStmt:
+		return s.execCreateTable(stmt)
+	case *dropTableStmt:
+		return s.execDropTable(stmt)
+	case *truncateStmt:
+		return s.execTruncate(stmt)
+	case *showStmt:
+		return s.execShow(stmt)
+	case *explainStmt:
+		return s.execExplain(stmt)
+	}
+
+	return nil, newError(errNotSupported, "This version of MySQL doesn't yet support this statement")
+}
+
+func (s *session) execCreateTable(stmt *createTableStmt) (*result, error) {
+	key := strings.ToLower(stmt.Table)
+
+	if _, ok := s.db.tables[key]; ok {
+		if stmt.IfNotExists {
+			return &result{}, nil
+		}
+
+		return nil, newErrorf(errTableExists, "Table '%v' already exists", stmt.Table)
+	}
+
+	t, err := newTable(stmt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.db.tables[key] = t
+	s.db.wrote()
+	return &result{}, nil
+}
+
+func (s *session) execDropTable(stmt *dropTableStmt) (*result, error) {
+	for _, name := range stmt.Tables {
+		key := strings.ToLower(name)
+
+		if _, ok := s.db.tables[key]; !ok && !stmt.IfExists {
+			return nil, newErrorf(errBadTable, "Unknown table '%v.%v'", s.db.Name, name)
+		}
+	}
+
+	for _, name := range stmt.Tables {
+		key := strings.ToLower(name)
+		delete(s.db.tables, key)
+
+		if s.tx != nil {
+			delete(s.tx.tables, key)
+			delete(s.tx.base, key)
+		}
+	}
+
+	s.db.wrote()
+	return &result{}, nil
+}
+
+func (s *session) execTruncate(stmt *truncateStmt) (*result, error) {
+	t, err := s.lookup(stmt.Table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cp := t.clone()
+	cp.Rows = nil
+	cp.AutoIncrement = 1
+	s.db.tables[strings.ToLower(t.Name)] = cp
+
+	if s.tx != nil {
+		delete(s.tx.tables, strings.ToLower(t.Name))
+		delete(s.tx.base, strings.ToLower(t.Name))
+	}
+
+	s.db.wrote()
+	return &result{}, nil
+}
+
+func (s *session) execShow(stmt *showStmt) (*result, error) {
+	switch stmt.What {
+	case "TABLES":
+		res := &result{
+			Columns: []string{"Tables_in_" + s.db.Name},
+			Types:   []string{"VARCHAR"},
+		}
+		names := make([]string, 0, len(s.db.tables))
+
+		for _, t := range s.db.tables {
+			names = append(names, t.Name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			res.Rows = append(res.Rows, []value{name})
+		}
+
+		return res, nil
+
+	case "SLAVE STATUS":
+		// 内存数据库不是任何数据库的从库，与 MySQL 一样返回空结果。
+		return &result{
+			Columns: []string{"Slave_IO_State", "Master_Host", "Slave_IO_Running", "Slave_SQL_Running", "Seconds_Behind_Master"},
+			Types:   []string{"VARCHAR", "VARCHAR", "VARCHAR", "VARCHAR", "BIGINT"},
+		}, nil
+	}
+
+	return nil, newError(errNotSupported, "This version of MySQL doesn't yet support this statement")
+}
+
+// execExplain 执行 EXPLAIN SELECT，结果的格式与 MySQL 一致，但只包含常用的列。
+// 内存数据库查询时总是遍历整张表，因此 type 总是 ALL。
+func (s *session) execExplain(stmt *explainStmt) (*result, error) {
+	res := &result{
+		Columns: []string{"id", "select_type", "table", "type", "possible_keys", "key", "rows", "Extra"},
+		Types:   []string{"BIGINT", "VARCHAR", "VARCHAR", "VARCHAR", "VARCHAR", "VARCHAR", "BIGINT", "VARCHAR"},
+	}
+
+	if stmt.Select.Table == "" {
+		res.Rows = [][]value{{int64(1), "SIMPLE", nil, nil, nil, nil, nil, "No tables used"}}
+		return res, nil
+	}
+
+	t, err := s.lookup(stmt.Select.Table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var extra value
+
+	if stmt.Select.Where != nil {
+		extra = "Using where"
+	}
+
+	res.Rows = [][]value{{int64(1), "SIMPLE", t.Name, "ALL", nil, nil, int64(len(t.Rows)), extra}}
+	return res, nil
+}
+
+func (s *session) execShowWarnings() *result {
+	res := &result{
+		Columns: []string{"Level", "Code", "Message"},
+		Types:   []string{"VARCHAR", "INT", "VARCHAR"},
+	}
+
+	for _, w := range s.warnings {
+		res.Rows = append(res.Rows, []value{"Warning", int64(w.Code), w.Message})
+	}
+
+	return res
+}
+
+// filter 返回 t 中满足 where 条件的所有行号。
+func (s *session) filter(e *env, t *table, where expr) (matched []int, err error) {
+	for i, row := range t.Rows {
+		if row == nil {
+			continue
+		}
+
+		if where != nil {
+			e.row = row
+			v, err := e.eval(where)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if b, _ := toBool(v); !b {
+				continue
+			}
+		}
+
+		matched = append(matched, i)
+	}
+
+	return
+}
+
+// sortByKeys 按照排序键对 idx 排序，keys[i] 是行号 i 对应的排序键。
+func sortByKeys(items []orderItem, idx []int, keys [][]value) {
+	sort.SliceStable(idx, func(i, j int) bool {
+		ki, kj := keys[idx[i]], keys[idx[j]]
+
+		for n, item := range items {
+			a, b := ki[n], kj[n]
+			c := 0
+
+			switch {
+			case a == nil && b == nil:
+				c = 0
+			case a == nil:
+				c = -1
+			case b == nil:
+				c = 1
+			default:
+				c = compare(a, b)
+			}
+
+			if item.Desc {
+				c = -c
+			}
+
+			if c != 0 {
+				return c < 0
+			}
+		}
+
+		return false
+	})
+}
+
+func (s *session) evalLimit(e *env, x expr) (n int64, err error) {
+	v, err := e.eval(x)
+
+	if err != nil {
+		return
+	}
+
+	if !isNumber(v) {
+		if _, ok := v.(string); !ok {
+			err = newError(1210, "Incorrect arguments to LIMIT")
+			return
+		}
+	}
+
+	n = toInt(v)
+
+	if n < 0 {
+		err = newError(1210, "Incorrect arguments to LIMIT")
+	}
+
+	return
+}
+
+// orderAndLimit 对 t 中匹配的行排序并截取，用于 UPDATE 和 DELETE。
+func (s *session) orderAndLimit(e *env, t *table, matched []int, orderBy []orderItem, limit expr) ([]int, error) {
+	if len(orderBy) > 0 {
+		keys := make([][]value, len(t.Rows))
+
+		for _, i := range matched {
+			e.row = t.Rows[i]
+			key := make([]value, 0, len(orderBy))
+
+			for _, item := range orderBy {
+				v, err := e.eval(item.Expr)
+
+				if err != nil {
+					return nil, err
+				}
+
+				key = append(key, v)
+			}
+
+			keys[i] = key
+		}
+
+		sortByKeys(orderBy, matched, keys)
+	}
+
+	if limit != nil {
+		n, err := s.evalLimit(e, limit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(len(matched)) > n {
+			matched = matched[:n]
+		}
+	}
+
+	return matched, nil
+}
+
+func (s *session) execSelect(ctx context.Context, stmt *selectStmt, args []value) (*result, error) {
+	e := &env{
+		ctx:  ctx,
+		s:    s,
+		args: args,
+	}
+	var rows [][]value
+
+	if stmt.Table != "" {
+		t, err := s.lookup(stmt.Table)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e.table = t
+		matched, err := s.filter(e, t, stmt.Where)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rows = make([][]value, 0, len(matched))
+
+		for _, i := range matched {
+			rows = append(rows, t.Rows[i])
+		}
+	} else {
+		// 没有 FROM 的查询相当于在一张只有一行的表上查询。
+		rows = [][]value{{}}
+
+		if stmt.Where != nil {
+			v, err := e.eval(stmt.Where)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if b, _ := toBool(v); !b {
+				rows = nil
+			}
+		}
+	}
+
+	res := &result{}
+
+	for _, item := range stmt.Items {
+		if item.Star {
+			if e.table == nil {
+				return nil, newError(1096, "No tables used")
+			}
+
+			for _, col := range e.table.Columns {
+				res.Columns = append(res.Columns, col.Name)
+				res.Types = append(res.Types, col.TypeName)
+			}
+
+			continue
+		}
+
+		name := item.Alias
+
+		if name == "" {
+			if col, ok := item.Expr.(*columnExpr); ok {
+				name = col.Name
+			} else {
+				name = item.Text
+			}
+		}
+
+		res.Columns = append(res.Columns, name)
+		res.Types = append(res.Types, exprTypeName(e.table, item.Expr))
+	}
+
+	aggregate := len(stmt.GroupBy) > 0 || hasAggregate(stmt.Having)
+
+	for _, item := range stmt.Items {
+		if !item.Star && hasAggregate(item.Expr) {
+			aggregate = true
+		}
+	}
+
+	for _, item := range stmt.OrderBy {
+		if hasAggregate(item.Expr) {
+			aggregate = true
+		}
+	}
+
+	var groups [][][]value
+
+	if aggregate {
+		var err error
+		groups, err = s.group(e, stmt, rows)
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		groups = make([][][]value, 0, len(rows))
+
+		for _, row := range rows {
+			groups = append(groups, [][]value{row})
+		}
+	}
+
+	type output struct {
+		values []value
+		keys   []value
+	}
+	outputs := make([]output, 0, len(groups))
+
+	for _, group := range groups {
+		if len(group) > 0 {
+			e.row = group[0]
+		} else {
+			e.row = nil
+		}
+
+		if aggregate {
+			e.group = group
+		}
+
+		e.aliases = nil
+		values := make([]value, 0, len(res.Columns))
+
+		for _, item := range stmt.Items {
+			if item.Star {
+				values = append(values, e.row...)
+				continue
+			}
+
+			v, err := e.eval(item.Expr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, v)
+		}
+
+		if stmt.Having != nil || len(stmt.OrderBy) > 0 {
+			e.aliases = make(map[string]value, len(stmt.Items))
+
+			for i, item := range stmt.Items {
+				if item.Alias != "" {
+					e.aliases[strings.ToLower(item.Alias)] = values[i]
+				}
+			}
+		}
+
+		if stmt.Having != nil {
+			v, err := e.eval(stmt.Having)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if b, _ := toBool(v); !b {
+				continue
+			}
+		}
+
+		keys := make([]value, 0, len(stmt.OrderBy))
+
+		for _, item := range stmt.OrderBy {
+			// ORDER BY 1 代表按照第一列排序。
+			if lit, ok := item.Expr.(*literalExpr); ok {
+				if pos, ok := lit.Value.(int64); ok {
+					if pos < 1 || int(pos) > len(values) {
+						return nil, newErrorf(errBadField, "Unknown column '%v' in 'order clause'", pos)
+					}
+
+					keys = append(keys, values[pos-1])
+					continue
+				}
+			}
+
+			v, err := e.eval(item.Expr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			keys = append(keys, v)
+		}
+
+		outputs = append(outputs, output{
+			values: values,
+			keys:   keys,
+		})
+	}
+
+	if stmt.Distinct {
+		seen := map[string]bool{}
+		distinct := outputs[:0]
+
+		for _, out := range outputs {
+			key := rowKey(out.values)
+
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			distinct = append(distinct, out)
+		}
+
+		outputs = distinct
+	}
+
+	if len(stmt.OrderBy) > 0 {
+		idx := make([]int, len(outputs))
+		keys := make([][]value, len(outputs))
+
+		for i := range outputs {
+			idx[i] = i
+			keys[i] = outputs[i].keys
+		}
+
+		sortByKeys(stmt.OrderBy, idx, keys)
+		sorted := make([]output, len(outputs))
+
+		for i, n := range idx {
+			sorted[i] = outputs[n]
+		}
+
+		outputs = sorted
+	}
+
+	e.row = nil
+	e.group = nil
+	e.aliases = nil
+
+	if stmt.Offset != nil {
+		n, err := s.evalLimit(e, stmt.Offset)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n > int64(len(outputs)) {
+			n = int64(len(outputs))
+		}
+
+		outputs = outputs[n:]
+	}
+
+	if stmt.Limit != nil {
+		n, err := s.evalLimit(e, stmt.Limit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n < int64(len(outputs)) {
+			outputs = outputs[:n]
+		}
+	}
+
+	res.Rows = make([][]value, 0, len(outputs))
+
+	for _, out := range outputs {
+		res.Rows = append(res.Rows, out.values)
+	}
+
+	return res, nil
+}
+
+// group 根据 GROUP BY 将 rows 分组，没有 GROUP BY 时所有的行都在同一组里。
+func (s *session) group(e *env, stmt *selectStmt, rows [][]value) (groups [][][]value, err error) {
+	if len(stmt.GroupBy) == 0 {
+		return [][][]value{rows}, nil
+	}
+
+	index := map[string]int{}
+	exprs := make([]expr, 0, len(stmt.GroupBy))
+
+	for _, x := range stmt.GroupBy {
+		exprs = append(exprs, resolveAlias(e.table, stmt.Items, x))
+	}
+
+	for _, row := range rows {
+		e.row = row
+		key := make([]value, 0, len(exprs))
+
+		for _, x := range exprs {
+			v, err := e.eval(x)
+
+			if err != nil {
+				return nil, err
+			}
+
+			key = append(key, v)
+		}
+
+		k := rowKey(key)
+		n, ok := index[k]
+
+		if !ok {
+			n = len(groups)
+			index[k] = n
+			groups = append(groups, nil)
+		}
+
+		groups[n] = append(groups[n], row)
+	}
+
+	return
+}
+
+// resolveAlias 将 GROUP BY 里引用的别名或者列序号替换成 SELECT 里对应的表达式。
+func resolveAlias(t *table, items []selectItem, x expr) expr {
+	switch x := x.(type) {
+	case *columnExpr:
+		if x.Table != "" {
+			return x
+		}
+
+		if t != nil {
+			if _, ok := t.column(x.Name); ok {
+				return x
+			}
+		}
+
+		for _, item := range items {
+			if item.Alias != "" && strings.EqualFold(item.Alias, x.Name) {
+				return item.Expr
+			}
+		}
+
+	case *literalExpr:
+		if pos, ok := x.Value.(int64); ok && pos >= 1 && int(pos) <= len(items) && !items[pos-1].Star {
+			return items[pos-1].Expr
+		}
+	}
+
+	return x
+}
+
+func rowKey(values []value) string {
+	buf := &strings.Builder{}
+
+	for _, v := range values {
+		if v == nil {
+			buf.WriteString("\x00N")
+		} else {
+			buf.WriteString("\x00V")
+			buf.WriteString(toString(v))
+		}
+	}
+
+	return buf.String()
+}
+
+func exprTypeName(t *table, x expr) string {
+	switch x := x.(type) {
+	case *columnExpr:
+		if t != nil {
+			if idx, ok := t.column(x.Name); ok {
+				return t.Columns[idx].TypeName
+			}
+		}
+	case *literalExpr:
+		switch x.Value.(type) {
+		case int64:
+			return "BIGINT"
+		case float64:
+			return "DOUBLE"
+		case string:
+			return "VARCHAR"
+		}
+	case *funcExpr:
+		switch x.Name {
+		case "COUNT", "CONNECTION_ID", "LAST_INSERT_ID", "LENGTH", "CHAR_LENGTH":
+			return "BIGINT"
+		case "NOW", "CURRENT_TIMESTAMP", "SYSDATE":
+			return "DATETIME"
+		case "MIN", "MAX":
+			if len(x.Args) == 1 {
+				return exprTypeName(t, x.Args[0])
+			}
+		case "SUM", "AVG":
+			return "DECIMAL"
+		}
+	}
+
+	return "VARCHAR"
+}
+
+// newRow 根据 INSERT 的列和值构造一个完整的行，未指定的列使用默认值。
+func (s *session) newRow(e *env, t *table, cols []int, exprs []expr, explicit []bool) (row []value, err error) {
+	row = make([]value, len(t.Columns))
+
+	for i := range explicit {
+		explicit[i] = false
+	}
+
+	for i, idx := range cols {
+		v, err := e.eval(exprs[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if row[idx], err = convert(t.Columns[idx], v); err != nil {
+			return nil, err
+		}
+
+		explicit[idx] = true
+	}
+
+	for i, col := range t.Columns {
+		if explicit[i] {
+			continue
+		}
+
+		if col.Default != nil {
+			v, err := e.eval(col.Default)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if row[i], err = convert(col, v); err != nil {
+				return nil, err
+			}
+		} else if col.NotNull && !col.AutoIncrement {
+			return nil, newErrorf(errNoDefault, "Field '%v' doesn't have a default value", col.Name)
+		}
+	}
+
+	return
+}
+
+// fillAutoIncrement 为自增列生成值，返回生成的值，没有生成则返回 0。
+func fillAutoIncrement(t *table, row []value) (generated int64) {
+	for i, col := range t.Columns {
+		if !col.AutoIncrement {
+			continue
+		}
+
+		if row[i] == nil || toInt(row[i]) == 0 {
+			generated = t.AutoIncrement
+			row[i] = generated
+			t.AutoIncrement++
+		} else if n := toInt(row[i]); n >= t.AutoIncrement {
+			t.AutoIncrement = n + 1
+		}
+	}
+
+	return
+}
+
+func checkNotNull(t *table, row []value) error {
+	for i, col := range t.Columns {
+		if col.NotNull && row[i] == nil {
+			return newErrorf(errBadNull, "Column '%v' cannot be null", col.Name)
+		}
+	}
+
+	return nil
+}
+
+func (s *session) execInsert(ctx context.Context, stmt *insertStmt, args []value) (*result, error) {
+	orig, err := s.lookup(stmt.Table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := orig.clone()
+
+	if s.tx != nil {
+		s.shareAutoIncrement(t)
+	}
+	e := &env{
+		ctx:   ctx,
+		s:     s,
+		args:  args,
+		table: t,
+	}
+	var cols []int
+
+	if stmt.Columns == nil {
+		for i := range t.Columns {
+			cols = append(cols, i)
+		}
+	} else {
+		for _, name := range stmt.Columns {
+			idx, ok := t.column(name)
+
+			if !ok {
+				return nil, newErrorf(errBadField, "Unknown column '%v' in 'field list'", name)
+			}
+
+			cols = append(cols, idx)
+		}
+	}
+
+	res := &result{}
+	sets := t.buildKeySets()
+	explicit := make([]bool, len(t.Columns))
+	deleted := false
+	next := t.AutoIncrement
+
+	// SET insert_id 指定了这条语句生成的第一个自增值，之后的自增值依次递增。
+	if s.insertID != 0 {
+		t.AutoIncrement = s.insertID
+	}
+
+	for _, exprs := range stmt.Rows {
+		if len(exprs) != len(cols) {
+			return nil, newError(errWrongValueCount, "Column count doesn't match value count at row 1")
+		}
+
+		row, err := s.newRow(e, t, cols, exprs, explicit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		autoInc := t.AutoIncrement
+		generated := fillAutoIncrement(t, row)
+
+		if err := checkNotNull(t, row); err != nil {
+			return nil, err
+		}
+
+		conflict, key, keyValue := t.findConflict(sets, row, -1)
+
+		if conflict >= 0 {
+			switch {
+			case stmt.Replace:
+				for conflict >= 0 {
+					t.removeKeys(sets, t.Rows[conflict])
+					t.Rows[conflict] = nil
+					deleted = true
+					res.Affected++
+					conflict, _, _ = t.findConflict(sets, row, -1)
+				}
+
+			case stmt.OnDuplicate != nil:
+				// 发生冲突时自增值不应该被消耗。
+				t.AutoIncrement = autoInc
+				affected, err := s.updateOnDuplicate(e, t, sets, conflict, row, stmt.OnDuplicate)
+
+				if err != nil {
+					return nil, err
+				}
+
+				res.Affected += affected
+				continue
+
+			case stmt.Ignore:
+				t.AutoIncrement = autoInc
+				continue
+
+			default:
+				return nil, dupEntryError(key, keyValue)
+			}
+		}
+
+		if generated != 0 && res.LastInsertID == 0 {
+			res.LastInsertID = generated
+		}
+
+		t.Rows = append(t.Rows, row)
+		t.addKeys(sets, row, len(t.Rows)-1)
+		res.Affected++
+	}
+
+	if deleted {
+		t.compact()
+	}
+
+	if next > t.AutoIncrement {
+		t.AutoIncrement = next
+	}
+
+	if res.LastInsertID != 0 {
+		s.lastInsertID = res.LastInsertID
+	}
+
+	s.store(t)
+	return res, nil
+}
+
+// updateOnDuplicate 处理 ON DUPLICATE KEY UPDATE，返回值与 MySQL 一致：
+// 更新了数据返回 2，数据没有变化返回 0。
+func (s *session) updateOnDuplicate(e *env, t *table, sets []keySet, rowIdx int, insert []value, assignments []assignment) (int64, error) {
+	e.insert = insert
+	defer func() {
+		e.insert = nil
+	}()
+
+	old := t.Rows[rowIdx]
+	row, err := s.assign(e, t, old, assignments)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if rowKey(row) == rowKey(old) {
+		return 0, nil
+	}
+
+	if err := s.replaceRow(t, sets, rowIdx, row); err != nil {
+		return 0, err
+	}
+
+	return 2, nil
+}
+
+// assign 根据 assignments 计算 old 更新后的新行，old 本身不会被修改。
+func (s *session) assign(e *env, t *table, old []value, assignments []assignment) (row []value, err error) {
+	row = make([]value, len(old))
+	copy(row, old)
+	e.row = row
+
+	for _, set := range assignments {
+		idx, ok := t.column(set.Column)
+
+		if !ok {
+			return nil, newErrorf(errBadField, "Unknown column '%v' in 'field list'", set.Column)
+		}
+
+		v, err := e.eval(set.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if row[idx], err = convert(t.Columns[idx], v); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = checkNotNull(t, row); err != nil {
+		return nil, err
+	}
+
+	for i, col := range t.Columns {
+		if col.AutoIncrement && row[i] != nil {
+			if n := toInt(row[i]); n >= t.AutoIncrement {
+				t.AutoIncrement = n + 1
+			}
+		}
+	}
+
+	return
+}
+
+func (s *session) replaceRow(t *table, sets []keySet, rowIdx int, row []value) error {
+	if conflict, key, keyValue := t.findConflict(sets, row, rowIdx); conflict >= 0 {
+		return dupEntryError(key, keyValue)
+	}
+
+	t.removeKeys(sets, t.Rows[rowIdx])
+	t.Rows[rowIdx] = row
+	t.addKeys(sets, row, rowIdx)
+	return nil
+}
+
+func (s *session) execUpdate(ctx context.Context, stmt *updateStmt, args []value) (*result, error) {
+	orig, err := s.lookup(stmt.Table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := orig.clone()
+	e := &env{
+		ctx:   ctx,
+		s:     s,
+		args:  args,
+		table: t,
+	}
+	matched, err := s.filter(e, t, stmt.Where)
+
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err = s.orderAndLimit(e, t, matched, stmt.OrderBy, stmt.Limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &result{}
+	sets := t.buildKeySets()
+
+	for _, i := range matched {
+		old := t.Rows[i]
+		row, err := s.assign(e, t, old, stmt.Sets)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rowKey(row) == rowKey(old) {
+			continue
+		}
+
+		if err := s.replaceRow(t, sets, i, row); err != nil {
+			return nil, err
+		}
+
+		res.Affected++
+	}
+
+	s.store(t)
+	return res, nil
+}
+
+func (s *session) execDelete(ctx context.Context, stmt *deleteStmt, args []value) (*result, error) {
+	orig, err := s.lookup(stmt.Table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := orig.clone()
+	e := &env{
+		ctx:   ctx,
+		s:     s,
+		args:  args,
+		table: t,
+	}
+	matched, err := s.filter(e, t, stmt.Where)
+
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err = s.orderAndLimit(e, t, matched, stmt.OrderBy, stmt.Limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range matched {
+		t.Rows[i] = nil
+	}
+
+	t.compact()
+	s.store(t)
+	return &result{
+		Affected: int64(len(matched)),
+	}, nil
+}
@@ -0,0 +1,106 @@
+package memdb
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gtidSet 是一个 GTID 集合，key 是 server_uuid，value 是已经排序且不重叠的序号区间。
+type gtidSet map[string][]gtidInterval
+
+// gtidInterval 是一个闭区间 [Start, End]。
+type gtidInterval struct {
+	Start, End int64
+}
+
+// parseGTIDSet 解析形如 "uuid:1-5:7,uuid2:3" 的 GTID 集合。
+func parseGTIDSet(s string) (gtidSet, error) {
+	set := gtidSet{}
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return set, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, newErrorf(1772, "Malformed GTID set specification '%v'.", s)
+		}
+
+		uuid := strings.ToLower(fields[0])
+
+		for _, f := range fields[1:] {
+			bounds := strings.SplitN(f, "-", 2)
+			start, err := strconv.ParseInt(bounds[0], 10, 64)
+
+			if err != nil || start <= 0 {
+				return nil, newErrorf(1772, "Malformed GTID set specification '%v'.", s)
+			}
+
+			end := start
+
+			if len(bounds) == 2 {
+				if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+					return nil, newErrorf(1772, "Malformed GTID set specification '%v'.", s)
+				}
+			}
+
+			set[uuid] = append(set[uuid], gtidInterval{Start: start, End: end})
+		}
+	}
+
+	for uuid, intervals := range set {
+		set[uuid] = mergeIntervals(intervals)
+	}
+
+	return set, nil
+}
+
+func mergeIntervals(intervals []gtidInterval) []gtidInterval {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].Start < intervals[j].Start
+	})
+	merged := intervals[:1]
+
+	for _, iv := range intervals[1:] {
+		last := &merged[len(merged)-1]
+
+		if iv.Start <= last.End+1 {
+			if iv.End > last.End {
+				last.End = iv.End
+			}
+
+			continue
+		}
+
+		merged = append(merged, iv)
+	}
+
+	return merged
+}
+
+// subsetOf 判断 set 是否是 other 的子集。
+func (set gtidSet) subsetOf(other gtidSet) bool {
+	for uuid, intervals := range set {
+		for _, iv := range intervals {
+			if !containsInterval(other[uuid], iv) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func containsInterval(intervals []gtidInterval, iv gtidInterval) bool {
+	for _, container := range intervals {
+		if container.Start <= iv.Start && iv.End <= container.End {
+			return true
+		}
+	}
+
+	return false
+}
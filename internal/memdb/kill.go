@@ -0,0 +1,60 @@
+package memdb
+
+import "context"
+
+// start 记录正在执行的语句，返回的 ctx 会在语句被 KILL 时结束。
+// 语句执行完成后必须调用 finish，finish 返回语句是否被 KILL 中断了。
+func (s *session) start(ctx context.Context) (running context.Context, finish func() (killed bool)) {
+	running, cancel := context.WithCancel(ctx)
+
+	s.killMu.Lock()
+	s.cancel = cancel
+	s.killed = false
+	s.killMu.Unlock()
+
+	finish = func() bool {
+		cancel()
+
+		s.killMu.Lock()
+		defer s.killMu.Unlock()
+		s.cancel = nil
+		return s.killed
+	}
+	return
+}
+
+// kill 中断正在执行的语句，conn 为 true 时同时关闭连接。与 MySQL 一样，空闲连接上的 KILL QUERY 什么都不做。
+func (s *session) kill(conn bool) {
+	s.killMu.Lock()
+	defer s.killMu.Unlock()
+
+	if s.cancel != nil {
+		s.killed = true
+		s.cancel()
+	}
+
+	if conn {
+		s.dead = true
+	}
+}
+
+// isDead 判断连接是否已经被 KILL CONNECTION 关闭。
+func (s *session) isDead() bool {
+	s.killMu.Lock()
+	defer s.killMu.Unlock()
+	return s.dead
+}
+
+// execKill 执行 KILL，不需要持有 db.mu，因此可以中断其他连接上长时间执行的语句。
+func (s *session) execKill(stmt *killStmt) (*result, error) {
+	s.db.sessionMu.Lock()
+	target := s.db.sessions[stmt.ID]
+	s.db.sessionMu.Unlock()
+
+	if target == nil {
+		return nil, newErrorf(errNoSuchThread, "Unknown thread id: %v", stmt.ID)
+	}
+
+	target.kill(!stmt.Query)
+	return &result{}, nil
+}
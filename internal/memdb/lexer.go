@@ -0,0 +1,225 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenParam
+	tokenOp
+)
+
+type token struct {
+	Kind   tokenKind
+	Value  string
+	Upper  string // Upper 是大写的 Value，仅用于匹配关键字。
+	Quoted bool   // Quoted 表示标识符是否被反引号括起来，括起来的标识符不会被当做关键字。
+	Pos    int
+}
+
+func (t token) String() string {
+	if t.Kind == tokenEOF {
+		return "end of query"
+	}
+
+	return t.Value
+}
+
+// reserved 是所有保留字，保留字不能直接作为标识符使用，需要用反引号括起来。
+// 其他关键字（比如 BEGIN、COMMENT 等）与 MySQL 一样可以直接当做列名使用。
+var reserved = map[string]bool{
+	"ADD": true, "ALL": true, "AND": true, "AS": true, "ASC": true, "BETWEEN": true, "BY": true,
+	"CHARACTER": true, "COLLATE": true, "CONSTRAINT": true, "CREATE": true, "DEFAULT": true,
+	"DELETE": true, "DESC": true, "DISTINCT": true, "DIV": true, "DROP": true, "EXISTS": true,
+	"FALSE": true, "FOR": true, "FROM": true, "GROUP": true, "HAVING": true, "IF": true, "IGNORE": true,
+	"IN": true, "INDEX": true, "INSERT": true, "INTO": true, "IS": true, "KEY": true, "LIKE": true,
+	"LIMIT": true, "LOCK": true, "MOD": true, "NOT": true, "NULL": true, "ON": true, "OR": true,
+	"ORDER": true, "PRIMARY": true, "RELEASE": true, "REPLACE": true, "SELECT": true, "SET": true,
+	"TABLE": true, "TO": true, "TRUE": true, "UNIQUE": true, "UNSIGNED": true, "UPDATE": true,
+	"VALUES": true, "WHERE": true,
+}
+
+// lex 将 query 拆分成 token 列表，最后一个 token 一定是 tokenEOF。
+func lex(query string) (tokens []token, err error) {
+	i := 0
+	n := len(query)
+
+	for i < n {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '#' || (c == '-' && i+1 < n && query[i+1] == '-'):
+			for i < n && query[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < n && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+
+			if end < 0 {
+				return nil, syntaxError(query, i)
+			}
+
+			i += end + 4
+
+		case isIdentStart(c):
+			start := i
+
+			for i < n && isIdentChar(query[i]) {
+				i++
+			}
+
+			word := query[start:i]
+			tokens = append(tokens, token{Kind: tokenIdent, Value: word, Upper: strings.ToUpper(word), Pos: start})
+
+		case c == '`':
+			start := i
+			i++
+			end := strings.IndexByte(query[i:], '`')
+
+			if end < 0 {
+				return nil, syntaxError(query, start)
+			}
+
+			tokens = append(tokens, token{Kind: tokenIdent, Value: query[i : i+end], Quoted: true, Pos: start})
+			i += end + 1
+
+		case c == '\'' || c == '"':
+			start := i
+			s, next, ok := lexString(query, i)
+
+			if !ok {
+				return nil, syntaxError(query, start)
+			}
+
+			tokens = append(tokens, token{Kind: tokenString, Value: s, Pos: start})
+			i = next
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < n && query[i+1] >= '0' && query[i+1] <= '9':
+			start := i
+
+			for i < n && (query[i] >= '0' && query[i] <= '9' || query[i] == '.') {
+				i++
+			}
+
+			if i < n && (query[i] == 'e' || query[i] == 'E') {
+				i++
+
+				if i < n && (query[i] == '+' || query[i] == '-') {
+					i++
+				}
+
+				for i < n && query[i] >= '0' && query[i] <= '9' {
+					i++
+				}
+			}
+
+			tokens = append(tokens, token{Kind: tokenNumber, Value: query[start:i], Pos: start})
+
+		case c == '?':
+			tokens = append(tokens, token{Kind: tokenParam, Value: "?", Pos: i})
+			i++
+
+		default:
+			start := i
+			op := ""
+
+			if i+2 < n && query[i:i+3] == "<=>" {
+				op = "<=>"
+			} else if i+1 < n {
+				switch query[i : i+2] {
+				case "<=", ">=", "<>", "!=", "&&", "||":
+					op = query[i : i+2]
+				}
+			}
+
+			if op == "" {
+				switch c {
+				case '(', ')', ',', ';', '=', '<', '>', '+', '-', '*', '/', '%', '.', '!':
+					op = string(c)
+				default:
+					return nil, syntaxError(query, i)
+				}
+			}
+
+			tokens = append(tokens, token{Kind: tokenOp, Value: op, Pos: start})
+			i += len(op)
+		}
+	}
+
+	tokens = append(tokens, token{Kind: tokenEOF, Pos: n})
+	return
+}
+
+func lexString(query string, i int) (s string, next int, ok bool) {
+	quote := query[i]
+	buf := &strings.Builder{}
+	i++
+
+	for i < len(query) {
+		c := query[i]
+
+		switch {
+		case c == '\\' && i+1 < len(query):
+			i++
+
+			switch query[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case '0':
+				buf.WriteByte(0)
+			case 'Z':
+				buf.WriteByte(26)
+			default:
+				buf.WriteByte(query[i])
+			}
+
+		case c == quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				buf.WriteByte(quote)
+				i++
+			} else {
+				return buf.String(), i + 1, true
+			}
+
+		default:
+			buf.WriteByte(c)
+		}
+
+		i++
+	}
+
+	return
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '@' || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '$'
+}
+
+func syntaxError(query string, pos int) error {
+	near := query[pos:]
+
+	if len(near) > 80 {
+		near = near[:80]
+	}
+
+	return newError(errSyntax, fmt.Sprintf("You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near '%v'", near))
+}
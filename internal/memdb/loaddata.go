@@ -0,0 +1,267 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// 与 MySQL 一致的 LOAD DATA 警告号。
+const (
+	warnTooFewRecords  = 1261
+	warnTooManyRecords = 1262
+)
+
+const readerPrefix = "Reader::"
+
+var (
+	readerHandlersMu sync.RWMutex
+	readerHandlers   = map[string]func() io.Reader{}
+)
+
+// RegisterReaderHandler 注册一个 io.Reader，与 mysql.RegisterReaderHandler 一样，
+// `LOAD DATA LOCAL INFILE 'Reader::<name>'` 会从 handler 返回的 io.Reader 读取数据。
+func RegisterReaderHandler(name string, handler func() io.Reader) {
+	readerHandlersMu.Lock()
+	defer readerHandlersMu.Unlock()
+	readerHandlers[name] = handler
+}
+
+// DeregisterReaderHandler 删除 RegisterReaderHandler 注册的 io.Reader。
+func DeregisterReaderHandler(name string) {
+	readerHandlersMu.Lock()
+	defer readerHandlersMu.Unlock()
+	delete(readerHandlers, name)
+}
+
+type warning struct {
+	Code    uint16
+	Message string
+}
+
+// execLoadData 执行 LOAD DATA，只支持从 RegisterReaderHandler 注册的 io.Reader 读取数据。
+// 与 MySQL 一样，LOCAL 模式下遇到重复数据时会忽略，字段数量不对时会产生警告。
+func (s *session) execLoadData(ctx context.Context, stmt *loadDataStmt) (*result, error) {
+	if !stmt.Local || !strings.HasPrefix(stmt.File, readerPrefix) {
+		return nil, newError(errNotSupported, "memdb only supports LOAD DATA LOCAL INFILE 'Reader::<name>'")
+	}
+
+	if stmt.FieldsTerminated == "" || stmt.LinesTerminated == "" {
+		return nil, newError(errNotSupported, "memdb doesn't support fixed-row format in LOAD DATA")
+	}
+
+	name := stmt.File[len(readerPrefix):]
+	readerHandlersMu.RLock()
+	handler := readerHandlers[name]
+	readerHandlersMu.RUnlock()
+
+	if handler == nil {
+		return nil, fmt.Errorf("reader '%v' is not registered", name)
+	}
+
+	r := handler()
+
+	if rc, ok := r.(io.ReadCloser); ok {
+		defer rc.Close()
+	}
+
+	// 读取数据时不能持有锁，读取速度取决于调用者。
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	t, err := s.lookup(stmt.Table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cols []*column
+
+	if stmt.Columns == nil {
+		cols = t.Columns
+	} else {
+		for _, name := range stmt.Columns {
+			idx, ok := t.column(name)
+
+			if !ok {
+				return nil, newErrorf(errBadField, "Unknown column '%v' in 'field list'", name)
+			}
+
+			cols = append(cols, t.Columns[idx])
+		}
+	}
+
+	insert := &insertStmt{
+		Table:   stmt.Table,
+		Replace: stmt.Replace,
+		Ignore:  !stmt.Replace,
+		Columns: stmt.Columns,
+	}
+	var warnings []warning
+
+	for i, fields := range parseRecords(string(data), stmt) {
+		n := len(fields)
+
+		switch {
+		case n < len(cols):
+			warnings = append(warnings, warning{warnTooFewRecords, fmt.Sprintf("Row %v doesn't contain data for all columns", i+1)})
+		case n > len(cols):
+			warnings = append(warnings, warning{warnTooManyRecords, fmt.Sprintf("Row %v was truncated; it contained more data than there were input columns", i+1)})
+			fields = fields[:len(cols)]
+		}
+
+		exprs := make([]expr, len(cols))
+
+		for j, col := range cols {
+			switch {
+			case j < len(fields):
+				exprs[j] = &literalExpr{Value: fields[j]}
+			case col.Default != nil:
+				exprs[j] = col.Default
+			default:
+				exprs[j] = &literalExpr{}
+			}
+		}
+
+		insert.Rows = append(insert.Rows, exprs)
+	}
+
+	res := &result{}
+
+	if len(insert.Rows) > 0 {
+		if res, err = s.execInsert(ctx, insert, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	s.warnings = warnings
+	return res, nil
+}
+
+// parseRecords 按照 stmt 中 FIELDS 和 LINES 的设置解析 data，NULL 字段的值为 nil。
+func parseRecords(data string, stmt *loadDataStmt) (records [][]value) {
+	fieldTerm, lineTerm := stmt.FieldsTerminated, stmt.LinesTerminated
+	enclosed, escaped := stmt.Enclosed, stmt.Escaped
+	skipped := int64(0)
+
+	for len(data) > 0 {
+		if stmt.LinesStarting != "" {
+			idx := strings.Index(data, stmt.LinesStarting)
+
+			if idx < 0 {
+				break
+			}
+
+			data = data[idx+len(stmt.LinesStarting):]
+		}
+
+		var fields []value
+
+		for {
+			var field value
+			var quoted bool
+			buf := &strings.Builder{}
+
+			if enclosed != "" && strings.HasPrefix(data, enclosed) {
+				quoted = true
+				data = data[len(enclosed):]
+			}
+
+			for len(data) > 0 {
+				if escaped != "" && strings.HasPrefix(data, escaped) && len(data) > len(escaped) {
+					c := data[len(escaped)]
+					data = data[len(escaped)+1:]
+
+					if c == 'N' && !quoted && buf.Len() == 0 && (len(data) == 0 || strings.HasPrefix(data, fieldTerm) || strings.HasPrefix(data, lineTerm)) {
+						field = nil
+						buf = nil
+						break
+					}
+
+					buf.WriteByte(unescapeLoadData(c))
+					continue
+				}
+
+				if quoted {
+					if strings.HasPrefix(data, enclosed+enclosed) {
+						buf.WriteString(enclosed)
+						data = data[2*len(enclosed):]
+						continue
+					}
+
+					if strings.HasPrefix(data, enclosed) {
+						rest := data[len(enclosed):]
+
+						if len(rest) == 0 || strings.HasPrefix(rest, fieldTerm) || strings.HasPrefix(rest, lineTerm) {
+							data = rest
+							break
+						}
+					}
+				} else if strings.HasPrefix(data, fieldTerm) || strings.HasPrefix(data, lineTerm) {
+					break
+				}
+
+				buf.WriteByte(data[0])
+				data = data[1:]
+			}
+
+			if buf != nil {
+				field = buf.String()
+
+				// 设置了 ENCLOSED BY 时，没有被括起来的 NULL 也代表 NULL。
+				if enclosed != "" && !quoted && field == "NULL" {
+					field = nil
+				}
+			}
+
+			fields = append(fields, field)
+
+			if strings.HasPrefix(data, fieldTerm) {
+				data = data[len(fieldTerm):]
+				continue
+			}
+
+			if strings.HasPrefix(data, lineTerm) {
+				data = data[len(lineTerm):]
+			}
+
+			break
+		}
+
+		if skipped < stmt.IgnoreLines {
+			skipped++
+			continue
+		}
+
+		records = append(records, fields)
+	}
+
+	return
+}
+
+func unescapeLoadData(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'b':
+		return '\b'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 26
+	}
+
+	return c
+}
@@ -0,0 +1,429 @@
+// Package memdb 实现了一个兼容 MySQL 语法的内存数据库 driver，仅用于单元测试。
+//
+// DSN 格式为 `mem://name`，相同 name 的连接会共享同一个数据库，
+// 因此可以将主库和从库配置为同一个 DSN 来模拟主从结构。
+// DSN 后面加上 `?textProtocol=true` 时，没有参数的查询会与 go-sql-driver 的文本协议一样，
+// 将所有非 NULL 的值以 []byte 的形式返回，有参数的查询依然返回 int64、float64 等类型的值。
+//
+// 内存数据库仅支持常用语法的一个子集：
+//     - CREATE TABLE / DROP TABLE / TRUNCATE TABLE；
+//     - INSERT [IGNORE] / REPLACE，支持 ON DUPLICATE KEY UPDATE；
+//     - 单表的 SELECT / UPDATE / DELETE，支持 WHERE、GROUP BY、HAVING、ORDER BY、LIMIT；
+//     - BEGIN / COMMIT / ROLLBACK 以及 SAVEPOINT；
+//     - XA START / END / PREPARE / COMMIT / ROLLBACK / RECOVER，PREPARE 之后的事务在连接关闭后依然保留；
+//     - LOAD DATA LOCAL INFILE 'Reader::<name>'，数据来自 RegisterReaderHandler 注册的 io.Reader，以及 SHOW WARNINGS；
+//     - KILL [CONNECTION | QUERY] id，id 就是 CONNECTION_ID() 的值，可以中断其他连接上的 SLEEP 等语句；
+//     - EXPLAIN SELECT，由于没有索引，结果中的 type 总是 ALL；
+//     - SET insert_id = n，指定下一条 INSERT 语句生成的第一个自增值，其他 SET 语句会被忽略。
+//
+// 所有语句都是串行执行的，事务中的修改在提交时按行合并，其他连接在事务期间提交的修改都会保留。
+// 事务第一次修改一张表之后就不再看到其他连接对这张表的修改，隔离级别近似于 REPEATABLE READ。
+// 没有行锁，并发修改同一行的事务中后提交的会失败并返回死锁错误（1213），并发写入相同唯一键时返回 1062。
+package memdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// Scheme 是内存数据库 DSN 的前缀。
+	Scheme = "mem://"
+
+	// Version 是内存数据库对外宣称的 MySQL 版本。
+	Version = "5.7.0-memdb"
+)
+
+var (
+	errConnClosed = errors.New("go-mysql: memdb connection is closed")
+)
+
+// IsDSN 判断 dsn 是否是一个内存数据库的 DSN。
+func IsDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, Scheme)
+}
+
+// Driver 是内存数据库的 driver。
+type Driver struct{}
+
+// Open 打开一个新的连接。
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	if !IsDSN(dsn) {
+		return nil, errors.New("go-mysql: invalid memdb DSN " + dsn)
+	}
+
+	name := dsn[len(Scheme):]
+	text := false
+
+	if idx := strings.IndexByte(name, '?'); idx >= 0 {
+		params, err := url.ParseQuery(name[idx+1:])
+
+		if err != nil {
+			return nil, errors.New("go-mysql: invalid memdb DSN " + dsn)
+		}
+
+		text = params.Get("textProtocol") == "true"
+		name = name[:idx]
+	}
+
+	if name == "" {
+		return nil, errors.New("go-mysql: memdb DSN must have a database name")
+	}
+
+	return &conn{
+		s:    newSession(lookupDatabase(name)),
+		text: text,
+	}, nil
+}
+
+type conn struct {
+	s      *session
+	text   bool // text 表示没有参数的查询使用文本协议返回结果。
+	closed bool
+}
+
+var (
+	_ driver.Conn               = new(conn)
+	_ driver.ConnBeginTx        = new(conn)
+	_ driver.ExecerContext      = new(conn)
+	_ driver.QueryerContext     = new(conn)
+	_ driver.ConnPrepareContext = new(conn)
+	_ driver.Pinger             = new(conn)
+	_ driver.SessionResetter    = new(conn)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+
+	parsed, params, err := parse(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.s.prepared(1)
+	return &stmt{
+		c:      c,
+		stmt:   parsed,
+		params: params,
+	}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	c.s.close()
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := c.s.begin(); err != nil {
+		return nil, err
+	}
+
+	return &tx{c: c}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if c.closed {
+		return driver.ErrBadConn
+	}
+
+	return ctx.Err()
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.closed || c.s.isDead() {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.run(ctx, query, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &execResult{
+		affected:     res.Affected,
+		lastInsertID: res.LastInsertID,
+	}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.run(ctx, query, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs := newRows(res)
+	rs.text = c.text && len(args) == 0
+	return rs, nil
+}
+
+func (c *conn) run(ctx context.Context, query string, args []driver.NamedValue) (*result, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+
+	parsed, params, err := parse(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.execParsed(ctx, parsed, params, args)
+}
+
+func (c *conn) execParsed(ctx context.Context, parsed statement, params int, args []driver.NamedValue) (*result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(args) != params {
+		return nil, newErrorf(1210, "Incorrect arguments to mysqld_stmt_execute: expect %v args but got %v", params, len(args))
+	}
+
+	values := make([]value, 0, len(args))
+
+	for _, arg := range args {
+		v, err := fromDriverValue(arg.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, v)
+	}
+
+	if c.s.isDead() {
+		return nil, driver.ErrBadConn
+	}
+
+	running, finish := c.s.start(ctx)
+	res, err := c.s.exec(running, parsed, values)
+	killed := finish()
+
+	// 与 go-sql-driver 一样，ctx 在执行过程中结束时返回 ctx 的错误。
+	if e := ctx.Err(); e != nil {
+		return nil, e
+	}
+
+	// 与 MySQL 一样，被 KILL QUERY 中断的语句总是返回错误，被 KILL CONNECTION 中断则是连接断开。
+	if killed {
+		if c.s.isDead() {
+			return nil, mysql.ErrInvalidConn
+		}
+
+		return nil, newError(errQueryInterrupted, "Query execution was interrupted")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type stmt struct {
+	c      *conn
+	stmt   statement
+	params int
+	closed bool
+}
+
+var (
+	_ driver.Stmt             = new(stmt)
+	_ driver.StmtExecContext  = new(stmt)
+	_ driver.StmtQueryContext = new(stmt)
+)
+
+func (s *stmt) Close() error {
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	// 连接关闭时已经关闭了上面所有的语句。
+	if !s.c.closed {
+		s.c.s.prepared(-1)
+	}
+
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.params
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if s.c.closed {
+		return nil, driver.ErrBadConn
+	}
+
+	res, err := s.c.execParsed(ctx, s.stmt, s.params, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &execResult{
+		affected:     res.Affected,
+		lastInsertID: res.LastInsertID,
+	}, nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if s.c.closed {
+		return nil, driver.ErrBadConn
+	}
+
+	res, err := s.c.execParsed(ctx, s.stmt, s.params, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newRows(res), nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, 0, len(args))
+
+	for i, arg := range args {
+		named = append(named, driver.NamedValue{
+			Ordinal: i + 1,
+			Value:   arg,
+		})
+	}
+
+	return named
+}
+
+type tx struct {
+	c *conn
+}
+
+func (t *tx) Commit() error {
+	if t.c.closed {
+		return errConnClosed
+	}
+
+	return t.c.s.commit()
+}
+
+func (t *tx) Rollback() error {
+	if t.c.closed {
+		return errConnClosed
+	}
+
+	t.c.s.rollback()
+	return nil
+}
+
+type execResult struct {
+	affected     int64
+	lastInsertID int64
+}
+
+func (r *execResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r *execResult) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+type rows struct {
+	columns []string
+	types   []string
+	rows    [][]value
+	pos     int
+	text    bool // text 表示所有非 NULL 的值都以 []byte 的形式返回。
+}
+
+var (
+	_ driver.Rows                           = new(rows)
+	_ driver.RowsColumnTypeDatabaseTypeName = new(rows)
+)
+
+func newRows(res *result) *rows {
+	return &rows{
+		columns: res.Columns,
+		types:   res.Types,
+		rows:    res.Rows,
+	}
+}
+
+func (rs *rows) Columns() []string {
+	return rs.columns
+}
+
+func (rs *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return rs.types[index]
+}
+
+func (rs *rows) Close() error {
+	rs.rows = nil
+	return nil
+}
+
+func (rs *rows) Next(dest []driver.Value) error {
+	if rs.pos >= len(rs.rows) {
+		return io.EOF
+	}
+
+	row := rs.rows[rs.pos]
+	rs.pos++
+
+	for i := range dest {
+		if rs.text && row[i] != nil {
+			dest[i] = []byte(toString(row[i]))
+			continue
+		}
+
+		dest[i] = toDriverValue(row[i])
+	}
+
+	return nil
+}
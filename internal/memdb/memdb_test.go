@@ -0,0 +1,523 @@
+package memdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+const testDriverName = "memdb-test"
+
+func init() {
+	sql.Register(testDriverName, &Driver{})
+}
+
+func openTestDB(t *testing.T, name string) *sql.DB {
+	Drop(name)
+	db, err := sql.Open(testDriverName, Scheme+name)
+
+	if err != nil {
+		t.Fatalf("fail to open memdb. [err:%v]", err)
+	}
+
+	return db
+}
+
+func errorNumber(err error) uint16 {
+	if e, ok := err.(*mysql.MySQLError); ok {
+		return e.Number
+	}
+
+	return 0
+}
+
+func TestMemDBCRUD(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "test_crud")
+	defer db.Close()
+
+	a.NilError(db.Exec(`CREATE TABLE IF NOT EXISTS user (
+		id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+		name varchar(64) NOT NULL DEFAULT '' COMMENT 'user name',
+		age int(11) DEFAULT NULL,
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		UNIQUE KEY uk_name (name),
+		KEY idx_age (age)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`))
+
+	res, err := db.Exec("INSERT INTO user (name, age) VALUES (?, ?), (?, ?), ('carol', NULL)", "alice", 20, "bob", 30)
+	a.NilError(err)
+	a.Equal(mustInt64(res.RowsAffected()), int64(3))
+	a.Equal(mustInt64(res.LastInsertId()), int64(1))
+
+	_, err = db.Exec("INSERT INTO user (name, age) VALUES (?, ?)", "alice", 1)
+	a.Equal(errorNumber(err), uint16(errDupEntry))
+
+	var count int64
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM user WHERE age IS NOT NULL").Scan(&count))
+	a.Equal(count, int64(2))
+
+	rows, err := db.Query("SELECT `user`.id, name AS n FROM user WHERE id IN (?, ?, 3) ORDER BY n DESC LIMIT 1, 2", 1, 2)
+	a.NilError(err)
+	cols, err := rows.Columns()
+	a.NilError(err)
+	a.Equal(cols, []string{"id", "n"})
+	names := []string{}
+
+	for rows.Next() {
+		var id int64
+		var name string
+		a.NilError(rows.Scan(&id, &name))
+		names = append(names, name)
+	}
+
+	a.NilError(rows.Err())
+	a.Equal(names, []string{"bob", "alice"})
+
+	res, err = db.Exec("UPDATE user SET age = age + 1 WHERE name LIKE 'a%' OR name = ?", "bob")
+	a.NilError(err)
+	a.Equal(mustInt64(res.RowsAffected()), int64(2))
+
+	var age int
+	a.NilError(db.QueryRow("SELECT age FROM user WHERE name = 'bob'").Scan(&age))
+	a.Equal(age, 31)
+
+	res, err = db.Exec("DELETE FROM user WHERE age BETWEEN 20 AND 30")
+	a.NilError(err)
+	a.Equal(mustInt64(res.RowsAffected()), int64(1))
+
+	var createdAt time.Time
+	a.NilError(db.QueryRow("SELECT created_at FROM user WHERE name = 'carol'").Scan(&createdAt))
+	a.Assert(time.Since(createdAt) < time.Minute)
+
+	_, err = db.Exec("SELECT * FROM not_exist")
+	a.Equal(errorNumber(err), uint16(errNoSuchTable))
+
+	_, err = db.Exec("SELEC 1")
+	a.Equal(errorNumber(err), uint16(errSyntax))
+}
+
+func TestMemDBInsertModes(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "test_insert_modes")
+	defer db.Close()
+
+	a.NilError(db.Exec("CREATE TABLE counter (k VARCHAR(32) PRIMARY KEY, v INT NOT NULL)"))
+	a.NilError(db.Exec("INSERT INTO counter VALUES ('a', 1), ('b', 2)"))
+
+	res, err := db.Exec("INSERT IGNORE INTO counter VALUES ('a', 10), ('c', 3)")
+	a.NilError(err)
+	a.Equal(mustInt64(res.RowsAffected()), int64(1))
+
+	res, err = db.Exec("INSERT INTO counter (k, v) VALUES ('a', 5) ON DUPLICATE KEY UPDATE v = v + VALUES(v)")
+	a.NilError(err)
+	a.Equal(mustInt64(res.RowsAffected()), int64(2))
+
+	res, err = db.Exec("REPLACE INTO counter (k, v) VALUES ('b', 20)")
+	a.NilError(err)
+	a.Equal(mustInt64(res.RowsAffected()), int64(2))
+
+	var sum int64
+	a.NilError(db.QueryRow("SELECT SUM(v) FROM counter").Scan(&sum))
+	a.Equal(sum, int64(6+20+3))
+
+	rows, err := db.Query("SELECT v % 2 AS odd, COUNT(*) AS cnt FROM counter GROUP BY odd HAVING cnt > 0 ORDER BY odd")
+	a.NilError(err)
+	defer rows.Close()
+	result := map[int64]int64{}
+
+	for rows.Next() {
+		var odd, cnt int64
+		a.NilError(rows.Scan(&odd, &cnt))
+		result[odd] = cnt
+	}
+
+	a.Equal(result, map[int64]int64{0: 2, 1: 1})
+}
+
+func TestMemDBTransaction(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "test_transaction")
+	defer db.Close()
+
+	a.NilError(db.Exec("CREATE TABLE t (id INT PRIMARY KEY, v INT)"))
+
+	tx, err := db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("INSERT INTO t VALUES (1, 1)"))
+	a.NilError(tx.Exec("SAVEPOINT sp1"))
+	a.NilError(tx.Exec("INSERT INTO t VALUES (2, 2)"))
+	a.NilError(tx.Exec("ROLLBACK TO SAVEPOINT sp1"))
+
+	var count int
+	a.NilError(tx.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 1)
+
+	// 事务提交之前，其他连接看不到事务里的修改。
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 0)
+
+	a.NilError(tx.Commit())
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 1)
+
+	tx, err = db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("DELETE FROM t"))
+	a.NilError(tx.Rollback())
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 1)
+
+	// 出错的语句不应该留下任何修改。
+	_, err = db.Exec("INSERT INTO t VALUES (3, 3), (1, 1)")
+	a.Equal(errorNumber(err), uint16(errDupEntry))
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 1)
+}
+
+func TestMemDBTransactionMerge(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "test_transaction_merge")
+	defer db.Close()
+
+	a.NilError(db.Exec("CREATE TABLE t (id BIGINT PRIMARY KEY, v INT)"))
+	ids := func() (ids []int64) {
+		rows, err := db.Query("SELECT id FROM t ORDER BY id")
+		a.NilError(err)
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			a.NilError(rows.Scan(&id))
+			ids = append(ids, id)
+		}
+
+		return
+	}
+
+	// 事务期间其他连接提交的行在事务提交之后依然存在。
+	tx, err := db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("INSERT INTO t VALUES (1, 1)"))
+	a.NilError(db.Exec("INSERT INTO t VALUES (2, 2)"))
+	a.NilError(tx.Commit())
+	a.Equal(ids(), []int64{1, 2})
+
+	// 修改和删除不同的行可以同时提交。
+	tx, err = db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("UPDATE t SET v = 10 WHERE id = 1"))
+	a.NilError(db.Exec("DELETE FROM t WHERE id = 2"))
+	a.NilError(db.Exec("INSERT INTO t VALUES (3, 3)"))
+	a.NilError(tx.Commit())
+	a.Equal(ids(), []int64{1, 3})
+	var v int
+	a.NilError(db.QueryRow("SELECT v FROM t WHERE id = 1").Scan(&v))
+	a.Equal(v, 10)
+
+	// 同一行被并发修改时后提交的事务失败，并且不会留下任何修改。
+	tx, err = db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("UPDATE t SET v = 20 WHERE id = 1"))
+	a.NilError(tx.Exec("INSERT INTO t VALUES (4, 4)"))
+	a.NilError(db.Exec("UPDATE t SET v = 30 WHERE id = 1"))
+	a.Equal(errorNumber(tx.Commit()), uint16(errDeadlock))
+	a.Equal(ids(), []int64{1, 3})
+	a.NilError(db.QueryRow("SELECT v FROM t WHERE id = 1").Scan(&v))
+	a.Equal(v, 30)
+
+	tx, err = db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("DELETE FROM t WHERE id = 3"))
+	a.NilError(db.Exec("DELETE FROM t WHERE id = 3"))
+	a.Equal(errorNumber(tx.Commit()), uint16(errDeadlock))
+
+	// 并发插入相同主键时后提交的事务失败。
+	tx, err = db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("INSERT INTO t VALUES (5, 5)"))
+	a.NilError(db.Exec("INSERT INTO t VALUES (5, 6)"))
+	a.Equal(errorNumber(tx.Commit()), uint16(errDupEntry))
+	a.Equal(ids(), []int64{1, 5})
+
+	// 自增值不受事务控制，并发的事务不会分配到相同的值。
+	a.NilError(db.Exec("CREATE TABLE a (id BIGINT AUTO_INCREMENT PRIMARY KEY, v INT)"))
+	tx, err = db.Begin()
+	a.NilError(err)
+	res, err := tx.Exec("INSERT INTO a (v) VALUES (1)")
+	a.NilError(err)
+	a.Equal(mustInt64(res.LastInsertId()), int64(1))
+	res, err = db.Exec("INSERT INTO a (v) VALUES (2)")
+	a.NilError(err)
+	a.Equal(mustInt64(res.LastInsertId()), int64(2))
+	res, err = tx.Exec("INSERT INTO a (v) VALUES (3)")
+	a.NilError(err)
+	a.Equal(mustInt64(res.LastInsertId()), int64(3))
+	a.NilError(tx.Commit())
+	var count int
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM a").Scan(&count))
+	a.Equal(count, 3)
+
+	// 没有主键的表也按行合并。
+	a.NilError(db.Exec("CREATE TABLE n (v INT)"))
+	a.NilError(db.Exec("INSERT INTO n VALUES (1), (2)"))
+	tx, err = db.Begin()
+	a.NilError(err)
+	a.NilError(tx.Exec("DELETE FROM n WHERE v = 1"))
+	a.NilError(db.Exec("INSERT INTO n VALUES (3)"))
+	a.NilError(tx.Commit())
+	a.NilError(db.QueryRow("SELECT SUM(v) FROM n").Scan(&count))
+	a.Equal(count, 5)
+}
+
+func mustInt64(n int64, err error) int64 {
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+func TestMemDBGTID(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "test_gtid")
+	defer db.Close()
+
+	var before, after string
+	a.NilError(db.QueryRow("SELECT @@GLOBAL.gtid_executed").Scan(&before))
+	a.NilError(db.Exec("CREATE TABLE t (id INT PRIMARY KEY)"))
+	a.NilError(db.Exec("INSERT INTO t VALUES (1)"))
+	a.NilError(db.QueryRow("SELECT @@gtid_executed").Scan(&after))
+	a.Assert(after != before)
+
+	var subset bool
+	a.NilError(db.QueryRow("SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)", after).Scan(&subset))
+	a.Assert(subset)
+	a.NilError(db.QueryRow("SELECT GTID_SUBSET('a:1-3:5', 'a:1-5,\nb:1')").Scan(&subset))
+	a.Assert(subset)
+	a.NilError(db.QueryRow("SELECT GTID_SUBSET('a:1-6', 'a:1-5')").Scan(&subset))
+	a.Assert(!subset)
+
+	_, err := db.Exec("SELECT @@no_such_variable")
+	a.Equal(errorNumber(err), uint16(errUnknownSysVar))
+}
+
+func TestMemDBXA(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "test_xa")
+	defer db.Close()
+	ctx := context.Background()
+
+	a.NilError(db.Exec("CREATE TABLE t (id INT PRIMARY KEY)"))
+	conn, err := db.Conn(ctx)
+	a.NilError(err)
+	a.NilError(conn.ExecContext(ctx, "XA START 'g1', 'b1'"))
+	a.NilError(conn.ExecContext(ctx, "INSERT INTO t VALUES (1)"))
+
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	a.Equal(errorNumber(err), uint16(errXAERRmfail))
+	_, err = conn.ExecContext(ctx, "XA PREPARE 'g1', 'b1'")
+	a.Equal(errorNumber(err), uint16(errXAERRmfail))
+
+	a.NilError(conn.ExecContext(ctx, "XA END 'g1', 'b1'"))
+	a.NilError(conn.ExecContext(ctx, "XA PREPARE 'g1', 'b1'"))
+	a.NilError(conn.Close())
+
+	// PREPARE 之后的事务在连接关闭后依然存在，可以在其他连接上提交。
+	var count int
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 0)
+
+	var formatID, gtridLen, bqualLen int
+	var data string
+	a.NilError(db.QueryRow("XA RECOVER").Scan(&formatID, &gtridLen, &bqualLen, &data))
+	a.Equal(formatID, 1)
+	a.Equal(data[:gtridLen], "g1")
+	a.Equal(data[gtridLen:], "b1")
+
+	a.NilError(db.Exec("XA COMMIT 'g1', 'b1'"))
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 1)
+
+	_, err = db.Exec("XA COMMIT 'g1', 'b1'")
+	a.Equal(errorNumber(err), uint16(errXAERNota))
+
+	// ONE PHASE 提交和回滚。
+	conn, err = db.Conn(ctx)
+	a.NilError(err)
+	defer conn.Close()
+	a.NilError(conn.ExecContext(ctx, "XA START 'g2'"))
+	a.NilError(conn.ExecContext(ctx, "INSERT INTO t VALUES (2)"))
+	a.NilError(conn.ExecContext(ctx, "XA END 'g2'"))
+	a.NilError(conn.ExecContext(ctx, "XA COMMIT 'g2' ONE PHASE"))
+
+	a.NilError(conn.ExecContext(ctx, "XA START 'g3'"))
+	a.NilError(conn.ExecContext(ctx, "INSERT INTO t VALUES (3)"))
+	a.NilError(conn.ExecContext(ctx, "XA END 'g3'"))
+	a.NilError(conn.ExecContext(ctx, "XA PREPARE 'g3'"))
+	a.NilError(conn.ExecContext(ctx, "XA ROLLBACK 'g3'"))
+
+	a.NilError(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 2)
+}
+
+func TestMemDBLoadData(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "test_load_data")
+	defer db.Close()
+	ctx := context.Background()
+
+	a.NilError(db.Exec("CREATE TABLE t (id INT PRIMARY KEY, name VARCHAR(32), score INT NOT NULL DEFAULT 0)"))
+	RegisterReaderHandler("tsv", func() io.Reader {
+		return strings.NewReader("1\ta\\tb\t10\n2\t\\N\t20\n3\tc\n1\tdup\t30\n")
+	})
+	defer DeregisterReaderHandler("tsv")
+
+	conn, err := db.Conn(ctx)
+	a.NilError(err)
+	defer conn.Close()
+	res, err := conn.ExecContext(ctx, "LOAD DATA LOCAL INFILE 'Reader::tsv' INTO TABLE t")
+	a.NilError(err)
+	affected, _ := res.RowsAffected()
+	a.Equal(affected, int64(3))
+
+	var code int
+	var level, message string
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS")
+	a.NilError(err)
+	a.Assert(rows.Next())
+	a.NilError(rows.Scan(&level, &code, &message))
+	a.Equal(code, warnTooFewRecords)
+	a.Assert(!rows.Next())
+	a.NilError(rows.Close())
+
+	var name sql.NullString
+	var score int
+	a.NilError(conn.QueryRowContext(ctx, "SELECT name, score FROM t WHERE id = 1").Scan(&name, &score))
+	a.Equal(name.String, "a\tb")
+	a.Equal(score, 10)
+	a.NilError(conn.QueryRowContext(ctx, "SELECT name, score FROM t WHERE id = 2").Scan(&name, &score))
+	a.Assert(!name.Valid)
+	a.NilError(conn.QueryRowContext(ctx, "SELECT name, score FROM t WHERE id = 3").Scan(&name, &score))
+	a.Equal(score, 0)
+
+	// CSV 格式，跳过表头，REPLACE 重复数据。
+	RegisterReaderHandler("csv", func() io.Reader {
+		return strings.NewReader("id,name,score\r\n1,\"x, \"\"y\"\"\",40\r\n4,NULL,50,extra\r\n")
+	})
+	defer DeregisterReaderHandler("csv")
+	res, err = conn.ExecContext(ctx, `LOAD DATA LOCAL INFILE 'Reader::csv' REPLACE INTO TABLE t
+		FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' LINES TERMINATED BY '\r\n' IGNORE 1 LINES (id, name, score)`)
+	a.NilError(err)
+	affected, _ = res.RowsAffected()
+	a.Equal(affected, int64(3))
+
+	a.NilError(conn.QueryRowContext(ctx, "SELECT name, score FROM t WHERE id = 1").Scan(&name, &score))
+	a.Equal(name.String, `x, "y"`)
+	a.Equal(score, 40)
+	a.NilError(conn.QueryRowContext(ctx, "SELECT name FROM t WHERE id = 4").Scan(&name))
+	a.Assert(!name.Valid)
+
+	// SHOW WARNINGS 之前执行了其他语句，警告已经被清空。
+	var count int
+	a.NilError(conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM t").Scan(&count))
+	a.Equal(count, 4)
+	rows, err = conn.QueryContext(ctx, "SHOW WARNINGS")
+	a.NilError(err)
+	a.Assert(!rows.Next())
+	a.NilError(rows.Close())
+
+	_, err = conn.ExecContext(ctx, "LOAD DATA LOCAL INFILE 'Reader::unknown' INTO TABLE t")
+	a.NonNilError(err)
+	_, err = conn.ExecContext(ctx, "LOAD DATA INFILE '/tmp/data.txt' INTO TABLE t")
+	a.Equal(errorNumber(err), uint16(errNotSupported))
+}
+
+func TestMemDBKill(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "kill")
+	defer db.Close()
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	a.NilError(err)
+	defer conn.Close()
+	var id int64
+	a.NilError(conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id))
+
+	// KILL QUERY 只中断正在执行的语句，连接依然可用。
+	done := make(chan error, 1)
+	go func() {
+		var v int64
+		done <- conn.QueryRowContext(ctx, "SELECT SLEEP(10)").Scan(&v)
+	}()
+
+	// 语句可能还没有开始执行，一直 KILL 直到语句被中断。
+	kill := "KILL QUERY " + strconv.FormatInt(id, 10)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+WAIT:
+	for {
+		_, err := db.ExecContext(ctx, kill)
+		a.NilError(err)
+
+		select {
+		case err = <-done:
+			a.Equal(errorNumber(err), uint16(errQueryInterrupted))
+			break WAIT
+		case <-ticker.C:
+		}
+	}
+
+	var v int64
+	a.NilError(conn.QueryRowContext(ctx, "SELECT 1").Scan(&v))
+	a.Equal(v, int64(1))
+
+	// KILL CONNECTION 之后连接不可用。
+	_, err = db.ExecContext(ctx, "KILL "+strconv.FormatInt(id, 10))
+	a.NilError(err)
+	_, err = conn.ExecContext(ctx, "SELECT 1")
+	a.Equal(err, driver.ErrBadConn)
+
+	_, err = db.ExecContext(ctx, "KILL QUERY 100000")
+	a.Equal(errorNumber(err), uint16(errNoSuchThread))
+}
+
+func TestMemDBExplain(t *testing.T) {
+	a := assert.New(t)
+	db := openTestDB(t, "explain")
+	defer db.Close()
+
+	_, err := db.Exec("CREATE TABLE t (id BIGINT PRIMARY KEY, name VARCHAR(16))")
+	a.NilError(err)
+	_, err = db.Exec("INSERT INTO t VALUES (1, 'a'), (2, 'b')")
+	a.NilError(err)
+
+	var id, rows int64
+	var selectType, table, typ string
+	var keys, key, extra sql.NullString
+	a.NilError(db.QueryRow("EXPLAIN SELECT * FROM t WHERE id = ?", 1).Scan(&id, &selectType, &table, &typ, &keys, &key, &rows, &extra))
+	a.Equal(table, "t")
+	a.Equal(typ, "ALL")
+	a.Equal(rows, int64(2))
+	a.Equal(extra.String, "Using where")
+
+	var nullTable sql.NullString
+	var nullType, nullRows interface{}
+	a.NilError(db.QueryRow("EXPLAIN SELECT 1").Scan(&id, &selectType, &nullTable, &nullType, &keys, &key, &nullRows, &extra))
+	a.Assert(!nullTable.Valid)
+	a.Equal(extra.String, "No tables used")
+
+	_, err = db.Query("EXPLAIN SELECT * FROM missing")
+	a.Equal(errorNumber(err), uint16(errNoSuchTable))
+}
@@ -0,0 +1,1207 @@
+package memdb
+
+import (
+	"strconv"
+	"strings"
+)
+
+type parser struct {
+	query  string
+	tokens []token
+	pos    int
+	params int
+}
+
+// parse 解析一条 SQL 语句，同时返回语句中 ? 占位符的个数。
+func parse(query string) (stmt statement, params int, err error) {
+	tokens, err := lex(query)
+
+	if err != nil {
+		return
+	}
+
+	p := &parser{
+		query:  query,
+		tokens: tokens,
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			pe, ok := e.(parseError)
+
+			if !ok {
+				panic(e)
+			}
+
+			err = pe.err
+		}
+	}()
+
+	stmt = p.parseStatement()
+	p.accept(";")
+
+	if p.peek().Kind != tokenEOF {
+		p.fail()
+	}
+
+	params = p.params
+	return
+}
+
+// parseError 用于在递归下降解析时快速跳出，由 parse 统一 recover。
+type parseError struct {
+	err error
+}
+
+func (p *parser) fail() {
+	panic(parseError{err: syntaxError(p.query, p.peek().Pos)})
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+
+	return p.tokens[p.pos+offset]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+
+	if t.Kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func isWord(t token, word string) bool {
+	return t.Kind == tokenIdent && !t.Quoted && t.Upper == word
+}
+
+func (p *parser) isWord(words ...string) bool {
+	t := p.peek()
+
+	for _, w := range words {
+		if isWord(t, w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.Kind == tokenOp && t.Value == op
+}
+
+// accept 如果下一个 token 是 s（关键字或操作符）就消耗掉并返回 true。
+func (p *parser) accept(s string) bool {
+	if p.isOp(s) || p.isWord(s) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(s ...string) {
+	for _, w := range s {
+		if !p.accept(w) {
+			p.fail()
+		}
+	}
+}
+
+func (p *parser) ident() string {
+	t := p.peek()
+
+	if t.Kind != tokenIdent || !t.Quoted && reserved[t.Upper] {
+		p.fail()
+	}
+
+	p.pos++
+	return t.Value
+}
+
+// tableName 解析表名，允许使用 db.table 的形式，但会忽略 db。
+func (p *parser) tableName() string {
+	name := p.ident()
+
+	if p.accept(".") {
+		name = p.ident()
+	}
+
+	return name
+}
+
+func (p *parser) parseStatement() statement {
+	switch {
+	case p.accept("SELECT"):
+		return p.parseSelect()
+	case p.accept("INSERT"):
+		return p.parseInsert(false)
+	case p.accept("REPLACE"):
+		return p.parseInsert(true)
+	case p.accept("UPDATE"):
+		return p.parseUpdate()
+	case p.accept("DELETE"):
+		return p.parseDelete()
+	case p.accept("CREATE"):
+		return p.parseCreate()
+	case p.accept("DROP"):
+		return p.parseDrop()
+	case p.accept("TRUNCATE"):
+		p.accept("TABLE")
+		return &truncateStmt{Table: p.tableName()}
+	case p.accept("BEGIN"):
+		p.accept("WORK")
+		return &beginStmt{}
+	case p.accept("START"):
+		p.expect("TRANSACTION")
+		return &beginStmt{}
+	case p.accept("COMMIT"):
+		p.accept("WORK")
+		return &commitStmt{}
+	case p.accept("ROLLBACK"):
+		p.accept("WORK")
+
+		if p.accept("TO") {
+			p.accept("SAVEPOINT")
+			return &rollbackStmt{Savepoint: p.ident()}
+		}
+
+		return &rollbackStmt{}
+	case p.accept("SAVEPOINT"):
+		return &savepointStmt{Name: p.ident()}
+	case p.accept("RELEASE"):
+		p.expect("SAVEPOINT")
+		return &releaseStmt{Name: p.ident()}
+	case p.accept("SET"):
+		return p.parseSet()
+	case p.accept("SHOW"):
+		return p.parseShow()
+	case p.accept("XA"):
+		return p.parseXA()
+	case p.accept("LOAD"):
+		return p.parseLoadData()
+	case p.accept("KILL"):
+		return p.parseKill()
+	case p.accept("EXPLAIN"):
+		p.expect("SELECT")
+		return &explainStmt{Select: p.parseSelect()}
+	}
+
+	p.fail()
+	return nil
+}
+
+func (p *parser) parseShow() *showStmt {
+	switch {
+	case p.accept("TABLES"):
+		return &showStmt{What: "TABLES"}
+	case p.accept("SLAVE"), p.accept("REPLICA"):
+		p.expect("STATUS")
+		return &showStmt{What: "SLAVE STATUS"}
+	case p.accept("WARNINGS"):
+		return &showStmt{What: "WARNINGS"}
+	}
+
+	p.fail()
+	return nil
+}
+
+// parseSet 解析 SET 语句，只关心 SET [SESSION] insert_id = n，其他 SET 语句都会被忽略。
+func (p *parser) parseSet() statement {
+	p.accept("SESSION")
+
+	if !p.accept("INSERT_ID") {
+		p.skipRest()
+		return &ignoredStmt{}
+	}
+
+	p.expect("=")
+	return &setInsertIDStmt{ID: p.intLiteral()}
+}
+
+func (p *parser) parseKill() *killStmt {
+	stmt := &killStmt{}
+
+	if p.accept("QUERY") {
+		stmt.Query = true
+	} else {
+		p.accept("CONNECTION")
+	}
+
+	stmt.ID = p.intLiteral()
+	return stmt
+}
+
+func (p *parser) parseXA() *xaStmt {
+	stmt := &xaStmt{}
+
+	switch {
+	case p.accept("START"), p.accept("BEGIN"):
+		stmt.Action = "START"
+	case p.accept("END"):
+		stmt.Action = "END"
+	case p.accept("PREPARE"):
+		stmt.Action = "PREPARE"
+	case p.accept("COMMIT"):
+		stmt.Action = "COMMIT"
+	case p.accept("ROLLBACK"):
+		stmt.Action = "ROLLBACK"
+	case p.accept("RECOVER"):
+		stmt.Action = "RECOVER"
+		return stmt
+	default:
+		p.fail()
+	}
+
+	stmt.XID = p.parseXID()
+
+	if stmt.Action == "COMMIT" && p.accept("ONE") {
+		p.expect("PHASE")
+		stmt.OnePhase = true
+	}
+
+	return stmt
+}
+
+// parseXID 解析 gtrid [, bqual [, formatID]]，gtrid 和 bqual 必须是字符串。
+func (p *parser) parseXID() (x xid) {
+	x.FormatID = 1
+	x.Gtrid = p.stringLiteral()
+
+	if !p.accept(",") {
+		return
+	}
+
+	x.Bqual = p.stringLiteral()
+
+	if !p.accept(",") {
+		return
+	}
+
+	x.FormatID = p.intLiteral()
+	return
+}
+
+// parseLoadData 解析 LOAD DATA 语句，不支持 CHARACTER SET 以外的字符集设置和 SET 子句。
+func (p *parser) parseLoadData() *loadDataStmt {
+	p.expect("DATA")
+	stmt := &loadDataStmt{
+		FieldsTerminated: "\t",
+		Escaped:          "\\",
+		LinesTerminated:  "\n",
+	}
+	stmt.Local = p.accept("LOCAL")
+	p.expect("INFILE")
+	stmt.File = p.stringLiteral()
+
+	switch {
+	case p.accept("REPLACE"):
+		stmt.Replace = true
+	case p.accept("IGNORE"):
+		stmt.Ignore = true
+	}
+
+	p.expect("INTO", "TABLE")
+	stmt.Table = p.tableName()
+
+	if p.accept("CHARACTER") {
+		p.expect("SET")
+		p.next()
+	}
+
+	if p.accept("FIELDS") || p.accept("COLUMNS") {
+	fields:
+		for {
+			switch {
+			case p.accept("TERMINATED"):
+				p.expect("BY")
+				stmt.FieldsTerminated = p.stringLiteral()
+			case p.accept("OPTIONALLY"):
+				p.expect("ENCLOSED", "BY")
+				stmt.OptionallyEnclosed = true
+				stmt.Enclosed = p.stringLiteral()
+			case p.accept("ENCLOSED"):
+				p.expect("BY")
+				stmt.Enclosed = p.stringLiteral()
+			case p.accept("ESCAPED"):
+				p.expect("BY")
+				stmt.Escaped = p.stringLiteral()
+			default:
+				break fields
+			}
+		}
+	}
+
+	if p.accept("LINES") {
+	lines:
+		for {
+			switch {
+			case p.accept("STARTING"):
+				p.expect("BY")
+				stmt.LinesStarting = p.stringLiteral()
+			case p.accept("TERMINATED"):
+				p.expect("BY")
+				stmt.LinesTerminated = p.stringLiteral()
+			default:
+				break lines
+			}
+		}
+	}
+
+	if p.accept("IGNORE") {
+		stmt.IgnoreLines = p.intLiteral()
+
+		if !p.accept("LINES") {
+			p.expect("ROWS")
+		}
+	}
+
+	if p.accept("(") {
+		for {
+			stmt.Columns = append(stmt.Columns, p.ident())
+
+			if !p.accept(",") {
+				break
+			}
+		}
+
+		p.expect(")")
+	}
+
+	return stmt
+}
+
+func (p *parser) intLiteral() int64 {
+	t := p.peek()
+
+	if t.Kind != tokenNumber {
+		p.fail()
+	}
+
+	n, err := strconv.ParseInt(t.Value, 10, 64)
+
+	if err != nil {
+		p.fail()
+	}
+
+	p.pos++
+	return n
+}
+
+func (p *parser) stringLiteral() string {
+	t := p.peek()
+
+	if t.Kind != tokenString {
+		p.fail()
+	}
+
+	p.pos++
+	return t.Value
+}
+
+func (p *parser) skipRest() {
+	for p.peek().Kind != tokenEOF && !p.isOp(";") {
+		p.pos++
+	}
+}
+
+// skipParens 跳过一组配对的括号，调用时下一个 token 必须是 (。
+func (p *parser) skipParens() {
+	p.expect("(")
+	depth := 1
+
+	for depth > 0 {
+		t := p.next()
+
+		switch {
+		case t.Kind == tokenEOF:
+			p.fail()
+		case t.Kind == tokenOp && t.Value == "(":
+			depth++
+		case t.Kind == tokenOp && t.Value == ")":
+			depth--
+		}
+	}
+}
+
+func (p *parser) parseSelect() *selectStmt {
+	stmt := &selectStmt{}
+
+	if p.accept("DISTINCT") {
+		stmt.Distinct = true
+	} else {
+		p.accept("ALL")
+	}
+
+	for {
+		stmt.Items = append(stmt.Items, p.parseSelectItem())
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if p.accept("FROM") {
+		stmt.Table = p.tableName()
+		p.parseAlias()
+	}
+
+	if p.accept("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+
+	if p.accept("GROUP") {
+		p.expect("BY")
+
+		for {
+			stmt.GroupBy = append(stmt.GroupBy, p.parseExpr())
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("HAVING") {
+		stmt.Having = p.parseExpr()
+	}
+
+	stmt.OrderBy = p.parseOrderBy()
+
+	if p.accept("LIMIT") {
+		stmt.Limit = p.parseExpr()
+
+		if p.accept(",") {
+			stmt.Offset = stmt.Limit
+			stmt.Limit = p.parseExpr()
+		} else if p.accept("OFFSET") {
+			stmt.Offset = p.parseExpr()
+		}
+	}
+
+	// 内存数据库所有语句都是串行执行的，加锁语句没有意义，直接忽略。
+	if p.accept("FOR") {
+		p.expect("UPDATE")
+	} else if p.accept("LOCK") {
+		p.expect("IN", "SHARE", "MODE")
+	}
+
+	return stmt
+}
+
+func (p *parser) parseSelectItem() selectItem {
+	if p.accept("*") {
+		return selectItem{Star: true}
+	}
+
+	if t := p.peek(); t.Kind == tokenIdent && isOp(p.peekAt(1), ".") && isOp(p.peekAt(2), "*") {
+		p.pos += 3
+		return selectItem{Star: true}
+	}
+
+	start := p.peek().Pos
+	item := selectItem{
+		Expr: p.parseExpr(),
+	}
+	item.Text = strings.TrimSpace(p.query[start:p.peek().Pos])
+	item.Alias = p.parseAlias()
+	return item
+}
+
+func (p *parser) parseAlias() string {
+	if p.accept("AS") {
+		if t := p.peek(); t.Kind == tokenString {
+			p.pos++
+			return t.Value
+		}
+
+		return p.ident()
+	}
+
+	if t := p.peek(); t.Kind == tokenIdent && (t.Quoted || !reserved[t.Upper] && t.Upper != "OFFSET") {
+		p.pos++
+		return t.Value
+	}
+
+	return ""
+}
+
+func isOp(t token, op string) bool {
+	return t.Kind == tokenOp && t.Value == op
+}
+
+func (p *parser) parseOrderBy() (items []orderItem) {
+	if !p.accept("ORDER") {
+		return
+	}
+
+	p.expect("BY")
+
+	for {
+		item := orderItem{
+			Expr: p.parseExpr(),
+		}
+
+		if p.accept("DESC") {
+			item.Desc = true
+		} else {
+			p.accept("ASC")
+		}
+
+		items = append(items, item)
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	return
+}
+
+func (p *parser) parseInsert(replace bool) *insertStmt {
+	stmt := &insertStmt{
+		Replace: replace,
+	}
+
+	if p.accept("IGNORE") {
+		stmt.Ignore = true
+	}
+
+	p.accept("INTO")
+	stmt.Table = p.tableName()
+
+	if p.accept("(") {
+		for {
+			stmt.Columns = append(stmt.Columns, p.columnName())
+
+			if !p.accept(",") {
+				break
+			}
+		}
+
+		p.expect(")")
+	}
+
+	if p.accept("SET") {
+		row := []expr{}
+
+		for _, set := range p.parseAssignments() {
+			stmt.Columns = append(stmt.Columns, set.Column)
+			row = append(row, set.Value)
+		}
+
+		stmt.Rows = append(stmt.Rows, row)
+	} else {
+		if !p.accept("VALUES") {
+			p.expect("VALUE")
+		}
+
+		for {
+			p.expect("(")
+			row := []expr{}
+
+			if !p.isOp(")") {
+				for {
+					row = append(row, p.parseExpr())
+
+					if !p.accept(",") {
+						break
+					}
+				}
+			}
+
+			p.expect(")")
+			stmt.Rows = append(stmt.Rows, row)
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("ON") {
+		p.expect("DUPLICATE", "KEY", "UPDATE")
+		stmt.OnDuplicate = p.parseAssignments()
+	}
+
+	return stmt
+}
+
+// columnName 解析列名，允许使用 table.column 的形式，但会忽略 table。
+func (p *parser) columnName() string {
+	name := p.ident()
+
+	if p.accept(".") {
+		name = p.ident()
+	}
+
+	return name
+}
+
+func (p *parser) parseAssignments() (sets []assignment) {
+	for {
+		col := p.columnName()
+		p.expect("=")
+		sets = append(sets, assignment{
+			Column: col,
+			Value:  p.parseExpr(),
+		})
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	return
+}
+
+func (p *parser) parseUpdate() *updateStmt {
+	stmt := &updateStmt{}
+	p.accept("IGNORE")
+	stmt.Table = p.tableName()
+	p.parseAlias()
+	p.expect("SET")
+	stmt.Sets = p.parseAssignments()
+
+	if p.accept("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+
+	stmt.OrderBy = p.parseOrderBy()
+
+	if p.accept("LIMIT") {
+		stmt.Limit = p.parseExpr()
+	}
+
+	return stmt
+}
+
+func (p *parser) parseDelete() *deleteStmt {
+	stmt := &deleteStmt{}
+	p.accept("IGNORE")
+	p.expect("FROM")
+	stmt.Table = p.tableName()
+
+	if p.accept("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+
+	stmt.OrderBy = p.parseOrderBy()
+
+	if p.accept("LIMIT") {
+		stmt.Limit = p.parseExpr()
+	}
+
+	return stmt
+}
+
+func (p *parser) parseDrop() *dropTableStmt {
+	stmt := &dropTableStmt{}
+	p.accept("TEMPORARY")
+	p.expect("TABLE")
+
+	if p.accept("IF") {
+		p.expect("EXISTS")
+		stmt.IfExists = true
+	}
+
+	for {
+		stmt.Tables = append(stmt.Tables, p.tableName())
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	p.skipRest()
+	return stmt
+}
+
+func (p *parser) parseCreate() *createTableStmt {
+	stmt := &createTableStmt{}
+	p.accept("TEMPORARY")
+	p.expect("TABLE")
+
+	if p.accept("IF") {
+		p.expect("NOT", "EXISTS")
+		stmt.IfNotExists = true
+	}
+
+	stmt.Table = p.tableName()
+	p.expect("(")
+
+	for {
+		switch {
+		case p.accept("PRIMARY"):
+			p.expect("KEY")
+			_, stmt.PrimaryKey = p.parseKeyColumns()
+
+		case p.accept("UNIQUE"):
+			if !p.accept("KEY") {
+				p.accept("INDEX")
+			}
+
+			name, cols := p.parseKeyColumns()
+
+			if name == "" {
+				name = cols[0]
+			}
+
+			stmt.UniqueKeys = append(stmt.UniqueKeys, uniqueKeyDef{Name: name, Columns: cols})
+
+		case p.accept("KEY"), p.accept("INDEX"), p.accept("FULLTEXT"), p.accept("SPATIAL"), p.accept("CONSTRAINT"), p.accept("FOREIGN"), p.accept("CHECK"):
+			p.skipDefinition()
+
+		default:
+			col := p.parseColumnDef()
+			stmt.Columns = append(stmt.Columns, col)
+
+			if col.Primary {
+				stmt.PrimaryKey = []string{col.Name}
+			}
+
+			if col.Unique {
+				stmt.UniqueKeys = append(stmt.UniqueKeys, uniqueKeyDef{Name: col.Name, Columns: []string{col.Name}})
+			}
+		}
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	p.expect(")")
+
+	// 忽略所有的表选项，比如 ENGINE=InnoDB DEFAULT CHARSET=utf8mb4。
+	p.skipRest()
+	return stmt
+}
+
+// skipDefinition 跳过一个不关心的定义，直到遇到同一层级的 , 或者 )。
+func (p *parser) skipDefinition() {
+	for !p.isOp(",") && !p.isOp(")") {
+		if p.peek().Kind == tokenEOF {
+			p.fail()
+		}
+
+		if p.isOp("(") {
+			p.skipParens()
+		} else {
+			p.pos++
+		}
+	}
+}
+
+// parseKeyColumns 解析索引定义里面的列名，忽略索引名、前缀长度和排序方向。
+func (p *parser) parseKeyColumns() (name string, cols []string) {
+	if !p.isOp("(") {
+		name = p.ident()
+	}
+
+	p.expect("(")
+
+	for {
+		cols = append(cols, p.ident())
+
+		if p.isOp("(") {
+			p.skipParens()
+		}
+
+		if !p.accept("ASC") {
+			p.accept("DESC")
+		}
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	p.expect(")")
+
+	// 忽略 USING BTREE 之类的索引选项。
+	p.skipDefinition()
+	return
+}
+
+func (p *parser) parseColumnDef() *columnDef {
+	col := &columnDef{
+		Name: p.ident(),
+	}
+	t := p.next()
+
+	if t.Kind != tokenIdent {
+		p.fail()
+	}
+
+	col.TypeName = t.Upper
+
+	switch t.Upper {
+	case "INT", "INTEGER", "BIGINT", "TINYINT", "SMALLINT", "MEDIUMINT", "BOOL", "BOOLEAN", "BIT", "YEAR":
+		col.Type = typeInt
+	case "FLOAT", "DOUBLE", "DECIMAL", "NUMERIC", "REAL", "DEC":
+		col.Type = typeFloat
+	case "CHAR", "VARCHAR", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT", "ENUM", "SET", "JSON", "TIME":
+		col.Type = typeString
+	case "BINARY", "VARBINARY", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB":
+		col.Type = typeBytes
+	case "DATETIME", "TIMESTAMP":
+		col.Type = typeDateTime
+	case "DATE":
+		col.Type = typeDate
+	default:
+		p.pos--
+		p.fail()
+	}
+
+	if p.isOp("(") {
+		p.skipParens()
+	}
+
+	for {
+		switch {
+		case p.accept("NOT"):
+			p.expect("NULL")
+			col.NotNull = true
+		case p.accept("NULL"):
+		case p.accept("AUTO_INCREMENT"):
+			col.AutoIncrement = true
+		case p.accept("DEFAULT"):
+			col.Default = p.parseUnary()
+		case p.accept("PRIMARY"):
+			p.expect("KEY")
+			col.Primary = true
+			col.NotNull = true
+		case p.accept("UNIQUE"):
+			p.accept("KEY")
+			col.Unique = true
+		case p.accept("KEY"):
+			col.Primary = true
+			col.NotNull = true
+		case p.accept("COMMENT"):
+			if p.next().Kind != tokenString {
+				p.fail()
+			}
+		case p.accept("CHARACTER"):
+			p.expect("SET")
+			p.next()
+		case p.accept("CHARSET"), p.accept("COLLATE"):
+			p.next()
+		case p.accept("ON"):
+			p.expect("UPDATE")
+			p.parseUnary()
+		case p.accept("UNSIGNED"), p.accept("SIGNED"), p.accept("ZEROFILL"), p.accept("BINARY"):
+		default:
+			return col
+		}
+	}
+}
+
+func (p *parser) parseExpr() expr {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() expr {
+	x := p.parseAnd()
+
+	for p.accept("OR") || p.accept("||") {
+		x = &binaryExpr{Op: "OR", L: x, R: p.parseAnd()}
+	}
+
+	return x
+}
+
+func (p *parser) parseAnd() expr {
+	x := p.parseNot()
+
+	for p.accept("AND") || p.accept("&&") {
+		x = &binaryExpr{Op: "AND", L: x, R: p.parseNot()}
+	}
+
+	return x
+}
+
+func (p *parser) parseNot() expr {
+	if p.accept("NOT") {
+		return &unaryExpr{Op: "NOT", X: p.parseNot()}
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() expr {
+	x := p.parseAdditive()
+
+	for {
+		t := p.peek()
+
+		switch {
+		case t.Kind == tokenOp && (t.Value == "=" || t.Value == "<=>" || t.Value == "<>" || t.Value == "!=" ||
+			t.Value == "<" || t.Value == "<=" || t.Value == ">" || t.Value == ">="):
+			p.pos++
+			op := t.Value
+
+			if op == "!=" {
+				op = "<>"
+			}
+
+			x = &binaryExpr{Op: op, L: x, R: p.parseAdditive()}
+
+		case p.accept("IS"):
+			not := p.accept("NOT")
+
+			if p.accept("TRUE") {
+				x = &binaryExpr{Op: "<=>", L: &unaryExpr{Op: "NOT", X: &unaryExpr{Op: "NOT", X: x}}, R: &literalExpr{Value: int64(1)}}
+			} else if p.accept("FALSE") {
+				x = &binaryExpr{Op: "<=>", L: &unaryExpr{Op: "NOT", X: &unaryExpr{Op: "NOT", X: x}}, R: &literalExpr{Value: int64(0)}}
+			} else {
+				p.expect("NULL")
+				x = &isNullExpr{X: x, Not: not}
+				continue
+			}
+
+			if not {
+				x = &unaryExpr{Op: "NOT", X: x}
+			}
+
+		case p.isWord("NOT") && (isWord(p.peekAt(1), "IN") || isWord(p.peekAt(1), "LIKE") || isWord(p.peekAt(1), "BETWEEN")):
+			p.pos++
+			x = p.parsePredicate(x, true)
+
+		case p.isWord("IN") || p.isWord("LIKE") || p.isWord("BETWEEN"):
+			x = p.parsePredicate(x, false)
+
+		default:
+			return x
+		}
+	}
+}
+
+func (p *parser) parsePredicate(x expr, not bool) expr {
+	switch {
+	case p.accept("IN"):
+		p.expect("(")
+		in := &inExpr{X: x, Not: not}
+
+		for {
+			in.List = append(in.List, p.parseExpr())
+
+			if !p.accept(",") {
+				break
+			}
+		}
+
+		p.expect(")")
+		return in
+
+	case p.accept("LIKE"):
+		return &likeExpr{X: x, Pattern: p.parseAdditive(), Not: not}
+
+	default:
+		p.expect("BETWEEN")
+		lo := p.parseAdditive()
+		p.expect("AND")
+		hi := p.parseAdditive()
+		return &betweenExpr{X: x, Lo: lo, Hi: hi, Not: not}
+	}
+}
+
+func (p *parser) parseAdditive() expr {
+	x := p.parseMultiplicative()
+
+	for {
+		if p.accept("+") {
+			x = &binaryExpr{Op: "+", L: x, R: p.parseMultiplicative()}
+		} else if p.accept("-") {
+			x = &binaryExpr{Op: "-", L: x, R: p.parseMultiplicative()}
+		} else {
+			return x
+		}
+	}
+}
+
+func (p *parser) parseMultiplicative() expr {
+	x := p.parseUnary()
+
+	for {
+		switch {
+		case p.accept("*"):
+			x = &binaryExpr{Op: "*", L: x, R: p.parseUnary()}
+		case p.accept("/"):
+			x = &binaryExpr{Op: "/", L: x, R: p.parseUnary()}
+		case p.accept("%"), p.accept("MOD"):
+			x = &binaryExpr{Op: "%", L: x, R: p.parseUnary()}
+		case p.accept("DIV"):
+			x = &binaryExpr{Op: "DIV", L: x, R: p.parseUnary()}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *parser) parseUnary() expr {
+	switch {
+	case p.accept("-"):
+		return &unaryExpr{Op: "-", X: p.parseUnary()}
+	case p.accept("+"):
+		return p.parseUnary()
+	case p.accept("!"):
+		return &unaryExpr{Op: "NOT", X: p.parseUnary()}
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() expr {
+	t := p.peek()
+
+	switch t.Kind {
+	case tokenNumber:
+		p.pos++
+
+		if !strings.ContainsAny(t.Value, ".eE") {
+			if n, err := strconv.ParseInt(t.Value, 10, 64); err == nil {
+				return &literalExpr{Value: n}
+			}
+		}
+
+		f, err := strconv.ParseFloat(t.Value, 64)
+
+		if err != nil {
+			p.pos--
+			p.fail()
+		}
+
+		return &literalExpr{Value: f}
+
+	case tokenString:
+		p.pos++
+		return &literalExpr{Value: t.Value}
+
+	case tokenParam:
+		p.pos++
+		param := &paramExpr{Index: p.params}
+		p.params++
+		return param
+
+	case tokenOp:
+		if t.Value != "(" {
+			break
+		}
+
+		p.pos++
+		x := p.parseExpr()
+
+		if p.accept(",") {
+			tuple := &tupleExpr{List: []expr{x}}
+
+			for {
+				tuple.List = append(tuple.List, p.parseExpr())
+
+				if !p.accept(",") {
+					break
+				}
+			}
+
+			x = tuple
+		}
+
+		p.expect(")")
+		return x
+
+	case tokenIdent:
+		if !t.Quoted {
+			switch t.Upper {
+			case "NULL":
+				p.pos++
+				return &literalExpr{}
+			case "TRUE":
+				p.pos++
+				return &literalExpr{Value: int64(1)}
+			case "FALSE":
+				p.pos++
+				return &literalExpr{Value: int64(0)}
+			case "CURRENT_TIMESTAMP", "LOCALTIMESTAMP", "LOCALTIME":
+				if !isOp(p.peekAt(1), "(") {
+					p.pos++
+					return &funcExpr{Name: "NOW"}
+				}
+			}
+		}
+
+		if !t.Quoted && strings.HasPrefix(t.Value, "@@") {
+			p.pos++
+			name := t.Value[2:]
+
+			switch strings.ToUpper(name) {
+			case "GLOBAL", "SESSION", "LOCAL":
+				if p.accept(".") {
+					name = p.ident()
+				}
+			}
+
+			return &variableExpr{Name: strings.ToLower(name)}
+		}
+
+		if isOp(p.peekAt(1), "(") && (t.Quoted || !reserved[t.Upper] || t.Upper == "VALUES" || t.Upper == "IF" || t.Upper == "REPLACE") {
+			return p.parseFunc()
+		}
+
+		p.pos++
+
+		if reserved[t.Upper] && !t.Quoted {
+			p.pos--
+			p.fail()
+		}
+
+		if p.accept(".") {
+			return &columnExpr{Table: t.Value, Name: p.ident()}
+		}
+
+		return &columnExpr{Name: t.Value}
+	}
+
+	p.fail()
+	return nil
+}
+
+func (p *parser) parseFunc() expr {
+	fn := &funcExpr{
+		Name: strings.ToUpper(p.next().Value),
+	}
+	p.expect("(")
+
+	if p.accept(")") {
+		return fn
+	}
+
+	if p.accept("*") {
+		fn.Star = true
+		p.expect(")")
+		return fn
+	}
+
+	if p.accept("DISTINCT") {
+		fn.Distinct = true
+	}
+
+	for {
+		fn.Args = append(fn.Args, p.parseExpr())
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	p.expect(")")
+	return fn
+}
@@ -0,0 +1,330 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+var lastTableID uint64
+
+type column struct {
+	Name          string
+	Type          columnType
+	TypeName      string
+	NotNull       bool
+	AutoIncrement bool
+	Default       expr
+}
+
+type uniqueKey struct {
+	Name    string
+	Columns []int
+}
+
+// table 是一张内存表。
+// 为了方便实现事务和语句的原子性，table 一旦被放进 database 或事务里就不再修改，
+// 所有写操作都先 clone 一份再修改，成功之后替换原来的表。
+type table struct {
+	ID            uint64 // ID 用来识别 DROP 之后重建的同名表。
+	Name          string
+	Columns       []*column
+	Rows          [][]value
+	AutoIncrement int64 // AutoIncrement 是下一个自增值。
+	Keys          []uniqueKey
+
+	index map[string]int
+}
+
+func newTable(stmt *createTableStmt) (t *table, err error) {
+	t = &table{
+		ID:            atomic.AddUint64(&lastTableID, 1),
+		Name:          stmt.Table,
+		AutoIncrement: 1,
+		index:         make(map[string]int, len(stmt.Columns)),
+	}
+
+	for i, def := range stmt.Columns {
+		name := strings.ToLower(def.Name)
+
+		if _, ok := t.index[name]; ok {
+			return nil, newErrorf(1060, "Duplicate column name '%v'", def.Name)
+		}
+
+		t.index[name] = i
+		t.Columns = append(t.Columns, &column{
+			Name:          def.Name,
+			Type:          def.Type,
+			TypeName:      def.TypeName,
+			NotNull:       def.NotNull,
+			AutoIncrement: def.AutoIncrement,
+			Default:       def.Default,
+		})
+	}
+
+	if len(stmt.PrimaryKey) > 0 {
+		key, err := t.makeKey("PRIMARY", stmt.PrimaryKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, idx := range key.Columns {
+			t.Columns[idx].NotNull = true
+		}
+
+		t.Keys = append(t.Keys, key)
+	}
+
+	for _, def := range stmt.UniqueKeys {
+		key, err := t.makeKey(def.Name, def.Columns)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.Keys = append(t.Keys, key)
+	}
+
+	return
+}
+
+func (t *table) makeKey(name string, cols []string) (key uniqueKey, err error) {
+	key.Name = name
+
+	for _, col := range cols {
+		idx, ok := t.index[strings.ToLower(col)]
+
+		if !ok {
+			err = newErrorf(1072, "Key column '%v' doesn't exist in table", col)
+			return
+		}
+
+		key.Columns = append(key.Columns, idx)
+	}
+
+	return
+}
+
+func (t *table) clone() *table {
+	cp := *t
+	cp.Rows = make([][]value, len(t.Rows), len(t.Rows)+1)
+	copy(cp.Rows, t.Rows)
+	return &cp
+}
+
+func (t *table) column(name string) (idx int, ok bool) {
+	idx, ok = t.index[strings.ToLower(name)]
+	return
+}
+
+// keySet 记录了一个唯一索引里所有的键值与行号的对应关系。
+type keySet map[string]int
+
+// keyString 返回 row 在 key 上的键值，如果任意一列是 NULL 则返回 false，
+// 与 MySQL 一样，NULL 不参与唯一性检查。
+func keyString(key *uniqueKey, row []value) (s string, ok bool) {
+	buf := &strings.Builder{}
+
+	for i, idx := range key.Columns {
+		v := row[idx]
+
+		if v == nil {
+			return
+		}
+
+		if i > 0 {
+			buf.WriteByte('-')
+		}
+
+		buf.WriteString(toString(v))
+	}
+
+	return buf.String(), true
+}
+
+// buildKeySets 为所有唯一索引建立 keySet，用于写操作时检查唯一性。
+// 被删除的行在 Rows 里是 nil。
+func (t *table) buildKeySets() []keySet {
+	sets := make([]keySet, len(t.Keys))
+
+	for i := range t.Keys {
+		set := make(keySet, len(t.Rows))
+		key := &t.Keys[i]
+
+		for rowIdx, row := range t.Rows {
+			if row == nil {
+				continue
+			}
+
+			if s, ok := keyString(key, row); ok {
+				set[s] = rowIdx
+			}
+		}
+
+		sets[i] = set
+	}
+
+	return sets
+}
+
+// findConflict 找到与 row 有唯一索引冲突的行，except 是需要忽略的行号。
+func (t *table) findConflict(sets []keySet, row []value, except int) (rowIdx int, key *uniqueKey, keyValue string) {
+	for i := range t.Keys {
+		key = &t.Keys[i]
+		s, ok := keyString(key, row)
+
+		if !ok {
+			continue
+		}
+
+		if idx, exists := sets[i][s]; exists && idx != except {
+			return idx, key, s
+		}
+	}
+
+	return -1, nil, ""
+}
+
+func (t *table) addKeys(sets []keySet, row []value, rowIdx int) {
+	for i := range t.Keys {
+		if s, ok := keyString(&t.Keys[i], row); ok {
+			sets[i][s] = rowIdx
+		}
+	}
+}
+
+func (t *table) removeKeys(sets []keySet, row []value) {
+	for i := range t.Keys {
+		if s, ok := keyString(&t.Keys[i], row); ok {
+			delete(sets[i], s)
+		}
+	}
+}
+
+// compact 移除所有被标记为删除的行。
+func (t *table) compact() {
+	rows := t.Rows[:0]
+
+	for _, row := range t.Rows {
+		if row != nil {
+			rows = append(rows, row)
+		}
+	}
+
+	for i := len(rows); i < len(t.Rows); i++ {
+		t.Rows[i] = nil
+	}
+
+	t.Rows = rows
+}
+
+func dupEntryError(key *uniqueKey, keyValue string) error {
+	return newErrorf(errDupEntry, "Duplicate entry '%v' for key '%v'", keyValue, key.Name)
+}
+
+// rowID 返回 row 的身份，有主键时是主键的值，否则是 row 本身的地址。
+// 所有写操作都会为修改后的行生成新的 []value，因此没有主键时修改过的行会被当作删除旧行并插入新行。
+func (t *table) rowID(row []value) string {
+	if len(t.Keys) > 0 && t.Keys[0].Name == "PRIMARY" {
+		if s, ok := keyString(&t.Keys[0], row); ok {
+			return s
+		}
+	}
+
+	return fmt.Sprintf("%p", &row[0])
+}
+
+func (t *table) rowMap() map[string][]value {
+	if t == nil {
+		return nil
+	}
+
+	rows := make(map[string][]value, len(t.Rows))
+
+	for _, row := range t.Rows {
+		rows[t.rowID(row)] = row
+	}
+
+	return rows
+}
+
+func sameRow(a, b []value) bool {
+	return &a[0] == &b[0]
+}
+
+// merge 将事务中的 t 相对于 base 修改过的行合并到这张表当前在 database 里的版本 current 上，返回合并之后的新表。
+// base 是事务第一次修改这张表时 database 里的版本。
+// 如果事务修改过的行在 current 里已经不是 base 里的样子，说明其他连接同时也修改了这一行，返回死锁错误；
+// 如果事务插入的行与其他连接插入的行有唯一键冲突，返回 1062 错误。
+func (t *table) merge(base, current *table) (*table, error) {
+	baseRows := base.rowMap()
+	txRows := t.rowMap()
+	curRows := current.rowMap()
+	changed := make(map[string]bool)
+
+	for id, row := range baseRows {
+		if r, ok := txRows[id]; !ok || !sameRow(r, row) {
+			changed[id] = true
+		}
+	}
+
+	for id := range txRows {
+		if _, ok := baseRows[id]; !ok {
+			changed[id] = true
+		}
+	}
+
+	for id := range changed {
+		b, inBase := baseRows[id]
+		c, inCur := curRows[id]
+
+		switch {
+		case !inBase && inCur:
+			return nil, dupEntryError(&t.Keys[0], id)
+		case inBase && (!inCur || !sameRow(b, c)):
+			return nil, newError(errDeadlock, "Deadlock found when trying to get lock; try restarting transaction")
+		}
+	}
+
+	m := current.clone()
+
+	if t.AutoIncrement > m.AutoIncrement {
+		m.AutoIncrement = t.AutoIncrement
+	}
+
+	if len(changed) == 0 {
+		return m, nil
+	}
+
+	for i, row := range m.Rows {
+		if id := m.rowID(row); changed[id] {
+			m.Rows[i] = txRows[id]
+			delete(changed, id)
+		}
+	}
+
+	// 剩下的都是事务中新插入的行，按照事务中的顺序追加。
+	for _, row := range t.Rows {
+		if id := t.rowID(row); changed[id] {
+			m.Rows = append(m.Rows, row)
+		}
+	}
+
+	m.compact()
+	sets := make([]keySet, len(m.Keys))
+
+	for i := range sets {
+		sets[i] = make(keySet, len(m.Rows))
+	}
+
+	for rowIdx, row := range m.Rows {
+		if conflict, key, keyValue := m.findConflict(sets, row, -1); conflict >= 0 {
+			return nil, dupEntryError(key, keyValue)
+		}
+
+		m.addKeys(sets, row, rowIdx)
+	}
+
+	return m, nil
+}
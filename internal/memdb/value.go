@@ -0,0 +1,325 @@
+package memdb
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// value 是内存数据库内部使用的值，只会是以下类型之一：
+// nil、int64、float64、string、[]byte、time.Time。
+type value interface{}
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// fromDriverValue 将 database/sql 传入的参数转化成内部的值。
+func fromDriverValue(v driver.Value) (value, error) {
+	switch val := v.(type) {
+	case nil, int64, float64, string, time.Time:
+		return val, nil
+	case []byte:
+		return append([]byte{}, val...), nil
+	case bool:
+		if val {
+			return int64(1), nil
+		}
+
+		return int64(0), nil
+	}
+
+	return nil, fmt.Errorf("go-mysql: unsupported argument type %T", v)
+}
+
+// toDriverValue 将内部的值转化成返回给 database/sql 的值，字符串会与 MySQL 一样以 []byte 的形式返回。
+func toDriverValue(v value) driver.Value {
+	switch val := v.(type) {
+	case string:
+		return []byte(val)
+	case []byte:
+		return append([]byte{}, val...)
+	}
+
+	return v
+}
+
+func isNumber(v value) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+
+	return false
+}
+
+func toString(v value) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		if val.Nanosecond() != 0 {
+			return val.Format("2006-01-02 15:04:05.999999")
+		}
+
+		return val.Format("2006-01-02 15:04:05")
+	}
+
+	return fmt.Sprint(v)
+}
+
+// toFloat 将 v 转化成 float64，字符串会像 MySQL 一样尽量解析开头的数字部分。
+func toFloat(v value) float64 {
+	switch val := v.(type) {
+	case int64:
+		return float64(val)
+	case float64:
+		return val
+	case time.Time:
+		f, _ := strconv.ParseFloat(val.Format("20060102150405"), 64)
+		return f
+	case string, []byte:
+		return parseNumberPrefix(toString(val))
+	}
+
+	return 0
+}
+
+func toInt(v value) int64 {
+	switch val := v.(type) {
+	case int64:
+		return val
+	case float64:
+		return int64(math.Round(val))
+	}
+
+	return int64(math.Round(toFloat(v)))
+}
+
+func parseNumberPrefix(s string) float64 {
+	s = strings.TrimSpace(s)
+	end := 0
+
+	for end < len(s) {
+		c := s[end]
+
+		if c >= '0' && c <= '9' || c == '.' || (end == 0 && (c == '-' || c == '+')) ||
+			(c == 'e' || c == 'E') && end > 0 {
+			end++
+			continue
+		}
+
+		break
+	}
+
+	for end > 0 {
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return f
+		}
+
+		end--
+	}
+
+	return 0
+}
+
+func parseTime(s string) (t time.Time, ok bool) {
+	for _, layout := range timeLayouts {
+		var err error
+		t, err = time.ParseInLocation(layout, s, time.Local)
+
+		if err == nil {
+			return t, true
+		}
+	}
+
+	return
+}
+
+func toBool(v value) (b bool, null bool) {
+	if v == nil {
+		return false, true
+	}
+
+	return toFloat(v) != 0, false
+}
+
+func boolValue(b bool) value {
+	if b {
+		return int64(1)
+	}
+
+	return int64(0)
+}
+
+// compare 比较 a 和 b，规则近似于 MySQL：
+// 两边有一边是数字就按数字比较，有一边是时间就按时间比较，否则按字符串比较。
+// 调用者需要自己保证 a 和 b 都不是 nil。
+func compare(a, b value) int {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := toTime(b); ok {
+			return compareTime(ta, tb)
+		}
+	}
+
+	if tb, ok := b.(time.Time); ok {
+		if ta, ok := toTime(a); ok {
+			return compareTime(ta, tb)
+		}
+	}
+
+	if isNumber(a) || isNumber(b) {
+		if ia, ok := a.(int64); ok {
+			if ib, ok := b.(int64); ok {
+				switch {
+				case ia < ib:
+					return -1
+				case ia > ib:
+					return 1
+				default:
+					return 0
+				}
+			}
+		}
+
+		fa, fb := toFloat(a), toFloat(b)
+
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	if ba, ok := a.([]byte); ok {
+		if bb, ok := b.([]byte); ok {
+			return bytes.Compare(ba, bb)
+		}
+	}
+
+	return strings.Compare(toString(a), toString(b))
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toTime(v value) (t time.Time, ok bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, true
+	case string, []byte:
+		return parseTime(toString(val))
+	}
+
+	return
+}
+
+// convert 将 v 转化成 col 的列类型，用于写入数据。
+func convert(col *column, v value) (value, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch col.Type {
+	case typeInt:
+		if t, ok := v.(time.Time); ok {
+			return int64(toFloat(t)), nil
+		}
+
+		return toInt(v), nil
+
+	case typeFloat:
+		return toFloat(v), nil
+
+	case typeString:
+		return toString(v), nil
+
+	case typeBytes:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+
+		return []byte(toString(v)), nil
+
+	case typeDateTime, typeDate:
+		t, ok := toTime(v)
+
+		if !ok {
+			return nil, newErrorf(errTruncatedWrongVal, "Incorrect datetime value: '%v' for column '%v' at row 1", toString(v), col.Name)
+		}
+
+		// 与 MySQL 一样，默认只保留到秒。
+		t = t.In(time.Local).Truncate(time.Second)
+
+		if col.Type == typeDate {
+			y, m, d := t.Date()
+			t = time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+		}
+
+		return t, nil
+	}
+
+	return v, nil
+}
+
+// matchLike 判断 s 是否匹配 LIKE 模式 pattern，支持 % 和 _ 以及 \ 转义。
+func matchLike(s, pattern string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+
+	switch pattern[0] {
+	case '%':
+		for i := 0; i <= len(s); i++ {
+			if matchLike(s[i:], pattern[1:]) {
+				return true
+			}
+		}
+
+		return false
+
+	case '_':
+		if s == "" {
+			return false
+		}
+
+		return matchLike(s[1:], pattern[1:])
+
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+
+	if s == "" || s[0] != pattern[0] {
+		return false
+	}
+
+	return matchLike(s[1:], pattern[1:])
+}
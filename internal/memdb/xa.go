@@ -0,0 +1,172 @@
+package memdb
+
+import (
+	"sort"
+	"strconv"
+)
+
+// XA 事务在连接上的状态，PREPARE 之后的事务保存在 database.prepared 里。
+const (
+	xaActive = "ACTIVE"
+	xaIdle   = "IDLE"
+)
+
+type preparedXA struct {
+	xid xid
+	tx  *txState
+}
+
+func (x xid) key() string {
+	return x.Gtrid + "\x00" + x.Bqual + "\x00" + strconv.FormatInt(x.FormatID, 10)
+}
+
+func (s *session) endXA() {
+	s.xid = nil
+	s.xaState = ""
+}
+
+// inXA 判断连接上是否有 xid 对应的 XA 事务。
+func (s *session) inXA(x xid) bool {
+	return s.xid != nil && s.xid.key() == x.key()
+}
+
+func (s *session) xaStateError() error {
+	state := s.xaState
+
+	if state == "" {
+		state = "NON-EXISTING"
+	}
+
+	return newErrorf(errXAERRmfail, "XAER_RMFAIL: The command cannot be executed when global transaction is in the  %v state", state)
+}
+
+func (s *session) execXA(stmt *xaStmt) (*result, error) {
+	switch stmt.Action {
+	case "START":
+		if s.xid != nil {
+			return nil, s.xaStateError()
+		}
+
+		if s.tx != nil {
+			return nil, newError(errXAEROutside, "XAER_OUTSIDE: Some work is done outside global transaction")
+		}
+
+		s.db.mu.Lock()
+		_, dup := s.db.prepared[stmt.XID.key()]
+		s.db.mu.Unlock()
+
+		if dup {
+			return nil, newError(errXAERDupID, "XAER_DUPID: The XID already exists")
+		}
+
+		s.begin()
+		x := stmt.XID
+		s.xid = &x
+		s.xaState = xaActive
+		return &result{}, nil
+
+	case "END":
+		if !s.inXA(stmt.XID) {
+			return nil, newError(errXAERNota, "XAER_NOTA: Unknown XID")
+		}
+
+		if s.xaState != xaActive {
+			return nil, s.xaStateError()
+		}
+
+		s.xaState = xaIdle
+		return &result{}, nil
+
+	case "PREPARE":
+		if !s.inXA(stmt.XID) {
+			return nil, newError(errXAERNota, "XAER_NOTA: Unknown XID")
+		}
+
+		if s.xaState != xaIdle {
+			return nil, s.xaStateError()
+		}
+
+		s.db.mu.Lock()
+
+		if s.db.prepared == nil {
+			s.db.prepared = make(map[string]*preparedXA)
+		}
+
+		s.db.prepared[stmt.XID.key()] = &preparedXA{
+			xid: stmt.XID,
+			tx:  s.tx,
+		}
+		s.db.mu.Unlock()
+
+		s.tx = nil
+		s.endXA()
+		return &result{}, nil
+
+	case "COMMIT", "ROLLBACK":
+		if s.inXA(stmt.XID) {
+			// 连接上还没有 PREPARE 的事务只能 ONE PHASE 提交或者直接回滚。
+			if s.xaState != xaIdle || stmt.Action == "COMMIT" && !stmt.OnePhase {
+				return nil, s.xaStateError()
+			}
+
+			if stmt.Action == "COMMIT" {
+				return &result{}, s.commit()
+			}
+
+			s.rollback()
+			return &result{}, nil
+		}
+
+		if s.xid != nil {
+			return nil, s.xaStateError()
+		}
+
+		if stmt.OnePhase {
+			return nil, newError(errXAERNota, "XAER_NOTA: Unknown XID")
+		}
+
+		s.db.mu.Lock()
+		defer s.db.mu.Unlock()
+		key := stmt.XID.key()
+		p, ok := s.db.prepared[key]
+
+		if !ok {
+			return nil, newError(errXAERNota, "XAER_NOTA: Unknown XID")
+		}
+
+		if stmt.Action == "COMMIT" {
+			// 提交失败时事务依然处于 PREPARE 状态，可以重试或者回滚。
+			if err := s.db.apply(p.tx); err != nil {
+				return nil, err
+			}
+		}
+
+		delete(s.db.prepared, key)
+
+		return &result{}, nil
+
+	case "RECOVER":
+		s.db.mu.Lock()
+		defer s.db.mu.Unlock()
+		res := &result{
+			Columns: []string{"formatID", "gtrid_length", "bqual_length", "data"},
+			Types:   []string{"BIGINT", "BIGINT", "BIGINT", "VARCHAR"},
+		}
+		keys := make([]string, 0, len(s.db.prepared))
+
+		for key := range s.db.prepared {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			x := s.db.prepared[key].xid
+			res.Rows = append(res.Rows, []value{x.FormatID, int64(len(x.Gtrid)), int64(len(x.Bqual)), x.Gtrid + x.Bqual})
+		}
+
+		return res, nil
+	}
+
+	return nil, newError(errNotSupported, "This version of MySQL doesn't yet support this statement")
+}
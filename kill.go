@@ -0,0 +1,149 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+const (
+	killQueryTimeout = 3 * time.Second // killQueryTimeout 是执行 KILL QUERY 的超时时间，与调用者的 ctx 无关。
+	killMaxConns     = 4               // killMaxConns 是每个控制连接池最多打开的连接数。
+)
+
+// killer 通过单独的控制连接执行 KILL QUERY，终止 ctx 结束后依然在 MySQL 上执行的语句。
+//
+// ctx 结束时 go-sql-driver 只会断开客户端的连接，服务端要等到语句执行完成后才会发现连接已经断开，
+// 在此之前慢查询会一直占用 CPU 和锁。
+// 控制连接使用独立的连接池，不受 Config.MaxOpenConns 限制，连接池耗尽时也能执行 KILL。
+type killer struct {
+	Name    string // Name 是控制连接的地址和数据库名，用于日志和监控。
+	Control *sql.DB
+}
+
+func newKiller(ctx context.Context, f *Factory, dsn string) (k *killer, err error) {
+	control, err := f.newDB(ctx, dsn)
+
+	if err != nil {
+		return
+	}
+
+	control.SetMaxOpenConns(killMaxConns)
+	control.SetMaxIdleConns(1)
+	k = &killer{
+		Name:    dsnName(dsn),
+		Control: control,
+	}
+	return
+}
+
+// pinConn 从 db 中取出一个连接并查询它的 CONNECTION_ID()，调用者用完之后需要关闭 conn。
+func pinConn(ctx context.Context, db *sql.DB) (conn *sql.Conn, id int64, err error) {
+	conn, err = db.Conn(ctx)
+
+	if err != nil {
+		return
+	}
+
+	if err = conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id); err != nil {
+		conn.Close()
+		conn = nil
+		return
+	}
+
+	return
+}
+
+// Exec 在一个固定的连接上执行 query，ctx 结束时 KILL 这条语句。
+func (k *killer) Exec(ctx context.Context, db *sql.DB, query string, args []interface{}) (res sql.Result, err error) {
+	conn, id, err := pinConn(ctx, db)
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+	stop := k.Watch(ctx, id, query)
+	res, err = conn.ExecContext(ctx, query, args...)
+	stop(err)
+	return
+}
+
+// Query 在一个固定的连接上执行 query，ctx 结束时 KILL 这条语句。
+// 查询成功时，调用者必须在关闭 rows 之后调用 release，参数是 rows.Err()。
+func (k *killer) Query(ctx context.Context, db *sql.DB, query string, args []interface{}) (rows *sql.Rows, release func(err error), err error) {
+	conn, id, err := pinConn(ctx, db)
+
+	if err != nil {
+		return
+	}
+
+	stop := k.Watch(ctx, id, query)
+	rows, err = conn.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		stop(err)
+		conn.Close()
+		return
+	}
+
+	release = func(err error) {
+		stop(err)
+		conn.Close()
+	}
+	return
+}
+
+// Watch 在 ctx 结束时 KILL 连接 id 上正在执行的 query。
+// 语句结束后、连接归还连接池之前必须调用 stop，参数是语句返回的错误，
+// 这样可以保证 KILL 不会误杀这个连接之后执行的其他语句。
+func (k *killer) Watch(ctx context.Context, id int64, query string) (stop func(err error)) {
+	if ctx.Done() == nil {
+		return func(error) {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	killed := false
+
+	go func() {
+		defer close(finished)
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			k.kill(ctx, id, query)
+			killed = true
+		}
+	}()
+
+	return func(err error) {
+		close(done)
+		<-finished
+
+		// ctx 可能在语句返回的同时结束，这时 select 不一定会选中 ctx.Done()，
+		// 语句因为 ctx 结束而返回说明 driver 已经放弃了这条语句，依然需要 KILL。
+		if !killed && err != nil && err == ctx.Err() {
+			k.kill(ctx, id, query)
+		}
+	}
+}
+
+func (k *killer) kill(ctx context.Context, id int64, query string) {
+	killCtx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+	defer cancel()
+
+	if _, err := k.Control.ExecContext(killCtx, "KILL QUERY "+strconv.FormatInt(id, 10)); err != nil {
+		log.Errorf(ctx, "err=%v||conn_id=%v||query=%v||go-mysql: fail to kill query", err, id, query)
+		return
+	}
+
+	statsForKillQuery(ctx, k.Name, id, query)
+}
+
+func (k *killer) Close() error {
+	return k.Control.Close()
+}
@@ -0,0 +1,88 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/altstory/go-runner"
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+func TestKillOnCancel(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN:          memdb.Scheme + testDB,
+		KillOnCancel: true,
+		Timeout: ConfigTimeout{
+			Read:  20 * time.Millisecond,
+			Write: 20 * time.Millisecond,
+		},
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+	stats := &runner.Stats{}
+	ctx := runner.WithStats(context.Background(), stats)
+	m := initTable(ctx, t, f, "kill", "id BIGINT PRIMARY KEY")
+
+	// 正常执行的语句不会被 KILL。
+	_, err := m.Exec("INSERT INTO kill VALUES (1), (2)")
+	a.NilError(err)
+	var ids []int64
+	a.NilError(m.QueryColumn(&ids, "SELECT id FROM kill ORDER BY id"))
+	a.Equal(ids, []int64{1, 2})
+
+	// ScanAll 已经关闭了 rows，再次 Close 不会重复释放。
+	rows, err := m.Query("SELECT id FROM kill ORDER BY id")
+	a.NilError(err)
+	ids = nil
+	a.NilError(rows.ScanAll(&ids))
+	a.NilError(rows.Close())
+	a.Equal(ids, []int64{1, 2})
+	a.Equal(statsValue(stats, mysqlKillQueryStatsKey), 0)
+
+	// 超时的语句会被 KILL。
+	var v int64
+	_, err = m.Exec("SELECT SLEEP(1)")
+	a.Equal(err, context.DeadlineExceeded)
+	a.Equal(m.QueryScalar(&v, "SELECT SLEEP(1)"), context.DeadlineExceeded)
+	row, err := m.QueryRow("SELECT SLEEP(1)")
+	a.NilError(err)
+	a.Equal(row.Scan(&v), context.DeadlineExceeded)
+	a.Equal(statsValue(stats, mysqlKillQueryStatsKey), 3)
+
+	// 事务中的语句也会被 KILL，事务所在的连接会被归还。
+	tx, err := m.BeginTx(nil)
+	a.NilError(err)
+	a.NilError(tx.QueryScalar(&v, "SELECT COUNT(*) FROM kill"))
+	a.Equal(v, int64(2))
+	_, err = tx.Exec("SELECT SLEEP(1)")
+	a.Equal(err, context.DeadlineExceeded)
+	a.Equal(statsValue(stats, mysqlKillQueryStatsKey), 4)
+	tx.Rollback()
+	a.Equal(m.Stats().InUse, 0)
+
+	// KILL QUERY 可以终止服务端不受 ctx 控制的语句。
+	master := f.conn().Master
+	k := f.conn().Killers[master]
+	conn, id, err := pinConn(ctx, master)
+	a.NilError(err)
+	defer conn.Close()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- conn.QueryRowContext(context.Background(), "SELECT SLEEP(10)").Scan(&v)
+	}()
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	stop := k.Watch(cancelCtx, id, "SELECT SLEEP(10)")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	err = <-done
+	stop(err)
+	a.Equal(err.(*mysql.MySQLError).Number, uint16(1317))
+	a.Equal(statsValue(stats, mysqlKillQueryStatsKey), 5)
+}
@@ -0,0 +1,302 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/altstory/go-log"
+	"github.com/altstory/go-mysql/internal/driver"
+)
+
+// LoadDataFormat 是 LoadData 读取的数据格式。
+type LoadDataFormat int
+
+// 所有支持的数据格式。
+const (
+	LoadDataTSV LoadDataFormat = iota // LoadDataTSV 是 MySQL 默认的格式，字段用 \t 分隔，特殊字符用 \ 转义，\N 代表 NULL。
+	LoadDataCSV                       // LoadDataCSV 是 CSV 格式，字段用 , 分隔，可以用 " 括起来，括起来的字段中 "" 代表一个 "，没有括起来的 NULL 代表 NULL。
+)
+
+var (
+	errLocalInfileDisabled = errors.New("go-mysql: LOAD DATA LOCAL INFILE is disabled by config")
+	errLoadDataMigration   = errors.New("go-mysql: LOAD DATA LOCAL INFILE is not supported during migration")
+)
+
+// loadDataSeq 用来生成注册 io.Reader 的名字。
+var loadDataSeq uint64
+
+// LoadDataOptions 是 LoadData 的设置。
+type LoadDataOptions struct {
+	Format      LoadDataFormat // Format 是数据格式，默认是 LoadDataTSV。
+	Replace     bool           // Replace 表示遇到重复数据时替换旧数据，默认忽略新数据。
+	IgnoreLines int            // IgnoreLines 是开头需要跳过的行数，比如 CSV 的表头。
+	LineEnding  string         // LineEnding 是每一行的结束符，默认是 \n。
+}
+
+// LoadDataResult 是 LoadData 的结果。
+type LoadDataResult struct {
+	Affected int64     // Affected 是影响的行数，替换的数据会被计算两次。
+	Warnings []Warning // Warnings 是执行过程中产生的警告，比如某一行的字段数与列数不一致。
+}
+
+// Warning 代表 MySQL 的一条警告，即 SHOW WARNINGS 的一行结果。
+type Warning struct {
+	Level   string
+	Code    uint16
+	Message string
+}
+
+// LoadData 使用 LOAD DATA LOCAL INFILE 将 r 中的数据写入 table，这是 MySQL 写入大量数据最快的方法。
+//
+// 数据会在执行过程中从 r 流式读取，不需要全部放到内存里，如果 r 实现了 io.ReadCloser，读取完成后会被关闭。
+// columns 是数据中每个字段对应的列，为空时与表中所有列的顺序一致。
+// table 和 columns 会原样拼接到 SQL 里，不能使用外部输入的数据。
+//
+// 这个功能默认关闭，需要设置 Config.LocalInfile，同时 MySQL 服务器也需要开启 local_infile。
+// 迁移分片时无法双写这些数据，因此会直接返回错误。
+// 导入数据通常需要执行很久，因此不使用 Config.Timeout.Write，只受 ctx 本身的 deadline 限制。
+// 与其他语句一样，导入数据受熔断器保护，开启 Config.KillOnCancel 时 ctx 结束会 KILL 这条语句。
+func (mysql *MySQL) LoadData(table string, columns []string, r io.Reader, opts *LoadDataOptions) (res *LoadDataResult, err error) {
+	if err = mysql.ctx.Err(); err != nil {
+		return
+	}
+
+	if mysql.tx != nil {
+		return mysql.tx.LoadData(table, columns, r, opts)
+	}
+
+	if !mysql.ins.LocalInfile {
+		err = errLocalInfileDisabled
+		return
+	}
+
+	if mysql.mirror != nil {
+		err = errLoadDataMigration
+		return
+	}
+
+	gen, err := mysql.ins.Breaker.Allow(mysql.ctx)
+
+	if err != nil {
+		return
+	}
+
+	// 执行完成后需要在同一个连接上查询警告。
+	start := time.Now()
+	db := mysql.db(true)
+	var conn *sql.Conn
+	var watch func(query string) (stop func(err error))
+
+	if k := mysql.ins.Killers[db]; k != nil && mysql.ctx.Done() != nil {
+		var id int64
+
+		if conn, id, err = pinConn(mysql.ctx, db); err == nil {
+			watch = func(query string) func(err error) {
+				return k.Watch(mysql.ctx, id, query)
+			}
+		}
+	} else {
+		conn, err = db.Conn(mysql.ctx)
+	}
+
+	if err == nil {
+		res, err = loadData(mysql.ctx, mysql.ins, conn, watch, table, columns, r, opts)
+		conn.Close()
+	}
+
+	mysql.ins.Breaker.Done(mysql.ctx, gen, start, err)
+
+	if err != nil {
+		return
+	}
+
+	if tracker := writeTrackerFromContext(mysql.ctx); tracker != nil {
+		tracker.Wrote(mysql.ctx, mysql.ins)
+	}
+
+	return
+}
+
+// LoadData 在事务中使用 LOAD DATA LOCAL INFILE 将 r 中的数据写入 table，详见 MySQL#LoadData。
+func (tx *Tx) LoadData(table string, columns []string, r io.Reader, opts *LoadDataOptions) (res *LoadDataResult, err error) {
+	if err = tx.ctx.Err(); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if !tx.ins.LocalInfile {
+		err = errLocalInfileDisabled
+		return
+	}
+
+	if tx.root().mirror != nil {
+		err = errLoadDataMigration
+		return
+	}
+
+	tx.root().wrote = true
+	return loadData(tx.ctx, tx.ins, tx.tx, func(query string) func(err error) {
+		return tx.watch(tx.ctx, query)
+	}, table, columns, r, opts)
+}
+
+// loadData 在 conn 上执行 LOAD DATA，watch 不为 nil 时用来在 ctx 结束时 KILL 这条语句。
+func loadData(ctx context.Context, ins *dbInstance, conn sqlConn, watch func(query string) (stop func(err error)), table string, columns []string, r io.Reader, opts *LoadDataOptions) (res *LoadDataResult, err error) {
+	if table == "" {
+		err = errors.New("go-mysql: table is required by LoadData")
+		return
+	}
+
+	var o LoadDataOptions
+
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.LineEnding == "" {
+		o.LineEnding = "\n"
+	}
+
+	name := fmt.Sprintf("go-mysql-%v", atomic.AddUint64(&loadDataSeq, 1))
+	driver.RegisterReaderHandler(name, func() io.Reader {
+		return r
+	})
+	defer driver.DeregisterReaderHandler(name)
+
+	query, err := buildLoadDataQuery(name, table, columns, &o)
+
+	if err != nil {
+		return
+	}
+
+	stop := func(error) {}
+
+	if watch != nil {
+		stop = watch(query)
+	}
+
+	start := time.Now()
+	result, err := conn.ExecContext(ctx, query)
+	stop(err)
+	statsForWrite(ctx, ins, query, start, err)
+
+	if err != nil {
+		log.Errorf(ctx, "err=%v||table=%v||go-mysql: fail to load data", err, table)
+		return
+	}
+
+	affected, _ := result.RowsAffected()
+
+	if affected > 0 {
+		statsForAffectedRows(ctx, affected)
+	}
+
+	res = &LoadDataResult{
+		Affected: affected,
+	}
+
+	// 数据已经写入，查询警告失败不影响结果。
+	if res.Warnings, err = showWarnings(ctx, conn); err != nil {
+		log.Warnf(ctx, "err=%v||table=%v||go-mysql: fail to show warnings after loading data", err, table)
+		err = nil
+	}
+
+	return
+}
+
+func buildLoadDataQuery(name, table string, columns []string, o *LoadDataOptions) (query string, err error) {
+	buf := &strings.Builder{}
+	buf.WriteString("LOAD DATA LOCAL INFILE ")
+	buf.WriteString(quoteString("Reader::" + name))
+
+	if o.Replace {
+		buf.WriteString(" REPLACE")
+	} else {
+		buf.WriteString(" IGNORE")
+	}
+
+	buf.WriteString(" INTO TABLE ")
+	buf.WriteString(table)
+
+	switch o.Format {
+	case LoadDataTSV:
+		buf.WriteString(` FIELDS TERMINATED BY '\t' ESCAPED BY '\\'`)
+	case LoadDataCSV:
+		buf.WriteString(` FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' ESCAPED BY ''`)
+	default:
+		err = fmt.Errorf("go-mysql: invalid load data format %v", o.Format)
+		return
+	}
+
+	buf.WriteString(" LINES TERMINATED BY ")
+	buf.WriteString(quoteString(o.LineEnding))
+
+	if o.IgnoreLines > 0 {
+		fmt.Fprintf(buf, " IGNORE %v LINES", o.IgnoreLines)
+	}
+
+	if len(columns) > 0 {
+		buf.WriteString(" (")
+		buf.WriteString(strings.Join(columns, ", "))
+		buf.WriteString(")")
+	}
+
+	query = buf.String()
+	return
+}
+
+func showWarnings(ctx context.Context, conn sqlConn) (warnings []Warning, err error) {
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS")
+
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var w Warning
+
+		if err = rows.Scan(&w.Level, &w.Code, &w.Message); err != nil {
+			return
+		}
+
+		warnings = append(warnings, w)
+	}
+
+	err = rows.Err()
+	return
+}
+
+// quoteString 将 s 转换成 MySQL 的字符串字面量。
+func quoteString(s string) string {
+	buf := &strings.Builder{}
+	buf.WriteByte('\'')
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			buf.WriteString(`\0`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\\', '\'':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+
+	buf.WriteByte('\'')
+	return buf.String()
+}
@@ -0,0 +1,132 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/altstory/go-runner"
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+func TestLoadData(t *testing.T) {
+	a := assert.New(t)
+	f := memFactory(t)
+	ctx := context.Background()
+	initTable(ctx, t, f, "load_data", "id BIGINT PRIMARY KEY", "name VARCHAR(16)", "score INT NOT NULL DEFAULT 0")
+
+	// 默认关闭。
+	_, err := f.New(ctx).LoadData("load_data", nil, strings.NewReader("1\ta\t10\n"), nil)
+	a.Equal(err, errLocalInfileDisabled)
+	f.Close()
+
+	f = NewFactory(&Config{
+		DSN:         memdb.Scheme + testDB,
+		LocalInfile: true,
+	})
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+	m := f.New(ctx)
+
+	res, err := m.LoadData("load_data", nil, strings.NewReader("1\ta\t10\n2\t\\N\t20\n3\tc\n"), nil)
+	a.NilError(err)
+	a.Equal(res.Affected, int64(3))
+	a.Equal(len(res.Warnings), 1)
+	a.Equal(res.Warnings[0].Code, uint16(1261))
+
+	var name *string
+	a.NilError(m.QueryScalar(&name, "SELECT name FROM load_data WHERE id = 2"))
+	a.Assert(name == nil)
+
+	// CSV 格式，跳过表头，重复数据会被替换。
+	csv := "name,id\r\n\"x, \"\"y\"\"\",1\r\nNULL,4\r\n"
+	res, err = m.LoadData("load_data", []string{"name", "id"}, strings.NewReader(csv), &LoadDataOptions{
+		Format:      LoadDataCSV,
+		Replace:     true,
+		IgnoreLines: 1,
+		LineEnding:  "\r\n",
+	})
+	a.NilError(err)
+	a.Equal(res.Affected, int64(3))
+	a.Equal(len(res.Warnings), 0)
+	a.NilError(m.QueryScalar(&name, "SELECT name FROM load_data WHERE id = 1"))
+	a.Equal(*name, `x, "y"`)
+
+	// 在事务中执行，出错时回滚。
+	err = m.Transaction(nil, func(tx *Tx) error {
+		res, err := tx.LoadData("load_data", nil, strings.NewReader("5\te\t50\n"), nil)
+		a.NilError(err)
+		a.Equal(res.Affected, int64(1))
+		return errors.New("rollback")
+	})
+	a.NonNilError(err)
+	var count int64
+	a.NilError(m.QueryScalar(&count, "SELECT COUNT(*) FROM load_data"))
+	a.Equal(count, int64(4))
+
+	_, err = m.LoadData("load_data", nil, strings.NewReader(""), &LoadDataOptions{Format: -1})
+	a.NonNilError(err)
+}
+
+// blockingReader 在 ctx 结束之后才返回数据，用来模拟执行很久的导入。
+type blockingReader struct {
+	ctx  context.Context
+	data io.Reader
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.ctx.Done()
+
+	// 等 KILL QUERY 先到达服务端。
+	time.Sleep(50 * time.Millisecond)
+	return r.data.Read(p)
+}
+
+func TestLoadDataBreakerAndKill(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN:          memdb.Scheme + testDB,
+		LocalInfile:  true,
+		KillOnCancel: true,
+		Breaker: ConfigBreaker{
+			ErrorRate:   0.5,
+			MinRequests: 2,
+			OpenTimeout: time.Minute,
+		},
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+	stats := &runner.Stats{}
+	ctx := runner.WithStats(context.Background(), stats)
+	m := initTable(ctx, t, f, "load_data_kill", "id BIGINT PRIMARY KEY")
+
+	// ctx 结束时 KILL 正在执行的导入。
+	cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err := f.New(cancelCtx).LoadData("load_data_kill", nil, &blockingReader{
+		ctx:  cancelCtx,
+		data: strings.NewReader("1\n2\n"),
+	}, nil)
+	a.Equal(err, context.DeadlineExceeded)
+	a.Equal(statsValue(stats, mysqlKillQueryStatsKey), 1)
+	a.Equal(m.Stats().InUse, 0)
+
+	// 连接断开会触发熔断，熔断之后导入直接返回错误。
+	memdb.Inject(testDB, 2, mysql.ErrInvalidConn)
+
+	for i := 0; i < 2; i++ {
+		_, err = m.LoadData("load_data_kill", nil, strings.NewReader("3\n"), nil)
+		a.Equal(err, mysql.ErrInvalidConn)
+	}
+
+	a.Equal(m.BreakerState(), BreakerOpen)
+	_, err = m.LoadData("load_data_kill", nil, strings.NewReader("3\n"), nil)
+	_, ok := err.(*BreakerOpenError)
+	a.Assert(ok)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/altstory/go-mysql/internal/memdb"
 	"github.com/huandu/go-sqlbuilder"
 )
 
@@ -56,10 +57,51 @@ func mysqlClusterFactory(t *testing.T) *Factory {
 	return f
 }
 
+// memFactory 返回一个使用内存数据库的 MySQL Factory，不依赖任何外部服务。
+// 每次调用都会清空内存数据库 xxxxxxx_test。
+func memFactory(t *testing.T) *Factory {
+	memdb.Drop(testDB)
+	c := &Config{
+		DSN: memdb.Scheme + testDB,
+	}
+	f := NewFactory(c)
+	ctx := context.Background()
+
+	if err := f.Conn(ctx); err != nil {
+		t.Fatalf("fail to connect memdb. [err:%v] [dsn:%v]", err, c.DSN)
+		return nil
+	}
+
+	return f
+}
+
+// memClusterFactory 返回一个使用内存数据库的 MySQL Factory，这里使用了 cluster 模式。
+func memClusterFactory(t *testing.T) *Factory {
+	memdb.Drop(testDB)
+	c := &Config{
+		Mod: 4,
+		Instances: []ConfigInstance{
+			{
+				DSN:     memdb.Scheme + testDB,
+				Buckets: []int64{0, 1, 2, 3},
+			},
+		},
+	}
+	f := NewFactory(c)
+	ctx := context.Background()
+
+	if err := f.Conn(ctx); err != nil {
+		t.Fatalf("fail to connect memdb. [err:%v] [dsn:%v]", err, c.Instances[0].DSN)
+		return nil
+	}
+
+	return f
+}
+
 func initTable(ctx context.Context, t *testing.T, f *Factory, table string, defs ...string) *MySQL {
 	mysql := f.New(ctx)
 
-	if _, err := mysql.Exec("DROP TABLE " + table); err != nil {
+	if _, err := mysql.Exec("DROP TABLE IF EXISTS " + table); err != nil {
 		t.Fatalf("unable to drop table. [err:%v] [table:%v]", err, table)
 	}
 
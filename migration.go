@@ -0,0 +1,421 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+// MigrationBucket 代表一个 bucket 的迁移进度。
+type MigrationBucket struct {
+	Bucket      int64 // Bucket 是 bucket 号，即 idx%buckets。
+	CutOver     bool  // CutOver 表示这个 bucket 的读请求是否已经切换到新分片。
+	Writes      int64 // Writes 是这个 bucket 双写的次数。
+	Failures    int64 // Failures 是写入另一套分片失败的次数，不为 0 时需要重新同步这个 bucket 的数据后再切换。
+	Divergences int64 // Divergences 是两套分片写入结果不一致的次数，即影响行数不同，不为 0 时同样需要重新同步数据。
+}
+
+// errNonDeterministic 是迁移时执行包含不确定函数的写请求返回的错误。
+var errNonDeterministic = errors.New("go-mysql: statement with non-deterministic functions like NOW() or RAND() cannot be mirrored in migration, pass the value as an argument instead")
+
+// reNonDeterministic 匹配在两套分片上执行结果不同的函数。
+var reNonDeterministic = regexp.MustCompile(`(?i)\b(NOW|SYSDATE|CURDATE|CURTIME|UTC_DATE|UTC_TIME|UTC_TIMESTAMP|RAND|UUID|UUID_SHORT|CONNECTION_ID|LAST_INSERT_ID)\s*\(|\bUNIX_TIMESTAMP\s*\(\s*\)|\b(CURRENT_TIMESTAMP|CURRENT_DATE|CURRENT_TIME|LOCALTIME|LOCALTIMESTAMP)\b`)
+
+// nonDeterministic 判断 query 是否包含不确定函数，字符串字面量中的内容不算。
+func nonDeterministic(query string) bool {
+	return reNonDeterministic.MatchString(normalizeQuery(query))
+}
+
+// migration 是双写迁移的状态，新旧两套分片同时存在。
+// 写请求会同时写入新旧分片，读请求在 bucket 切换前走旧分片，切换后走新分片。
+type migration struct {
+	Instances []*dbInstance // Instances 与 ConfigMigration.Instances 一一对应。
+	Sharder   sharder
+	Buckets   []*migrationBucket
+
+	owned []*dbInstance // owned 是迁移时新打开的实例，与旧分片共用的实例不在这里。
+}
+
+type migrationBucket struct {
+	ID int64
+
+	cutover     int32
+	writes      int64
+	failures    int64
+	divergences int64
+}
+
+func (b *migrationBucket) CutOver() bool {
+	return atomic.LoadInt32(&b.cutover) != 0
+}
+
+func (b *migrationBucket) setCutOver(cutover bool) {
+	var v int32
+
+	if cutover {
+		v = 1
+	}
+
+	atomic.StoreInt32(&b.cutover, v)
+}
+
+func (b *migrationBucket) wrote() {
+	atomic.AddInt64(&b.writes, 1)
+}
+
+func (b *migrationBucket) fail(ctx context.Context, err error, query string) {
+	atomic.AddInt64(&b.failures, 1)
+	log.Errorf(ctx, "err=%v||bucket=%v||query=%v||go-mysql: fail to write to the other layout in migration", err, b.ID, query)
+}
+
+// compare 比较两套分片的写入结果，影响行数不同说明两边的数据已经不一致了。
+// 自增 id 已经通过 insertIDQuery 与业务分片保持一致，不需要比较。
+func (b *migrationBucket) compare(ctx context.Context, res, mirrored sql.Result, query string) {
+	affected, _ := res.RowsAffected()
+	mirroredAffected, _ := mirrored.RowsAffected()
+
+	if affected == mirroredAffected {
+		return
+	}
+
+	atomic.AddInt64(&b.divergences, 1)
+	log.Errorf(ctx, "bucket=%v||query=%v||affected=%v||mirrored_affected=%v||go-mysql: data diverges between layouts in migration",
+		b.ID, query, affected, mirroredAffected)
+}
+
+// insertIDQuery 返回让另一套分片使用业务分片自增 id 的语句，res 没有生成自增 id 时返回空字符串。
+// 两套分片的自增计数器是独立的，直接双写 INSERT 会生成不同的 id，
+// 因此与 MySQL 基于语句的复制一样，先用 SET insert_id 指定下一条语句的第一个自增值再执行写请求。
+func insertIDQuery(res sql.Result) string {
+	id, _ := res.LastInsertId()
+
+	if id == 0 {
+		return ""
+	}
+
+	return "SET insert_id = " + strconv.FormatInt(id, 10)
+}
+
+// openMigration 打开新分片的所有实例，DSN 与旧分片相同的实例会直接复用旧分片的连接。
+func (f *Factory) openMigration(ctx context.Context, conn *dbConn) (m *migration, err error) {
+	config := f.migration
+	sharder, err := newSharder(config.Sharding, config.Mod, config.VirtualNodes, config.Instances)
+
+	if err != nil {
+		return
+	}
+
+	buckets := config.Buckets
+
+	if buckets <= 0 {
+		buckets = config.Mod
+	}
+
+	if buckets <= 0 {
+		buckets = f.mod
+	}
+
+	if buckets <= 0 {
+		buckets = 1
+	}
+
+	m = &migration{
+		Sharder: sharder,
+		Buckets: make([]*migrationBucket, buckets),
+	}
+
+	for i := range m.Buckets {
+		m.Buckets[i] = &migrationBucket{
+			ID: int64(i),
+		}
+	}
+
+	for _, b := range config.Cutover {
+		if b < 0 || b >= buckets {
+			return nil, fmt.Errorf("go-mysql: invalid cutover bucket %v which is out of range [0, %v)", b, buckets)
+		}
+
+		m.Buckets[b].setCutOver(true)
+	}
+
+	existing := map[string]*dbInstance{}
+
+	if conn.Master != nil {
+		existing[f.dsn] = &conn.dbInstance
+	}
+
+	for i, ins := range f.instances {
+		existing[ins.DSN] = conn.Instances[i]
+	}
+
+	for i, ins := range config.Instances {
+		if db, ok := existing[ins.DSN]; ok {
+			m.Instances = append(m.Instances, db)
+			continue
+		}
+
+		db := &dbInstance{
+			Label: "migration_" + strconv.Itoa(i),
+		}
+		err = db.openDBConn(ctx, f, ins.DSN, ins.DSNSlave, ins.Slaves)
+
+		if err != nil {
+			db.Close()
+			m.Close()
+			return nil, err
+		}
+
+		m.Instances = append(m.Instances, db)
+		m.owned = append(m.owned, db)
+	}
+
+	return
+}
+
+// New 根据 idx 创建迁移中的 MySQL 实例，old 是 idx 在旧分片中对应的实例。
+func (m *migration) New(ctx context.Context, idx int64, old *dbInstance) *MySQL {
+	n := int64(len(m.Buckets))
+	bucket := m.Buckets[(idx%n+n)%n]
+	i := m.Sharder.Shard(idx)
+
+	if i < 0 || i >= len(m.Instances) {
+		log.Errorf(ctx, "idx=%v||go-mysql: no instance is found for the index in migration layout", idx)
+		panic(fmt.Errorf("go-mysql: no instance is found for index %v in migration layout", idx))
+	}
+
+	mysql := newMySQL(ctx, old)
+	target := m.Instances[i]
+
+	if target == old {
+		return mysql
+	}
+
+	if bucket.CutOver() {
+		mysql.ins, target = target, old
+	}
+
+	mysql.mirror = &mirror{
+		ins:    target,
+		bucket: bucket,
+	}
+	return mysql
+}
+
+// Progress 返回每个 bucket 的迁移进度。
+func (m *migration) Progress() []MigrationBucket {
+	progress := make([]MigrationBucket, 0, len(m.Buckets))
+
+	for _, b := range m.Buckets {
+		progress = append(progress, MigrationBucket{
+			Bucket:      b.ID,
+			CutOver:     b.CutOver(),
+			Writes:      atomic.LoadInt64(&b.writes),
+			Failures:    atomic.LoadInt64(&b.failures),
+			Divergences: atomic.LoadInt64(&b.divergences),
+		})
+	}
+
+	return progress
+}
+
+func (m *migration) Close() error {
+	for _, ins := range m.owned {
+		if err := ins.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mirror 代表迁移时需要同时写入的另一套分片。
+type mirror struct {
+	ins    *dbInstance
+	bucket *migrationBucket
+}
+
+// Exec 在另一套分片上执行写请求，res 是业务分片上的执行结果，失败只记录日志和进度，不影响业务。
+// 与业务分片一样受写超时、熔断和慢查询日志的控制，另一套分片卡住时不会无限阻塞业务的写请求。
+func (m *mirror) Exec(ctx context.Context, res sql.Result, query string, args ...interface{}) {
+	m.bucket.wrote()
+	gen, err := m.ins.Breaker.Allow(ctx)
+
+	if err != nil {
+		m.bucket.fail(ctx, err, query)
+		return
+	}
+
+	execCtx, cancel := withQueryTimeout(ctx, m.ins.Timeout.Write)
+	defer cancel()
+
+	start := time.Now()
+	mirrored, err := m.exec(execCtx, insertIDQuery(res), query, args)
+	m.ins.SlowLog.Record(ctx, query, args, start)
+	m.ins.Breaker.Done(ctx, gen, start, err)
+
+	if err != nil {
+		m.bucket.fail(ctx, err, query)
+		return
+	}
+
+	m.bucket.compare(ctx, res, mirrored, query)
+}
+
+// exec 执行写请求，setID 不为空时需要在同一个连接上先执行 setID。
+func (m *mirror) exec(ctx context.Context, setID, query string, args []interface{}) (sql.Result, error) {
+	if setID == "" {
+		return m.ins.Master.ExecContext(ctx, query, args...)
+	}
+
+	conn, err := m.ins.Master.Conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, setID); err != nil {
+		return nil, err
+	}
+
+	return conn.ExecContext(ctx, query, args...)
+}
+
+// BeginTx 在另一套分片上开启一个事务，失败时返回 nil。
+func (m *mirror) BeginTx(ctx context.Context, opts *sql.TxOptions) *mirrorTx {
+	gen, err := m.ins.Breaker.Allow(ctx)
+
+	if err != nil {
+		m.bucket.fail(ctx, err, "BEGIN")
+		return nil
+	}
+
+	start := time.Now()
+	tx, err := m.ins.Master.BeginTx(ctx, opts)
+	m.ins.Breaker.Done(ctx, gen, start, err)
+
+	if err != nil {
+		m.bucket.fail(ctx, err, "BEGIN")
+		return nil
+	}
+
+	return &mirrorTx{
+		tx:     tx,
+		ins:    m.ins,
+		bucket: m.bucket,
+	}
+}
+
+// mirrorTx 是另一套分片上与业务事务同时进行的事务。
+type mirrorTx struct {
+	tx     *sql.Tx
+	ins    *dbInstance
+	bucket *migrationBucket
+}
+
+// Exec 在事务中执行写请求，res 是业务事务中的执行结果，失败时回滚事务并返回 false，之后这个事务不再双写。
+func (m *mirrorTx) Exec(ctx context.Context, res sql.Result, query string, args ...interface{}) bool {
+	m.bucket.wrote()
+	execCtx, cancel := withQueryTimeout(ctx, m.ins.Timeout.Write)
+	defer cancel()
+
+	start := time.Now()
+	var mirrored sql.Result
+	var err error
+
+	if setID := insertIDQuery(res); setID != "" {
+		_, err = m.tx.ExecContext(execCtx, setID)
+	}
+
+	if err == nil {
+		mirrored, err = m.tx.ExecContext(execCtx, query, args...)
+	}
+
+	m.ins.SlowLog.Record(ctx, query, args, start)
+
+	if err != nil {
+		m.bucket.fail(ctx, err, query)
+		m.tx.Rollback()
+		return false
+	}
+
+	m.bucket.compare(ctx, res, mirrored, query)
+	return true
+}
+
+func (m *mirrorTx) Commit(ctx context.Context) {
+	if err := m.tx.Commit(); err != nil {
+		m.bucket.fail(ctx, err, "COMMIT")
+	}
+}
+
+func (m *mirrorTx) Rollback() {
+	m.tx.Rollback()
+}
+
+// Cutover 将 buckets 的读请求切换到新分片，写请求依然会双写，方便出问题时切回。
+func (f *Factory) Cutover(buckets ...int64) error {
+	return f.setCutover(buckets, true)
+}
+
+// RevertCutover 将 buckets 的读请求切回旧分片。
+func (f *Factory) RevertCutover(buckets ...int64) error {
+	return f.setCutover(buckets, false)
+}
+
+func (f *Factory) setCutover(buckets []int64, cutover bool) error {
+	m, err := f.currentMigration()
+
+	if err != nil {
+		return err
+	}
+
+	for _, b := range buckets {
+		if b < 0 || b >= int64(len(m.Buckets)) {
+			return fmt.Errorf("go-mysql: invalid cutover bucket %v which is out of range [0, %v)", b, len(m.Buckets))
+		}
+	}
+
+	for _, b := range buckets {
+		m.Buckets[b].setCutOver(cutover)
+	}
+
+	return nil
+}
+
+// MigrationProgress 返回每个 bucket 的迁移进度，如果没有在迁移则返回 nil。
+func (f *Factory) MigrationProgress() []MigrationBucket {
+	m, err := f.currentMigration()
+
+	if err != nil {
+		return nil
+	}
+
+	return m.Progress()
+}
+
+func (f *Factory) currentMigration() (*migration, error) {
+	if f.unavailable {
+		return nil, errors.New("go-mysql: factory is not initialized")
+	}
+
+	conn := f.conn()
+
+	if conn == nil {
+		return nil, errors.New("go-mysql: factory is not connected")
+	}
+
+	if conn.Migration == nil {
+		return nil, errors.New("go-mysql: factory is not in migration mode")
+	}
+
+	return conn.Migration, nil
+}
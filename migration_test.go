@@ -0,0 +1,140 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/huandu/go-assert"
+)
+
+func TestMigrationDualWrite(t *testing.T) {
+	a := assert.New(t)
+	oldDB, newDB := testDB+"_old", testDB+"_new"
+	memdb.Drop(oldDB)
+	memdb.Drop(newDB)
+
+	f := NewFactory(&Config{
+		Mod: 2,
+		Instances: []ConfigInstance{
+			{DSN: memdb.Scheme + oldDB, Buckets: []int64{0, 1}},
+		},
+		Migration: ConfigMigration{
+			Mod: 2,
+			Instances: []ConfigInstance{
+				{DSN: memdb.Scheme + oldDB, Buckets: []int64{0}},
+				{DSN: memdb.Scheme + newDB, Buckets: []int64{1}},
+			},
+		},
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	conn := f.conn()
+	oldIns, newIns := conn.Instances[0], conn.Migration.Instances[1]
+	a.Equal(conn.Migration.Instances[0], oldIns)
+
+	for _, ins := range []*dbInstance{oldIns, newIns} {
+		a.NilError(ins.Master.Exec("CREATE TABLE t (id INT PRIMARY KEY, v INT)"))
+	}
+
+	count := func(db *sql.DB, id int64) (cnt int) {
+		a.NilError(db.QueryRow("SELECT COUNT(*) FROM t WHERE id = ?", id).Scan(&cnt))
+		return
+	}
+
+	// bucket 0 在新旧分片中都是同一个实例，不需要双写。
+	m := f.New(WithIndex(ctx, 0))
+	a.Equal(m.ins, oldIns)
+	a.Assert(m.mirror == nil)
+
+	// bucket 1 切换前读旧分片，写请求双写。
+	m = f.New(WithIndex(ctx, 1))
+	a.Equal(m.ins, oldIns)
+	a.NilError(m.Exec("INSERT INTO t VALUES (1, 1)"))
+	a.Equal(count(oldIns.Master, 1), 1)
+	a.Equal(count(newIns.Master, 1), 1)
+
+	tx, err := m.BeginTx(nil)
+	a.NilError(err)
+	a.NilError(tx.Exec("INSERT INTO t VALUES (3, 3)"))
+	a.Equal(count(newIns.Master, 3), 0)
+	a.NilError(tx.Commit())
+	a.Equal(count(oldIns.Master, 3), 1)
+	a.Equal(count(newIns.Master, 3), 1)
+
+	// 两套分片的自增计数器不同，双写的 INSERT 依然使用业务分片生成的自增 id。
+	for _, ins := range []*dbInstance{oldIns, newIns} {
+		a.NilError(ins.Master.Exec("CREATE TABLE u (id INT PRIMARY KEY AUTO_INCREMENT, v INT)"))
+	}
+
+	a.NilError(newIns.Master.Exec("INSERT INTO u VALUES (10, 0)"))
+	res, err := m.Exec("INSERT INTO u (v) VALUES (1), (2)")
+	a.NilError(err)
+	id, _ := res.LastInsertId()
+	a.Equal(id, int64(1))
+	a.NilError(m.Transaction(nil, func(tx *Tx) error {
+		res, err := tx.Exec("INSERT INTO u (v) VALUES (3)")
+		a.NilError(err)
+		id, _ := res.LastInsertId()
+		a.Equal(id, int64(3))
+		return nil
+	}))
+	a.NilError(newIns.Master.Exec("INSERT INTO u (v) VALUES (11)"))
+
+	ids := func(db *sql.DB) (ids []int64) {
+		rows, err := db.Query("SELECT id FROM u ORDER BY id")
+		a.NilError(err)
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			a.NilError(rows.Scan(&id))
+			ids = append(ids, id)
+		}
+
+		return
+	}
+	a.Equal(ids(oldIns.Master), []int64{1, 2, 3})
+	a.Equal(ids(newIns.Master), []int64{1, 2, 3, 10, 11})
+
+	// 切换后读新分片，写请求依然双写。
+	a.NilError(f.Cutover(1))
+	m = f.New(WithIndex(ctx, 5))
+	a.Equal(m.ins, newIns)
+	a.NilError(m.Exec("INSERT INTO t VALUES (5, 5)"))
+	a.Equal(count(oldIns.Master, 5), 1)
+	a.Equal(count(newIns.Master, 5), 1)
+
+	// 包含不确定函数的写请求会被拒绝，字符串里的内容不算。
+	_, err = m.Exec("UPDATE t SET v = UNIX_TIMESTAMP() WHERE id = 5")
+	a.Equal(err, errNonDeterministic)
+	_, err = m.Exec("UPDATE t SET v = RAND () WHERE id = 5")
+	a.Equal(err, errNonDeterministic)
+	a.NilError(m.Exec("UPDATE t SET v = 6 WHERE id = 5 AND 'NOW()' <> ''"))
+	a.NilError(m.Transaction(nil, func(tx *Tx) error {
+		_, err := tx.Exec("INSERT INTO t VALUES (6, CURRENT_TIMESTAMP)")
+		a.Equal(err, errNonDeterministic)
+		return nil
+	}))
+
+	// 两套分片的影响行数不同说明数据已经不一致了。
+	a.NilError(newIns.Master.Exec("DELETE FROM t WHERE id = 5"))
+	a.NilError(m.Exec("UPDATE t SET v = 0 WHERE id = 5"))
+
+	// 另一套分片写入失败不影响业务，但会记录在进度里。
+	a.NilError(newIns.Master.Exec("DROP TABLE t"))
+	a.NilError(f.RevertCutover(1))
+	a.NilError(f.New(WithIndex(ctx, 7)).Exec("INSERT INTO t VALUES (7, 7)"))
+
+	progress := f.MigrationProgress()
+	a.Equal(progress, []MigrationBucket{
+		{Bucket: 0},
+		{Bucket: 1, Writes: 8, Failures: 1, Divergences: 1},
+	})
+
+	a.NonNilError(f.Cutover(2))
+	a.Assert(memFactory(t).MigrationProgress() == nil)
+}
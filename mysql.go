@@ -11,9 +11,11 @@ import (
 // MySQL 代表一个数据库的链接。
 type MySQL struct {
 	ctx       context.Context
-	master    *sql.DB
-	slave     *sql.DB
+	ins       *dbInstance
 	useMaster bool
+	mirror    *mirror // mirror 是迁移时需要双写的另一套分片。
+	tx        *Tx     // tx 是 ctx 中绑定的事务，设置后所有读写请求都在这个事务中执行。
+	prepared  bool    // prepared 表示这是 Stmt 使用的实例，开启了语句缓存时使用服务端预处理语句执行。
 }
 
 // New 通过默认工厂创建一个 MySQL 实例。
@@ -28,47 +30,170 @@ func New(ctx context.Context) *MySQL {
 	return factory.New(ctx)
 }
 
-func newMySQL(ctx context.Context, master, slave *sql.DB) *MySQL {
+func newMySQL(ctx context.Context, ins *dbInstance) *MySQL {
 	return &MySQL{
-		ctx:    ctx,
-		master: master,
-		slave:  slave,
+		ctx: ctx,
+		ins: ins,
 	}
 }
 
-// BeginTx 开始一个事务。
+// BeginTx 开始一个事务，如果 MySQL 实例绑定了事务，则开启一个嵌套事务并忽略 opts。
 func (mysql *MySQL) BeginTx(opts *sql.TxOptions) (tx *Tx, err error) {
 	if err = mysql.ctx.Err(); err != nil {
 		return
 	}
 
-	sqltx, err := mysql.db(true).BeginTx(mysql.ctx, opts)
+	if mysql.tx != nil {
+		return mysql.tx.Begin()
+	}
+
+	gen, err := mysql.ins.Breaker.Allow(mysql.ctx)
 
 	if err != nil {
 		return
 	}
 
-	tx = &Tx{
-		ctx: mysql.ctx,
-		tx:  sqltx,
+	// 事务超时后 database/sql 会自动回滚事务。
+	start := time.Now()
+	ctx, cancel := withTimeout(mysql.ctx, mysql.ins.Timeout.Tx)
+	db := mysql.db(true)
+	k := mysql.ins.Killers[db]
+	var conn *sql.Conn
+	var connID int64
+	var sqltx *sql.Tx
+
+	// 需要 KILL QUERY 时事务必须在一个已知 CONNECTION_ID() 的连接上执行。
+	if k != nil {
+		if conn, connID, err = pinConn(ctx, db); err == nil {
+			if sqltx, err = conn.BeginTx(ctx, opts); err != nil {
+				conn.Close()
+			}
+		}
+	} else {
+		sqltx, err = db.BeginTx(ctx, opts)
+	}
+
+	mysql.ins.Breaker.Done(mysql.ctx, gen, start, err)
+
+	if err != nil {
+		cancel()
+		checkTimeout(mysql.ctx, ctx, "BEGIN", err)
+		return
+	}
+
+	tx = newTx(ctx, mysql.ins, sqltx, nil)
+	tx.outer = mysql.ctx
+	tx.cancel = cancel
+
+	if k != nil {
+		tx.killer = k
+		tx.conn = conn
+		tx.connID = connID
+	}
+
+	if mysql.mirror != nil {
+		tx.mirror = mysql.mirror.BeginTx(mysql.ctx, opts)
 	}
 	return
 }
 
+// Transaction 在事务中执行 fn，fn 返回 nil 时提交事务，返回错误或者 panic 时回滚事务，panic 会在回滚后继续抛出。
+// 如果事务因为死锁失败，会重新开启事务并执行 fn，最多执行 Config.Retry.TxMaxAttempts 次，
+// 因此 fn 必须可以安全的重复执行，不要在 fn 里修改外部状态。
+//
+// 如果 MySQL 实例绑定了同一个实例上的外层事务（WithTx 或者 Tx#Context），Transaction 会通过 Tx#Transaction 开启嵌套事务，
+// 这时 opts 会被忽略，并且不会重试，死锁只会由最外层的 Transaction 重试。
+func (mysql *MySQL) Transaction(opts *sql.TxOptions, fn func(tx *Tx) error) (err error) {
+	if mysql.tx != nil {
+		return mysql.tx.Transaction(fn)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = mysql.transaction(opts, fn)
+
+		if !mysql.ins.TxRetry.ShouldRetry(true, attempt, err) {
+			return
+		}
+
+		statsForRetry(mysql.ctx, "TRANSACTION", attempt, err)
+
+		if !mysql.ins.TxRetry.Wait(mysql.ctx, attempt) {
+			return
+		}
+	}
+}
+
+func (mysql *MySQL) transaction(opts *sql.TxOptions, fn func(tx *Tx) error) (err error) {
+	tx, err := mysql.BeginTx(opts)
+
+	if err != nil {
+		return
+	}
+
+	done := false
+	defer func() {
+		// fn 返回错误或者 panic 时都需要回滚。
+		if !done {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return
+	}
+
+	done = true
+	return tx.Commit()
+}
+
 // Exec 执行一条修改语句并返回结果。
 func (mysql *MySQL) Exec(query string, args ...interface{}) (result Result, err error) {
 	if err = mysql.ctx.Err(); err != nil {
 		return
 	}
 
+	if mysql.tx != nil {
+		return mysql.tx.Exec(query, args...)
+	}
+
+	// 双写时不确定函数在两套分片上的结果不同，直接拒绝，避免数据悄悄地不一致。
+	if mysql.mirror != nil && nonDeterministic(query) {
+		err = errNonDeterministic
+		return
+	}
+
+	gen, err := mysql.ins.Breaker.Allow(mysql.ctx)
+
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := withQueryTimeout(mysql.ctx, mysql.ins.Timeout.Write)
+	defer cancel()
+
 	start := time.Now()
-	res, err := mysql.db(true).ExecContext(mysql.ctx, query, args...)
-	statsForWrite(mysql.ctx, query, start)
+	var res sql.Result
+	err = mysql.ins.Retry.Do(ctx, true, query, func() (e error) {
+		res, e = mysql.execContext(ctx, mysql.db(true), query, args)
+		return
+	})
+	statsForWrite(mysql.ctx, mysql.ins, query, start, err)
+	mysql.ins.SlowLog.Record(mysql.ctx, query, args, start)
+	mysql.ins.Breaker.Done(mysql.ctx, gen, start, err)
 
 	if err != nil {
+		checkTimeout(mysql.ctx, ctx, query, err)
 		return
 	}
 
+	if mysql.mirror != nil {
+		mysql.mirror.Exec(mysql.ctx, res, query, args...)
+	}
+
+	if tracker := writeTrackerFromContext(mysql.ctx); tracker != nil {
+		tracker.Wrote(mysql.ctx, mysql.ins)
+	}
+
 	affected, _ := res.RowsAffected()
 
 	if affected > 0 {
@@ -94,8 +219,14 @@ func (mysql *MySQL) Prepare(query string) (stmt *Stmt, err error) {
 		return
 	}
 
+	if mysql.tx != nil {
+		return mysql.tx.Prepare(query)
+	}
+
+	cp := *mysql
+	cp.prepared = true
 	stmt = &Stmt{
-		db:    mysql,
+		db:    &cp,
 		query: query,
 	}
 	return
@@ -107,17 +238,43 @@ func (mysql *MySQL) Query(query string, args ...interface{}) (rows *Rows, err er
 		return
 	}
 
+	if mysql.tx != nil {
+		return mysql.tx.Query(query, args...)
+	}
+
+	gen, err := mysql.ins.Breaker.Allow(mysql.ctx)
+
+	if err != nil {
+		return
+	}
+
+	// 超时的 ctx 要等到 Rows#Close 时才能释放。
+	ctx, cancel := withQueryTimeout(mysql.ctx, mysql.ins.Timeout.Read)
 	start := time.Now()
-	sqlrows, err := mysql.db(false).QueryContext(mysql.ctx, query, args...)
-	statsForRead(mysql.ctx, query, start)
+	var db *sql.DB
+	var sqlrows *sql.Rows
+	var release func(err error)
+	err = mysql.ins.Retry.Do(ctx, false, query, func() (e error) {
+		db = mysql.db(false)
+		sqlrows, release, e = mysql.queryContext(ctx, db, query, args)
+		return
+	})
+	statsForRead(mysql.ctx, mysql.ins, db == mysql.ins.Master, query, start, err)
+	mysql.ins.SlowLog.Record(mysql.ctx, query, args, start)
+	mysql.ins.Breaker.Done(mysql.ctx, gen, start, err)
 
 	if err != nil {
+		cancel()
+		checkTimeout(mysql.ctx, ctx, query, err)
 		return
 	}
 
 	rows = &Rows{
-		ctx:  mysql.ctx,
-		rows: sqlrows,
+		ctx:      mysql.ctx,
+		rows:     sqlrows,
+		unmapped: mysql.ins.Unmapped,
+		cancel:   cancel,
+		release:  release,
 	}
 	return
 }
@@ -129,16 +286,110 @@ func (mysql *MySQL) QueryRow(query string, args ...interface{}) (row *Row, err e
 		return
 	}
 
+	if mysql.tx != nil {
+		return mysql.tx.QueryRow(query, args...)
+	}
+
+	// 熔断时与查询出错一样，错误要等到 Scan 时才返回。
+	gen, e := mysql.ins.Breaker.Allow(mysql.ctx)
+
+	if e != nil {
+		row = &Row{
+			ctx: mysql.ctx,
+			err: e,
+		}
+		return
+	}
+
+	// 超时的 ctx 要等到 Row#Scan 时才能释放。
+	ctx, cancel := withQueryTimeout(mysql.ctx, mysql.ins.Timeout.Read)
 	start := time.Now()
-	sqlrow := mysql.db(false).QueryRowContext(mysql.ctx, query, args...)
-	statsForRead(mysql.ctx, query, start)
+	db := mysql.db(false)
+	sqlrows, release, e := mysql.queryContext(ctx, db, query, args)
+	statsForRead(mysql.ctx, mysql.ins, db == mysql.ins.Master, query, start, e)
+	mysql.ins.SlowLog.Record(mysql.ctx, query, args, start)
+	mysql.ins.Breaker.Done(mysql.ctx, gen, start, e)
+	checkTimeout(mysql.ctx, ctx, query, e)
 	row = &Row{
-		ctx: mysql.ctx,
-		row: sqlrow,
+		ctx:      mysql.ctx,
+		rows:     sqlrows,
+		err:      e,
+		unmapped: mysql.ins.Unmapped,
+		cancel:   cancel,
+		release:  release,
+	}
+
+	// QueryRow 的错误要等到 Scan 时才返回，所以重试也放在 Row#Scan 里。
+	if mysql.ins.Retry != nil {
+		row.retry = mysql.ins.Retry
+		row.query = query
+		row.requery = func() (*sql.Rows, func(err error), error) {
+			return mysql.queryContext(ctx, mysql.db(false), query, args)
+		}
+	}
+	return
+}
+
+// execContext 在 db 上执行 query，Stmt 在开启了语句缓存时会使用缓存的预处理语句。
+// 开启了 Config.KillOnCancel 时，不使用预处理语句的 query 会在 ctx 结束时被 KILL。
+func (mysql *MySQL) execContext(ctx context.Context, db *sql.DB, query string, args []interface{}) (res sql.Result, err error) {
+	if cache := mysql.stmtCache(db); cache != nil {
+		if stmt, release := cache.Get(ctx, query); stmt != nil {
+			res, err = stmt.ExecContext(ctx, args...)
+			release()
+
+			if err != nil {
+				cache.Invalidate(query, err)
+			}
+
+			return
+		}
+	}
+
+	if k := mysql.ins.Killers[db]; k != nil && ctx.Done() != nil {
+		return k.Exec(ctx, db, query, args)
+	}
+
+	return db.ExecContext(ctx, query, args...)
+}
+
+// queryContext 在 db 上执行 query，规则与 execContext 一致。
+// 查询成功并且 release 不为 nil 时，调用者必须在关闭 rows 之后调用 release。
+func (mysql *MySQL) queryContext(ctx context.Context, db *sql.DB, query string, args []interface{}) (rows *sql.Rows, release func(err error), err error) {
+	if cache := mysql.stmtCache(db); cache != nil {
+		if stmt, put := cache.Get(ctx, query); stmt != nil {
+			rows, err = stmt.QueryContext(ctx, args...)
+			put()
+
+			if err != nil {
+				cache.Invalidate(query, err)
+			}
+
+			return
+		}
 	}
+
+	if k := mysql.ins.Killers[db]; k != nil && ctx.Done() != nil {
+		return k.Query(ctx, db, query, args)
+	}
+
+	rows, err = db.QueryContext(ctx, query, args...)
 	return
 }
 
+func (mysql *MySQL) stmtCache(db *sql.DB) *stmtCache {
+	if !mysql.prepared {
+		return nil
+	}
+
+	return mysql.ins.Stmts[db]
+}
+
+// BreakerState 返回当前实例熔断器的状态，没有开启熔断时总是 BreakerClosed。
+func (mysql *MySQL) BreakerState() BreakerState {
+	return mysql.ins.Breaker.State()
+}
+
 // Stats 返回数据库当前状态。
 func (mysql *MySQL) Stats() sql.DBStats {
 	return mysql.db(false).Stats()
@@ -153,8 +404,19 @@ func (mysql *MySQL) UseMaster() *MySQL {
 
 func (mysql *MySQL) db(forceMaster bool) *sql.DB {
 	if mysql.useMaster || forceMaster {
-		return mysql.master
+		return mysql.ins.Master
+	}
+
+	s := mysql.ins.Slaves.pick()
+
+	if s == nil {
+		return mysql.ins.Master
+	}
+
+	// 开启了读己之写模式时，从库还没有同步到本次请求写入的数据则走主库。
+	if tracker := writeTrackerFromContext(mysql.ctx); tracker != nil && !tracker.Readable(mysql.ctx, mysql.ins, s) {
+		return mysql.ins.Master
 	}
 
-	return mysql.slave
+	return s.DB
 }
@@ -2,9 +2,13 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/altstory/go-mysql/internal/memdb"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/huandu/go-assert"
 	"github.com/huandu/go-sqlbuilder"
 )
@@ -62,6 +66,260 @@ func TestMySQLSimpleCommand(t *testing.T) {
 		a.Assert(simple.CreatedAt.Equal(tm))
 	}
 
+	runFunc(memFactory(t))
+	runFunc(memClusterFactory(t))
 	runFunc(mysqlFactory(t))
 	runFunc(mysqlClusterFactory(t))
 }
+
+func TestMySQLTxAndStmt(t *testing.T) {
+	a := assert.New(t)
+	const table = "test_tx_and_stmt"
+	ctx := context.Background()
+	f := memFactory(t)
+	mysql := initTable(ctx, t, f, table,
+		"id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY",
+		"name VARCHAR(255) NOT NULL",
+	)
+
+	stmt, err := mysql.Prepare("INSERT INTO " + table + " (name) VALUES (?)")
+	a.NilError(err)
+	defer stmt.Close()
+
+	tx, err := mysql.BeginTx(nil)
+	a.NilError(err)
+	txStmt, err := tx.Stmt(stmt)
+	a.NilError(err)
+	a.NilError(txStmt.Exec("foo"))
+	a.NilError(tx.Rollback())
+
+	tx, err = mysql.BeginTx(nil)
+	a.NilError(err)
+	a.NilError(tx.Exec("INSERT INTO "+table+" (name) VALUES (?), (?)", "bar", "baz"))
+	a.NilError(tx.Commit())
+
+	rows, err := mysql.Query("SELECT id, name FROM " + table + " ORDER BY id")
+	a.NilError(err)
+	defer rows.Close()
+	names := []string{}
+
+	for rows.Next() {
+		var id int64
+		var name string
+		a.NilError(rows.Scan(&id, &name))
+		names = append(names, name)
+	}
+
+	a.NilError(rows.Err())
+	a.Equal(names, []string{"bar", "baz"})
+}
+
+func TestMySQLTransaction(t *testing.T) {
+	a := assert.New(t)
+	const table = "test_transaction"
+	ctx := context.Background()
+	f := memFactory(t)
+	mysql := initTable(ctx, t, f, table,
+		"id bigint(20) NOT NULL PRIMARY KEY",
+	)
+	count := func() (cnt int) {
+		row, err := mysql.QueryRow("SELECT COUNT(*) FROM " + table)
+		a.NilError(err)
+		a.NilError(row.Scan(&cnt))
+		return
+	}
+	insert := func(tx *Tx) error {
+		row, err := tx.QueryRow("SELECT COUNT(*) FROM " + table)
+
+		if err != nil {
+			return err
+		}
+
+		var cnt int
+
+		if err = row.Scan(&cnt); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO "+table+" VALUES (?)", cnt+1)
+		return err
+	}
+
+	a.NilError(mysql.Transaction(nil, insert))
+	a.Equal(count(), 1)
+
+	// 返回错误或者 panic 时回滚事务。
+	errFoo := errors.New("foo")
+	err := mysql.Transaction(nil, func(tx *Tx) error {
+		a.NilError(insert(tx))
+		return errFoo
+	})
+	a.Equal(err, errFoo)
+	a.Equal(count(), 1)
+
+	func() {
+		defer func() {
+			a.Equal(recover(), errFoo)
+		}()
+
+		mysql.Transaction(nil, func(tx *Tx) error {
+			a.NilError(insert(tx))
+			panic(errFoo)
+		})
+	}()
+	a.Equal(count(), 1)
+
+	// 死锁时重新执行整个事务。
+	deadlock := &mysqldriver.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}
+	attempts := 0
+	memdb.Inject(testDB, 1, deadlock)
+	a.NilError(mysql.Transaction(nil, func(tx *Tx) error {
+		attempts++
+		return insert(tx)
+	}))
+	a.Equal(attempts, 2)
+	a.Equal(count(), 2)
+
+	attempts = 0
+	memdb.Inject(testDB, DefaultTxMaxAttempts, deadlock)
+	err = mysql.Transaction(nil, func(tx *Tx) error {
+		attempts++
+		return insert(tx)
+	})
+	a.Equal(err, deadlock)
+	a.Equal(attempts, DefaultTxMaxAttempts)
+	a.Equal(count(), 2)
+
+	// 业务代码包装过的死锁错误也会重试。
+	attempts = 0
+	memdb.Inject(testDB, 1, deadlock)
+	a.NilError(mysql.Transaction(nil, func(tx *Tx) error {
+		attempts++
+
+		if err := insert(tx); err != nil {
+			return &testWrappedError{err}
+		}
+
+		return nil
+	}))
+	a.Equal(attempts, 2)
+	a.Equal(count(), 3)
+}
+
+func TestTxSavepoint(t *testing.T) {
+	a := assert.New(t)
+	const table = "test_tx_savepoint"
+	ctx := context.Background()
+	f := memFactory(t)
+	mysql := initTable(ctx, t, f, table,
+		"id bigint(20) NOT NULL PRIMARY KEY",
+	)
+	ids := func(tx *Tx) (ids []int64) {
+		rows, err := tx.Query("SELECT id FROM " + table + " ORDER BY id")
+		a.NilError(err)
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			a.NilError(rows.Scan(&id))
+			ids = append(ids, id)
+		}
+
+		return
+	}
+	errFoo := errors.New("foo")
+
+	a.NilError(mysql.Transaction(nil, func(tx *Tx) error {
+		a.NilError(tx.Exec("INSERT INTO " + table + " VALUES (1)"))
+
+		// 嵌套事务回滚不影响外层事务。
+		nested, err := tx.Begin()
+		a.NilError(err)
+		a.NilError(nested.Exec("INSERT INTO " + table + " VALUES (2)"))
+		a.NilError(nested.Rollback())
+		a.Equal(nested.Commit(), sql.ErrTxDone)
+		a.Equal(ids(tx), []int64{1})
+
+		// ctx 中有外层事务时，MySQL#Transaction 会开启嵌套事务。
+		err = f.New(tx.Context()).Transaction(nil, func(nested *Tx) error {
+			a.NilError(nested.Exec("INSERT INTO " + table + " VALUES (3)"))
+
+			return nested.Transaction(func(inner *Tx) error {
+				a.NilError(inner.Exec("INSERT INTO " + table + " VALUES (4)"))
+				return errFoo
+			})
+		})
+		a.Equal(err, errFoo)
+		a.Equal(ids(tx), []int64{1})
+
+		a.NilError(tx.Transaction(func(nested *Tx) error {
+			_, err := nested.Exec("INSERT INTO " + table + " VALUES (5)")
+			return err
+		}))
+
+		// 手动管理 SAVEPOINT。
+		a.NilError(tx.Savepoint("sp"))
+		a.NilError(tx.Exec("INSERT INTO " + table + " VALUES (6)"))
+		a.NilError(tx.RollbackTo("sp"))
+		a.NilError(tx.ReleaseSavepoint("sp"))
+		a.NonNilError(tx.RollbackTo("sp"))
+		return nil
+	}))
+
+	rows, err := mysql.Query("SELECT COUNT(*) FROM " + table)
+	a.NilError(err)
+	defer rows.Close()
+	a.Assert(rows.Next())
+	var cnt int
+	a.NilError(rows.Scan(&cnt))
+	a.Equal(cnt, 2)
+}
+
+func TestWithTx(t *testing.T) {
+	a := assert.New(t)
+	const table = "test_with_tx"
+	ctx := context.Background()
+	f := memFactory(t)
+	mysql := initTable(ctx, t, f, table,
+		"id bigint(20) NOT NULL PRIMARY KEY",
+	)
+	count := func(mysql *MySQL) (cnt int) {
+		row, err := mysql.QueryRow("SELECT COUNT(*) FROM " + table)
+		a.NilError(err)
+		a.NilError(row.Scan(&cnt))
+		return
+	}
+	insert := func(ctx context.Context, id int64) {
+		stmt, err := f.New(ctx).Prepare("INSERT INTO " + table + " VALUES (?)")
+		a.NilError(err)
+		a.NilError(stmt.Exec(id))
+	}
+
+	tx, err := mysql.BeginTx(nil)
+	a.NilError(err)
+	txCtx := WithTx(ctx, tx)
+	insert(txCtx, 1)
+	a.NilError(f.New(txCtx).Exec("INSERT INTO "+table+" VALUES (?)", 2))
+	a.Equal(count(f.New(txCtx)), 2)
+	a.Equal(count(f.New(ctx)), 0)
+
+	// 绑定了事务的 MySQL 实例开启的是嵌套事务。
+	nested, err := f.New(txCtx).BeginTx(nil)
+	a.NilError(err)
+	insert(nested.Context(), 3)
+	a.NilError(nested.Rollback())
+	a.Equal(count(f.New(txCtx)), 2)
+
+	a.NilError(tx.Commit())
+	a.Equal(count(f.New(ctx)), 2)
+
+	// 事务结束后继续使用 ctx 会报错。
+	_, err = f.New(txCtx).Exec("INSERT INTO "+table+" VALUES (?)", 4)
+	a.Equal(err, sql.ErrTxDone)
+
+	// 不同实例上的事务不会被使用。
+	other := NewFactory(&Config{DSN: memdb.Scheme + testDB + "_other"})
+	a.NilError(other.Conn(ctx))
+	defer other.Close()
+	a.Assert(other.New(txCtx).tx == nil)
+}
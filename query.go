@@ -0,0 +1,247 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// QueryInto 执行查询并将所有结果追加到 dest 指向的 slice 里，映射规则详见 Rows#ScanAll。
+func (mysql *MySQL) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	return queryInto(mysql, dest, query, args)
+}
+
+// QueryMap 执行查询并将所有结果放到 dest 指向的 map 里，map 的 key 是 key 这一列的值。
+// 如果 map 的值是结构体或者结构体指针，按照 Rows#ScanStruct 的规则设置，
+// 否则查询结果必须正好有两列，除了 key 以外的另一列就是 map 的值。
+// 如果 key 有重复，以最后一条结果为准。
+func (mysql *MySQL) QueryMap(dest interface{}, key string, query string, args ...interface{}) error {
+	return queryMap(mysql, dest, key, query, args)
+}
+
+// QueryScalar 执行查询并将第一条结果的唯一一列设置到 dest 里，比如查询 COUNT(*)。
+// 如果查询没有任何结果，返回 sql.ErrNoRows。
+func (mysql *MySQL) QueryScalar(dest interface{}, query string, args ...interface{}) error {
+	return queryScalar(mysql, dest, query, args)
+}
+
+// QueryColumn 执行查询并将所有结果的唯一一列追加到 dest 指向的 slice 里。
+func (mysql *MySQL) QueryColumn(dest interface{}, query string, args ...interface{}) error {
+	return queryColumn(mysql, dest, query, args)
+}
+
+// QueryInto 在事务中执行查询并将所有结果追加到 dest 指向的 slice 里，详见 MySQL#QueryInto。
+func (tx *Tx) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	return queryInto(tx, dest, query, args)
+}
+
+// QueryMap 在事务中执行查询并将所有结果放到 dest 指向的 map 里，详见 MySQL#QueryMap。
+func (tx *Tx) QueryMap(dest interface{}, key string, query string, args ...interface{}) error {
+	return queryMap(tx, dest, key, query, args)
+}
+
+// QueryScalar 在事务中执行查询并将第一条结果的唯一一列设置到 dest 里，详见 MySQL#QueryScalar。
+func (tx *Tx) QueryScalar(dest interface{}, query string, args ...interface{}) error {
+	return queryScalar(tx, dest, query, args)
+}
+
+// QueryColumn 在事务中执行查询并将所有结果的唯一一列追加到 dest 指向的 slice 里，详见 MySQL#QueryColumn。
+func (tx *Tx) QueryColumn(dest interface{}, query string, args ...interface{}) error {
+	return queryColumn(tx, dest, query, args)
+}
+
+// QueryInto 使用 args 执行语句并将所有结果追加到 dest 指向的 slice 里，详见 MySQL#QueryInto。
+func (s *Stmt) QueryInto(dest interface{}, args ...interface{}) error {
+	return queryInto(s.db, dest, s.query, args)
+}
+
+// QueryMap 使用 args 执行语句并将所有结果放到 dest 指向的 map 里，详见 MySQL#QueryMap。
+func (s *Stmt) QueryMap(dest interface{}, key string, args ...interface{}) error {
+	return queryMap(s.db, dest, key, s.query, args)
+}
+
+// QueryScalar 使用 args 执行语句并将第一条结果的唯一一列设置到 dest 里，详见 MySQL#QueryScalar。
+func (s *Stmt) QueryScalar(dest interface{}, args ...interface{}) error {
+	return queryScalar(s.db, dest, s.query, args)
+}
+
+// QueryColumn 使用 args 执行语句并将所有结果的唯一一列追加到 dest 指向的 slice 里，详见 MySQL#QueryColumn。
+func (s *Stmt) QueryColumn(dest interface{}, args ...interface{}) error {
+	return queryColumn(s.db, dest, s.query, args)
+}
+
+func queryInto(db db, dest interface{}, query string, args []interface{}) error {
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	return rows.ScanAll(dest)
+}
+
+func queryScalar(db db, dest interface{}, query string, args []interface{}) error {
+	row, err := db.QueryRow(query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	return row.Scan(dest)
+}
+
+func queryColumn(db db, dest interface{}, query string, args []interface{}) (err error) {
+	v := reflect.ValueOf(dest)
+
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("go-mysql: dest must be a pointer to slice instead of %T", dest)
+	}
+
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return
+	}
+
+	if len(columns) != 1 {
+		return fmt.Errorf("go-mysql: query must return exactly 1 column instead of %v", len(columns))
+	}
+
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+
+	for rows.Next() {
+		elem := reflect.New(elemType)
+
+		if err = rows.Scan(elem.Interface()); err != nil {
+			return
+		}
+
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+
+	return rows.Err()
+}
+
+func queryMap(db db, dest interface{}, key string, query string, args []interface{}) (err error) {
+	v := reflect.ValueOf(dest)
+
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Map {
+		return fmt.Errorf("go-mysql: dest must be a pointer to map instead of %T", dest)
+	}
+
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return
+	}
+
+	keyIdx := -1
+
+	for i, col := range columns {
+		if strings.EqualFold(col, key) {
+			keyIdx = i
+			break
+		}
+	}
+
+	if keyIdx < 0 {
+		return fmt.Errorf("go-mysql: key column %v is not found in query result", key)
+	}
+
+	m := v.Elem()
+
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+
+	keyType := m.Type().Key()
+	elemType := m.Type().Elem()
+	structType := elemType
+
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+
+	isStruct := structType.Kind() == reflect.Struct && !isScannable(structType)
+
+	if !isStruct && len(columns) != 2 {
+		return fmt.Errorf("go-mysql: query must return exactly 2 columns instead of %v", len(columns))
+	}
+
+	var mapping *structMapping
+
+	if isStruct {
+		if mapping, err = newStructMapping(rows.ctx, rows.rows, reflect.New(structType).Interface(), rows.unmapped); err != nil {
+			return
+		}
+	}
+
+	targets := make([]interface{}, len(columns))
+
+	for rows.Next() {
+		k := reflect.New(keyType)
+		elem := reflect.New(structType)
+
+		if isStruct {
+			// key 这一列如果有对应的字段，先扫描到字段里再复制到 k 里。
+			sv := elem.Elem()
+
+			for i, index := range mapping.fields {
+				switch {
+				case index != nil:
+					targets[i] = fieldByIndex(sv, index).Addr().Interface()
+				case i == keyIdx:
+					targets[i] = k.Interface()
+				default:
+					targets[i] = new(sql.RawBytes)
+				}
+			}
+
+			if err = rows.rows.Scan(targets...); err != nil {
+				return
+			}
+
+			if index := mapping.fields[keyIdx]; index != nil {
+				field := fieldByIndex(sv, index)
+
+				if field.Type().AssignableTo(keyType) {
+					k.Elem().Set(field)
+				} else if err = convertAssign(k.Interface(), field.Interface()); err != nil {
+					return
+				}
+			}
+		} else {
+			elem = reflect.New(elemType)
+			targets[keyIdx] = k.Interface()
+			targets[1-keyIdx] = elem.Interface()
+
+			if err = rows.rows.Scan(targets...); err != nil {
+				return
+			}
+		}
+
+		if elemType.Kind() != reflect.Ptr || !isStruct {
+			elem = elem.Elem()
+		}
+
+		m.SetMapIndex(k.Elem(), elem)
+	}
+
+	return rows.Err()
+}
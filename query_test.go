@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/huandu/go-assert"
+)
+
+func TestQueryHelpers(t *testing.T) {
+	a := assert.New(t)
+	const table = "test_query_helpers"
+	ctx := context.Background()
+	f := memFactory(t)
+	mysql := initTable(ctx, t, f, table,
+		"id bigint(20) NOT NULL PRIMARY KEY",
+		"name VARCHAR(255) NOT NULL",
+		"score bigint(20)",
+	)
+	a.NilError(mysql.Exec("INSERT INTO " + table + " VALUES (1, 'foo', 10), (2, 'bar', NULL), (3, 'baz', 30)"))
+
+	type user struct {
+		ID    int64  `db:"id"`
+		Name  string `db:"name"`
+		Score *int64 `db:"score"`
+	}
+
+	var users []user
+	a.NilError(mysql.QueryInto(&users, "SELECT * FROM "+table+" WHERE id < ? ORDER BY id", 3))
+	a.Equal(len(users), 2)
+	a.Equal(users[0].Name, "foo")
+	a.Assert(users[1].Score == nil)
+
+	var byID map[int64]*user
+	a.NilError(mysql.QueryMap(&byID, "id", "SELECT * FROM "+table))
+	a.Equal(len(byID), 3)
+	a.Equal(byID[3].Name, "baz")
+
+	// key 列没有对应的字段时依然可以作为 key。
+	byName := map[string]user{}
+	a.NilError(mysql.QueryMap(&byName, "name", "SELECT name AS n, id, name FROM "+table))
+	a.Equal(byName["bar"].ID, int64(2))
+
+	scores := map[string]*int64{}
+	a.NilError(mysql.QueryMap(&scores, "name", "SELECT name, score FROM "+table))
+	a.Equal(*scores["foo"], int64(10))
+	a.Assert(scores["bar"] == nil)
+	a.NonNilError(mysql.QueryMap(&scores, "name", "SELECT * FROM "+table))
+	a.NonNilError(mysql.QueryMap(&scores, "no_such_column", "SELECT name, score FROM "+table))
+
+	var cnt int
+	a.NilError(mysql.QueryScalar(&cnt, "SELECT COUNT(*) FROM "+table))
+	a.Equal(cnt, 3)
+	a.Equal(mysql.QueryScalar(&cnt, "SELECT id FROM "+table+" WHERE id > 10"), sql.ErrNoRows)
+
+	var names []string
+	a.NilError(mysql.QueryColumn(&names, "SELECT name FROM "+table+" ORDER BY id"))
+	a.Equal(names, []string{"foo", "bar", "baz"})
+	a.NonNilError(mysql.QueryColumn(&names, "SELECT id, name FROM "+table))
+
+	// Tx 和 Stmt 上的同名方法。
+	a.NilError(mysql.Transaction(nil, func(tx *Tx) error {
+		a.NilError(tx.Exec("INSERT INTO " + table + " VALUES (4, 'qux', 40)"))
+
+		var ids []int64
+		a.NilError(tx.QueryColumn(&ids, "SELECT id FROM "+table+" ORDER BY id"))
+		a.Equal(ids, []int64{1, 2, 3, 4})
+
+		stmt, err := tx.Prepare("SELECT * FROM " + table + " WHERE id = ?")
+		a.NilError(err)
+		var found []*user
+		a.NilError(stmt.QueryInto(&found, 4))
+		a.Equal(len(found), 1)
+		a.Equal(found[0].Name, "qux")
+
+		var score int64
+		a.NilError(tx.QueryScalar(&score, "SELECT score FROM "+table+" WHERE id = 4"))
+		a.Equal(score, int64(40))
+
+		m := map[int64]string{}
+		return tx.QueryMap(&m, "id", "SELECT id, name FROM "+table)
+	}))
+
+	stmt, err := mysql.Prepare("SELECT COUNT(*) FROM " + table + " WHERE id > ?")
+	a.NilError(err)
+	a.NilError(stmt.QueryScalar(&cnt, 1))
+	a.Equal(cnt, 3)
+}
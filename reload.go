@@ -0,0 +1,215 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/altstory/go-config"
+	"github.com/altstory/go-log"
+	"github.com/altstory/go-runner"
+)
+
+// drainCheckInterval 是等待旧连接上的查询结束时的检查间隔。
+const drainCheckInterval = 100 * time.Millisecond
+
+// Reload 使用新的配置重新建立连接。
+// 新连接全部建立成功后才会替换旧连接，之后通过 Factory#New 创建的实例都会使用新连接；
+// 旧连接会在所有正在进行的查询、事务结束后关闭，最多等待 DrainTimeout。
+// 如果新连接建立失败，Factory 会继续使用旧的配置和连接。
+//
+// 需要注意，迁移模式下通过 Factory#Cutover 修改的切换状态会被重置为配置中的 cutover。
+func (f *Factory) Reload(ctx context.Context, config *Config) error {
+	if f.unavailable {
+		return errors.New("go-mysql: factory is not initialized")
+	}
+
+	nf := NewFactory(config)
+	conn, err := nf.open(ctx)
+
+	if err != nil {
+		log.Errorf(ctx, "err=%v||dsn=%v||go-mysql: fail to reload MySQL config", err, config.DSN)
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.factoryOptions = nf.factoryOptions
+	f.swap(ctx, conn)
+	log.Tracef(ctx, "dsn=%v||go-mysql: MySQL config is reloaded", config.DSN)
+	return nil
+}
+
+// Drain 等待所有正在进行的查询结束后关闭连接，最多等待 timeout。
+func (conn *dbConn) Drain(ctx context.Context, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		inUse := conn.InUse()
+
+		if inUse == 0 {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			log.Warnf(ctx, "in_use=%v||timeout=%v||go-mysql: force to close old MySQL connections after drain timeout", inUse, timeout)
+			break
+		}
+
+		time.Sleep(drainCheckInterval)
+	}
+
+	if err := conn.Close(); err != nil {
+		log.Errorf(ctx, "err=%v||go-mysql: fail to close old MySQL connections", err)
+	}
+}
+
+// InUse 返回所有实例上正在使用的连接数。
+func (conn *dbConn) InUse() (inUse int) {
+	for _, ins := range conn.instances() {
+		inUse += ins.InUse()
+	}
+
+	return
+}
+
+// instances 返回所有已经建立连接的实例，包括迁移的目标分片。
+func (conn *dbConn) instances() (instances []*dbInstance) {
+	if conn.Master != nil {
+		instances = append(instances, &conn.dbInstance)
+	}
+
+	instances = append(instances, conn.Instances...)
+
+	if conn.Migration != nil {
+		instances = append(instances, conn.Migration.owned...)
+	}
+
+	return
+}
+
+// InUse 返回主库和所有从库上正在使用的连接数。
+func (db *dbInstance) InUse() (inUse int) {
+	if db.Master == nil {
+		return
+	}
+
+	inUse = db.Master.Stats().InUse
+
+	if db.Slaves != nil {
+		for _, s := range db.Slaves.slaves {
+			if s.DB != db.Master {
+				inUse += s.DB.Stats().InUse
+			}
+		}
+	}
+
+	return
+}
+
+// configWatcher 定期检查配置文件是否修改，修改后重新加载 section 对应的配置。
+// go-runner 没有提供配置变更通知，这里直接读取 go-runner 使用的配置文件。
+type configWatcher struct {
+	factory *Factory
+	section string
+	config  Config
+	paths   []string
+	mtimes  []time.Time
+}
+
+// watchConfig 启动配置文件检查，服务退出时自动停止。
+func watchConfig(ctx context.Context, f *Factory, section string, config Config, interval time.Duration) {
+	fl := flag.Lookup("config")
+
+	if fl == nil {
+		log.Warnf(ctx, "section=%v||go-mysql: config file is unknown and hot reload is disabled", section)
+		return
+	}
+
+	w := &configWatcher{
+		factory: f,
+		section: section,
+		config:  config,
+		paths:   []string{fl.Value.String()},
+	}
+
+	// go-runner 支持通过环境变量追加一个配置文件。
+	if ext := os.Getenv("ALTSTORY_RUNNER_EXT_CONFIG"); ext != "" {
+		w.paths = append(w.paths, ext)
+	}
+
+	w.mtimes = w.stat()
+	stop := make(chan struct{})
+	runner.OnExit(func(ctx context.Context) {
+		close(stop)
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				w.Check(context.Background())
+			}
+		}
+	}()
+}
+
+func (w *configWatcher) stat() []time.Time {
+	mtimes := make([]time.Time, len(w.paths))
+
+	for i, path := range w.paths {
+		if info, err := os.Stat(path); err == nil {
+			mtimes[i] = info.ModTime()
+		}
+	}
+
+	return mtimes
+}
+
+// Check 检查配置文件，如果 section 的配置发生变化则调用 Factory#Reload。
+func (w *configWatcher) Check(ctx context.Context) {
+	mtimes := w.stat()
+
+	if reflect.DeepEqual(mtimes, w.mtimes) {
+		return
+	}
+
+	c, err := config.LoadFile(w.paths[0])
+
+	if err == nil && len(w.paths) > 1 {
+		err = c.LoadExt(w.paths[1])
+	}
+
+	if err != nil {
+		log.Errorf(ctx, "err=%v||section=%v||go-mysql: fail to load config file for hot reload", err, w.section)
+		return
+	}
+
+	w.mtimes = mtimes
+	var next Config
+
+	if err = c.Unmarshal(w.section, &next); err != nil {
+		log.Errorf(ctx, "err=%v||section=%v||go-mysql: fail to read MySQL config for hot reload", err, w.section)
+		return
+	}
+
+	if reflect.DeepEqual(next, w.config) {
+		return
+	}
+
+	cp := next
+
+	if err = w.factory.Reload(ctx, &cp); err != nil {
+		return
+	}
+
+	w.config = next
+}
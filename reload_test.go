@@ -0,0 +1,129 @@
+package mysql
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/huandu/go-assert"
+)
+
+// waitClosed 等待 db 被关闭，关闭后 Ping 会返回错误。
+func waitClosed(ins *dbInstance, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		if ins.Master.Ping() != nil {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestFactoryReload(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	oldDB, newDB := testDB+"_reload1", testDB+"_reload2"
+	memdb.Drop(oldDB)
+	memdb.Drop(newDB)
+
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + oldDB,
+	})
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	old := f.conn()
+	a.NilError(f.New(ctx).Exec("CREATE TABLE t (id INT PRIMARY KEY)"))
+
+	// 正在进行的事务不受 Reload 影响。
+	tx, err := f.New(ctx).BeginTx(nil)
+	a.NilError(err)
+
+	a.NilError(f.Reload(ctx, &Config{
+		DSN:          memdb.Scheme + newDB,
+		DrainTimeout: 10 * time.Second,
+	}))
+	a.Assert(f.conn() != old)
+	a.Equal(f.options().dsn, memdb.Scheme+newDB)
+
+	_, err = f.New(ctx).Exec("INSERT INTO t VALUES (1)")
+	a.NonNilError(err)
+
+	a.NilError(tx.Exec("INSERT INTO t VALUES (1)"))
+	a.Assert(!waitClosed(&old.dbInstance, 200*time.Millisecond))
+	a.NilError(tx.Commit())
+	a.Assert(waitClosed(&old.dbInstance, time.Second))
+
+	// 配置错误时继续使用旧连接。
+	current := f.conn()
+	a.NonNilError(f.Reload(ctx, &Config{
+		DSN:          memdb.Scheme + oldDB,
+		SlaveBalance: "no_such_balance",
+	}))
+	a.Equal(f.conn(), current)
+	a.Equal(f.options().dsn, memdb.Scheme+newDB)
+
+	// 超过 DrainTimeout 后强制关闭旧连接。
+	tx, err = f.New(ctx).BeginTx(nil)
+	a.NilError(err)
+	defer tx.Rollback()
+	a.NilError(f.Reload(ctx, &Config{
+		DSN:          memdb.Scheme + oldDB,
+		DrainTimeout: 50 * time.Millisecond,
+	}))
+	a.Assert(waitClosed(&current.dbInstance, time.Second))
+}
+
+func TestConfigWatcher(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-mysql")
+	a.NilError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "service.conf")
+	write := func(dsn string, mtime time.Time) {
+		a.NilError(ioutil.WriteFile(path, []byte("[mysql]\ndsn = \""+dsn+"\"\n"), 0644))
+		a.NilError(os.Chtimes(path, mtime, mtime))
+	}
+
+	oldDSN, newDSN := memdb.Scheme+testDB+"_watch1", memdb.Scheme+testDB+"_watch2"
+	now := time.Now()
+	write(oldDSN, now.Add(-time.Minute))
+
+	config := Config{DSN: oldDSN}
+	f := NewFactory(&Config{DSN: oldDSN})
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	w := &configWatcher{
+		factory: f,
+		section: "mysql",
+		config:  config,
+		paths:   []string{path},
+	}
+	w.mtimes = w.stat()
+
+	// 文件没有修改时不会重新加载。
+	conn := f.conn()
+	w.Check(ctx)
+	a.Equal(f.conn(), conn)
+
+	// 只修改了时间但配置没变，也不会重新加载。
+	write(oldDSN, now)
+	w.Check(ctx)
+	a.Equal(f.conn(), conn)
+
+	write(newDSN, now.Add(time.Minute))
+	w.Check(ctx)
+	a.Assert(f.conn() != conn)
+	a.Equal(f.options().dsn, newDSN)
+}
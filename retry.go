@@ -0,0 +1,164 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 默认的重试设置。
+const (
+	DefaultRetryBackoff    time.Duration = 10 * time.Millisecond // DefaultRetryBackoff 是第一次重试前默认的等待时间。
+	DefaultRetryMaxBackoff time.Duration = time.Second           // DefaultRetryMaxBackoff 是两次重试之间默认的最长等待时间。
+	DefaultTxMaxAttempts                 = 3                     // DefaultTxMaxAttempts 是 MySQL#Transaction 遇到死锁时默认最多执行的次数。
+)
+
+// DefaultRetryErrors 是默认可以重试的 MySQL 错误号，包括死锁（1213）和锁等待超时（1205）。
+var DefaultRetryErrors = []uint16{1213, 1205}
+
+// txRetryErrors 是事务可以整体重试的错误号，即死锁（1213，SQLSTATE 40001）。
+var txRetryErrors = []uint16{1213}
+
+// retryPolicy 是遇到临时错误时的重试策略。
+type retryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Errors      map[uint16]bool
+	RetryWrites bool
+	ConnErrors  bool // ConnErrors 表示连接断开时是否可以重试。
+}
+
+func newRetryPolicy(config ConfigRetry) *retryPolicy {
+	if config.MaxAttempts <= 1 {
+		return nil
+	}
+
+	errors := config.Errors
+
+	if len(errors) == 0 {
+		errors = DefaultRetryErrors
+	}
+
+	p := makeRetryPolicy(config.MaxAttempts, config.Backoff, config.MaxBackoff, errors)
+	p.RetryWrites = config.RetryWrites
+	p.ConnErrors = true
+	return p
+}
+
+// newTxRetryPolicy 创建 MySQL#Transaction 的重试策略。
+// 事务只在死锁时重试，连接断开时事务可能已经提交，不能重试。
+func newTxRetryPolicy(config ConfigRetry) *retryPolicy {
+	attempts := config.TxMaxAttempts
+
+	if attempts == 0 {
+		attempts = DefaultTxMaxAttempts
+	}
+
+	if attempts <= 1 {
+		return nil
+	}
+
+	p := makeRetryPolicy(attempts, config.Backoff, config.MaxBackoff, txRetryErrors)
+	p.RetryWrites = true
+	return p
+}
+
+func makeRetryPolicy(attempts int, backoff, maxBackoff time.Duration, errors []uint16) *retryPolicy {
+	p := &retryPolicy{
+		MaxAttempts: attempts,
+		Backoff:     backoff,
+		MaxBackoff:  maxBackoff,
+		Errors:      make(map[uint16]bool),
+	}
+
+	if p.Backoff <= 0 {
+		p.Backoff = DefaultRetryBackoff
+	}
+
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+
+	for _, num := range errors {
+		p.Errors[num] = true
+	}
+
+	return p
+}
+
+// Do 执行 fn，遇到可以重试的错误时按照策略重试，返回最后一次执行的错误。
+// write 表示 fn 是否会修改数据，默认只有读请求会重试。
+func (p *retryPolicy) Do(ctx context.Context, write bool, query string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+
+		if !p.ShouldRetry(write, attempt, err) {
+			return err
+		}
+
+		statsForRetry(ctx, query, attempt, err)
+
+		if !p.Wait(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+// ShouldRetry 判断第 attempt 次执行返回 err 之后是否需要重试。
+func (p *retryPolicy) ShouldRetry(write bool, attempt int, err error) bool {
+	if p == nil || err == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+
+	if write && !p.RetryWrites {
+		return false
+	}
+
+	return p.Retryable(err)
+}
+
+// Retryable 判断 err 是否是可以重试的临时错误。
+// 连接断开（比如 MySQL server has gone away）取决于 ConnErrors，其他错误需要在 Errors 中设置错误号。
+// 与 Is 一样，会逐层检查 err 包装的错误，因此事务函数返回包装过的死锁错误也能重试。
+func (p *retryPolicy) Retryable(err error) bool {
+	for ; err != nil; err = unwrap(err) {
+		if err == driver.ErrBadConn || err == mysql.ErrInvalidConn {
+			return p.ConnErrors
+		}
+
+		if e, ok := err.(*mysql.MySQLError); ok {
+			return p.Errors[e.Number]
+		}
+	}
+
+	return false
+}
+
+// Wait 等待第 attempt 次重试之前的退避时间，如果 ctx 已经结束则返回 false。
+func (p *retryPolicy) Wait(ctx context.Context, attempt int) bool {
+	backoff := p.Backoff
+
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	// 加入随机抖动，避免多个请求同时重试再次冲突。
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
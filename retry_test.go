@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+func TestRetry(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		Retry: ConfigRetry{
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond,
+		},
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}
+	m := initTable(ctx, t, f, "retry", "id INT PRIMARY KEY")
+	a.NilError(m.Exec("INSERT INTO retry VALUES (1)"))
+
+	// 读请求会重试。
+	memdb.Inject(testDB, 2, deadlock)
+	rows, err := m.Query("SELECT id FROM retry")
+	a.NilError(err)
+	rows.Close()
+
+	var id int
+	memdb.Inject(testDB, 2, deadlock)
+	row, err := m.QueryRow("SELECT id FROM retry")
+	a.NilError(err)
+	a.NilError(row.Scan(&id))
+	a.Equal(id, 1)
+
+	stmt, err := m.Prepare("SELECT id FROM retry WHERE id = ?")
+	a.NilError(err)
+	memdb.Inject(testDB, 1, deadlock)
+	row, err = stmt.QueryRow(1)
+	a.NilError(err)
+	a.NilError(row.Scan(&id))
+
+	// 超过最大次数后返回最后一次的错误。
+	memdb.Inject(testDB, 3, deadlock)
+	_, err = m.Query("SELECT id FROM retry")
+	a.Equal(err, deadlock)
+	memdb.Drop(testDB)
+
+	// 默认不重试写请求，也不重试不在列表中的错误。
+	m = initTable(ctx, t, f, "retry", "id INT PRIMARY KEY")
+	memdb.Inject(testDB, 1, deadlock)
+	_, err = m.Exec("INSERT INTO retry VALUES (1)")
+	a.Equal(err, deadlock)
+
+	dup := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	memdb.Inject(testDB, 1, dup)
+	_, err = m.Query("SELECT id FROM retry")
+	a.Equal(err, dup)
+}
+
+func TestRetryWrites(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		Retry: ConfigRetry{
+			MaxAttempts: 2,
+			Backoff:     time.Millisecond,
+			Errors:      []uint16{1205},
+			RetryWrites: true,
+		},
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	m := initTable(ctx, t, f, "retry", "id INT PRIMARY KEY")
+	memdb.Inject(testDB, 1, &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded; try restarting transaction"})
+	res, err := m.Exec("INSERT INTO retry VALUES (1)")
+	a.NilError(err)
+	affected, _ := res.RowsAffected()
+	a.Equal(affected, int64(1))
+
+	// 事务中的语句不会重试。
+	tx, err := m.BeginTx(nil)
+	a.NilError(err)
+	defer tx.Rollback()
+	memdb.Inject(testDB, 1, &mysql.MySQLError{Number: 1205})
+	_, err = tx.Exec("INSERT INTO retry VALUES (2)")
+	a.NonNilError(err)
+
+	// ctx 结束后不再等待重试。
+	p := newRetryPolicy(ConfigRetry{MaxAttempts: 2, Backoff: time.Hour})
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	a.Assert(!p.Wait(cancelled, 1))
+}
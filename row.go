@@ -7,13 +7,57 @@ import (
 
 // Row 代表一条查询结果。
 type Row struct {
-	ctx context.Context
-	row *sql.Row
+	ctx      context.Context
+	rows     *sql.Rows
+	err      error
+	unmapped string
+	cancel   context.CancelFunc // cancel 用来释放查询超时的 ctx，没有设置超时时为 nil。
+	release  func(err error)    // release 用来结束 KILL QUERY 的监控并归还连接，没有开启 Config.KillOnCancel 时为 nil。
+
+	retry   *retryPolicy
+	query   string
+	requery func() (*sql.Rows, func(err error), error)
 }
 
 // Scan 将查询出来的数据设置到 dest 里面。
+// 如果查询没有任何结果，返回 sql.ErrNoRows。
 func (r *Row) Scan(dest ...interface{}) error {
-	err := r.row.Scan(dest...)
+	return r.scan(func(rows *sql.Rows) error {
+		return rows.Scan(dest...)
+	})
+}
+
+// ScanStruct 将查询出来的数据按照列名设置到 dest 指向的结构体里，映射规则详见 Rows#ScanStruct。
+// 如果查询没有任何结果，返回 sql.ErrNoRows。
+func (r *Row) ScanStruct(dest interface{}) error {
+	return r.scan(func(rows *sql.Rows) error {
+		m, err := newStructMapping(r.ctx, rows, dest, r.unmapped)
+
+		if err != nil {
+			return err
+		}
+
+		return m.Scan(rows, dest)
+	})
+}
+
+func (r *Row) scan(fn func(rows *sql.Rows) error) error {
+	if r.cancel != nil {
+		defer r.cancel()
+	}
+
+	err := r.scanOnce(fn)
+
+	for attempt := 1; r.retry.ShouldRetry(false, attempt, err); attempt++ {
+		statsForRetry(r.ctx, r.query, attempt, err)
+
+		if !r.retry.Wait(r.ctx, attempt) {
+			break
+		}
+
+		r.rows, r.release, r.err = r.requery()
+		err = r.scanOnce(fn)
+	}
 
 	if err != nil {
 		return err
@@ -22,3 +66,33 @@ func (r *Row) Scan(dest ...interface{}) error {
 	statsForSelectedRows(r.ctx, 1)
 	return nil
 }
+
+// scanOnce 与 sql.Row#Scan 一样，读取第一条结果后关闭 rows。
+func (r *Row) scanOnce(fn func(rows *sql.Rows) error) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.release != nil {
+		defer func() {
+			r.release(r.rows.Err())
+			r.release = nil
+		}()
+	}
+
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+
+		return sql.ErrNoRows
+	}
+
+	if err := fn(r.rows); err != nil {
+		return err
+	}
+
+	return r.rows.Close()
+}
@@ -3,17 +3,37 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"reflect"
 )
 
 // Rows 代表一个查询结果。
 type Rows struct {
-	ctx  context.Context
-	rows *sql.Rows
+	ctx      context.Context
+	rows     *sql.Rows
+	unmapped string
+	cancel   context.CancelFunc // cancel 用来释放查询超时的 ctx，没有设置超时时为 nil。
+	release  func(err error)    // release 用来结束 KILL QUERY 的监控并归还连接，没有开启 Config.KillOnCancel 时为 nil。
+
+	mapping *structMapping // mapping 是上一次 ScanStruct 使用的映射关系，同一个类型不需要重复计算。
 }
 
-// Close 关闭 rs 来释放资源。
+// Close 关闭 rs 来释放资源，可以重复调用。
 func (rs *Rows) Close() error {
-	return rs.rows.Close()
+	err := rs.rows.Close()
+
+	// release 只能调用一次，ScanAll 之后调用者往往还会再 Close 一次。
+	if rs.release != nil {
+		rs.release(rs.rows.Err())
+		rs.release = nil
+	}
+
+	if rs.cancel != nil {
+		rs.cancel()
+		rs.cancel = nil
+	}
+
+	return err
 }
 
 // ColumnTypes 返回列类型信息。
@@ -52,3 +72,66 @@ func (rs *Rows) NextResultSet() bool {
 func (rs *Rows) Scan(dest ...interface{}) error {
 	return rs.rows.Scan(dest...)
 }
+
+// ScanStruct 将当前这条结果按照列名设置到 dest 指向的结构体里。
+//
+// 列名优先与字段的 `db` tag 匹配，没有 tag 的字段使用字段名匹配，不区分大小写，tag 为 "-" 的字段会被忽略。
+// 嵌入的结构体（包括结构体指针）会被展开，nil 的结构体指针会自动分配内存。
+// 如果某一列可能是 NULL，对应的字段需要是指针或者 sql.NullString 之类的类型，NULL 会被设置为 nil。
+// 在结构体中找不到对应字段的列按照 Config.UnmappedColumns 处理，默认忽略。
+func (rs *Rows) ScanStruct(dest interface{}) (err error) {
+	if rs.mapping == nil || rs.mapping.typ != reflect.TypeOf(dest) {
+		if rs.mapping, err = newStructMapping(rs.ctx, rs.rows, dest, rs.unmapped); err != nil {
+			return
+		}
+	}
+
+	return rs.mapping.Scan(rs.rows, dest)
+}
+
+// ScanAll 读取所有剩下的结果并追加到 dest 指向的 slice 里，读取完成后会关闭 rs。
+// 如果 slice 的元素是结构体或者结构体指针，按照 ScanStruct 的规则设置每个元素，
+// 否则查询结果只能有一列，比如 *[]int64、*[]*string。
+func (rs *Rows) ScanAll(dest interface{}) (err error) {
+	defer rs.Close()
+	v := reflect.ValueOf(dest)
+
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("go-mysql: dest must be a pointer to slice instead of %T", dest)
+	}
+
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+
+	isStruct := structType.Kind() == reflect.Struct && !isScannable(structType)
+
+	for rs.Next() {
+		var elem reflect.Value
+
+		if isStruct {
+			elem = reflect.New(structType)
+			err = rs.ScanStruct(elem.Interface())
+
+			if elemType.Kind() != reflect.Ptr {
+				elem = elem.Elem()
+			}
+		} else {
+			elem = reflect.New(elemType)
+			err = rs.rows.Scan(elem.Interface())
+			elem = elem.Elem()
+		}
+
+		if err != nil {
+			return
+		}
+
+		slice.Set(reflect.Append(slice, elem))
+	}
+
+	return rs.Err()
+}
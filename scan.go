@@ -0,0 +1,219 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+// 查询结果中的列在结构体中没有对应字段时的处理方式。
+const (
+	UnmappedColumnsIgnore = "ignore" // UnmappedColumnsIgnore 忽略没有对应字段的列。
+	UnmappedColumnsWarn   = "warn"   // UnmappedColumnsWarn 忽略没有对应字段的列，并打印警告日志。
+	UnmappedColumnsError  = "error"  // UnmappedColumnsError 遇到没有对应字段的列时返回 *MissingFieldError。
+)
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+
+	structFieldsCache sync.Map
+)
+
+// MissingFieldError 代表查询结果中有些列在结构体中没有对应的字段。
+type MissingFieldError struct {
+	Type    reflect.Type // Type 是结构体类型。
+	Columns []string     // Columns 是所有没有对应字段的列。
+}
+
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("go-mysql: columns [%v] have no mapped field in %v", strings.Join(e.Columns, ", "), e.Type)
+}
+
+// structMapping 记录了查询结果的每一列对应结构体的哪个字段。
+type structMapping struct {
+	typ    reflect.Type // typ 是结构体指针的类型。
+	fields [][]int      // fields 是每一列对应字段的 index，没有对应字段的列为 nil。
+}
+
+func newStructMapping(ctx context.Context, rows *sql.Rows, dest interface{}, unmapped string) (*structMapping, error) {
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newColumnsMapping(ctx, columns, dest, unmapped)
+}
+
+// newColumnsMapping 计算 columns 与 dest 结构体字段的映射关系。
+func newColumnsMapping(ctx context.Context, columns []string, dest interface{}, unmapped string) (*structMapping, error) {
+	t := reflect.TypeOf(dest)
+
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("go-mysql: dest must be a pointer to struct instead of %T", dest)
+	}
+
+	fields := structFields(t.Elem())
+	m := &structMapping{
+		typ:    t,
+		fields: make([][]int, len(columns)),
+	}
+	var missing []string
+
+	for i, col := range columns {
+		if index, ok := fields[strings.ToLower(col)]; ok {
+			m.fields[i] = index
+		} else {
+			missing = append(missing, col)
+		}
+	}
+
+	if len(missing) > 0 {
+		switch unmapped {
+		case UnmappedColumnsError:
+			return nil, &MissingFieldError{
+				Type:    t.Elem(),
+				Columns: missing,
+			}
+		case UnmappedColumnsWarn:
+			log.Warnf(ctx, "type=%v||columns=%v||go-mysql: columns have no mapped field and are ignored", t.Elem(), strings.Join(missing, ","))
+		}
+	}
+
+	return m, nil
+}
+
+// Scan 将当前这一行设置到 dest 里，dest 的类型必须与 m.typ 一致。
+func (m *structMapping) Scan(rows *sql.Rows, dest interface{}) error {
+	return rows.Scan(m.Targets(dest)...)
+}
+
+// Targets 返回每一列在 dest 中对应字段的指针，没有对应字段的列使用 *sql.RawBytes 占位。
+func (m *structMapping) Targets(dest interface{}) []interface{} {
+	v := reflect.ValueOf(dest).Elem()
+	targets := make([]interface{}, len(m.fields))
+
+	for i, index := range m.fields {
+		if index == nil {
+			targets[i] = new(sql.RawBytes)
+			continue
+		}
+
+		targets[i] = fieldByIndex(v, index).Addr().Interface()
+	}
+
+	return targets
+}
+
+// fieldByIndex 与 reflect.Value#FieldByIndex 一样，但是会为 nil 的嵌入结构体指针分配内存。
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v
+}
+
+// structFields 返回结构体 t 中所有可以映射的字段，key 是小写的列名。
+// 列名优先使用 `db` tag，没有 tag 时使用字段名，tag 为 "-" 的字段会被忽略。
+// 嵌入的结构体会被展开，与 Go 的规则一样，同名字段以层级较浅的为准，同一层级的同名字段都会被忽略。
+func structFields(t reflect.Type) map[string][]int {
+	if v, ok := structFieldsCache.Load(t); ok {
+		return v.(map[string][]int)
+	}
+
+	fields := map[string][]int{}
+	depths := map[string]int{}
+	collectFields(t, nil, fields, depths, map[reflect.Type]bool{})
+
+	for name, index := range fields {
+		if index == nil {
+			delete(fields, name)
+		}
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func collectFields(t reflect.Type, parent []int, fields map[string][]int, depths map[string]int, visited map[reflect.Type]bool) {
+	visited[t] = true
+	defer delete(visited, t)
+	depth := len(parent)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("db")
+
+		if tag == "-" {
+			continue
+		}
+
+		if idx := strings.IndexByte(tag, ','); idx >= 0 {
+			tag = tag[:idx]
+		}
+
+		index := make([]int, depth+1)
+		copy(index, parent)
+		index[depth] = i
+
+		if sf.Anonymous && tag == "" {
+			ft := sf.Type
+			isPtr := ft.Kind() == reflect.Ptr
+
+			if isPtr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct && !isScannable(ft) {
+				// 未导出的嵌入结构体指针无法分配内存，只能忽略。
+				if !(isPtr && sf.PkgPath != "") && !visited[ft] {
+					collectFields(ft, index, fields, depths, visited)
+				}
+
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		name := tag
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		name = strings.ToLower(name)
+		d, ok := depths[name]
+
+		switch {
+		case !ok || depth < d:
+			fields[name] = index
+			depths[name] = depth
+		case depth == d:
+			fields[name] = nil
+		}
+	}
+}
+
+// isScannable 判断结构体 t 是否可以直接作为 Scan 的目标，这样的结构体不会被展开。
+func isScannable(t reflect.Type) bool {
+	return t == timeType || reflect.PtrTo(t).Implements(scannerType)
+}
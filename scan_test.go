@@ -0,0 +1,120 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/huandu/go-assert"
+)
+
+type testScanBase struct {
+	ID        int64     `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type testScanExtra struct {
+	Score int64 `db:"score"`
+}
+
+// TestScanScore 需要导出，未导出的嵌入结构体指针无法自动分配内存。
+type TestScanScore struct {
+	Score int64 `db:"score"`
+}
+
+type testScanUser struct {
+	testScanBase
+	*testScanExtra `db:"-"`
+	Extra          *testScanExtra
+
+	Name     string  `db:"name"`
+	Nickname *string `db:"nickname"`
+	Ignored  string  `db:"-"`
+}
+
+func TestScanStruct(t *testing.T) {
+	a := assert.New(t)
+	const table = "test_scan_struct"
+	ctx := context.Background()
+	f := memFactory(t)
+	mysql := initTable(ctx, t, f, table,
+		"id bigint(20) NOT NULL PRIMARY KEY",
+		"name VARCHAR(255) NOT NULL",
+		"nickname VARCHAR(255)",
+		"score bigint(20) NOT NULL",
+		"created_at DATETIME NOT NULL",
+	)
+	now := time.Now().Truncate(time.Second)
+	a.NilError(mysql.Exec("INSERT INTO "+table+" VALUES (1, 'foo', NULL, 10, ?), (2, 'bar', 'b', 20, ?)", now, now))
+
+	// 嵌入的结构体会被展开，NULL 会被设置为 nil。
+	var user testScanUser
+	row, err := mysql.QueryRow("SELECT * FROM " + table + " WHERE id = 1")
+	a.NilError(err)
+	a.NilError(row.ScanStruct(&user))
+	a.Equal(user.ID, int64(1))
+	a.Equal(user.Name, "foo")
+	a.Assert(user.Nickname == nil)
+	a.Assert(user.CreatedAt.Equal(now))
+	a.Assert(user.testScanExtra == nil)
+
+	// Extra 不是嵌入的结构体，score 没有对应的字段。
+	a.Assert(user.Extra == nil)
+
+	row, err = mysql.QueryRow("SELECT * FROM " + table + " WHERE id = 3")
+	a.NilError(err)
+	a.Equal(row.ScanStruct(&user), sql.ErrNoRows)
+
+	// 嵌入的结构体指针会自动分配内存。
+	type scoreUser struct {
+		*TestScanScore
+		Name string
+	}
+	var users []*scoreUser
+	rows, err := mysql.Query("SELECT name, score FROM " + table + " ORDER BY id")
+	a.NilError(err)
+	a.NilError(rows.ScanAll(&users))
+	a.Equal(len(users), 2)
+	a.Equal(users[0].Name, "foo")
+	a.Equal(users[1].Score, int64(20))
+
+	var all []testScanUser
+	rows, err = mysql.Query("SELECT id, name, nickname FROM " + table + " ORDER BY id")
+	a.NilError(err)
+	a.NilError(rows.ScanAll(&all))
+	a.Equal(len(all), 2)
+	a.Equal(*all[1].Nickname, "b")
+
+	var names []*string
+	rows, err = mysql.Query("SELECT nickname FROM " + table + " ORDER BY id")
+	a.NilError(err)
+	a.NilError(rows.ScanAll(&names))
+	a.Equal(len(names), 2)
+	a.Assert(names[0] == nil)
+	a.Equal(*names[1], "b")
+
+	var ids []int64
+	rows, err = mysql.Query("SELECT id FROM " + table + " ORDER BY id")
+	a.NilError(err)
+	a.NilError(rows.ScanAll(&ids))
+	a.Equal(ids, []int64{1, 2})
+
+	rows, err = mysql.Query("SELECT id FROM " + table)
+	a.NilError(err)
+	a.NonNilError(rows.ScanAll(ids))
+	a.NonNilError(rows.ScanStruct(user))
+
+	// 没有对应字段的列可以配置成报错。
+	f.New(ctx).ins.Unmapped = UnmappedColumnsError
+	defer func() {
+		f.New(ctx).ins.Unmapped = UnmappedColumnsIgnore
+	}()
+	row, err = f.New(ctx).QueryRow("SELECT * FROM " + table + " WHERE id = 1")
+	a.NilError(err)
+	err = row.ScanStruct(&user)
+	a.NonNilError(err)
+	e, ok := err.(*MissingFieldError)
+	a.Assert(ok)
+	a.Equal(e.Columns, []string{"score"})
+}
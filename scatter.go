@@ -0,0 +1,533 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+// ScatterOptions 代表跨实例查询的选项。
+type ScatterOptions struct {
+	OrderBy []ScatterOrder // OrderBy 是合并结果的排序方式，每个实例的查询最好带上相同的 ORDER BY 和 LIMIT 来减少数据传输。
+	Offset  int64          // Offset 是合并后跳过的行数，每个实例的查询需要自行取 Offset+Limit 行。
+	Limit   int64          // Limit 是合并后最多返回的行数，0 代表不限制。
+
+	Sum     []string // Sum 是需要求和的列，一般是 COUNT 或者 SUM 的结果，设置后所有 GroupBy 相同的行会合并成一行。
+	GroupBy []string // GroupBy 是合并时的分组列，仅在设置了 Sum 时有效，不设置则所有行合并成一行。
+
+	UseMaster   bool // UseMaster 表示查询走主库。
+	FailOnError bool // FailOnError 表示任何一个实例出错都返回错误，默认只有所有实例都出错时才返回错误。
+}
+
+// ScatterOrder 代表合并结果时的一个排序列。
+type ScatterOrder struct {
+	Column string // Column 是列名，与 Rows#Columns 返回的名字一致，不区分大小写。
+	Desc   bool   // Desc 表示降序。
+}
+
+// ShardError 代表跨实例查询时一个实例上发生的错误。
+type ShardError struct {
+	Shard int   // Shard 是实例在 Config.Instances 中的下标，没有设置 Instances 时是 0。
+	Err   error // Err 是实例返回的错误。
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("go-mysql: query fails on shard %v: %v", e.Shard, e.Err)
+}
+
+// Unwrap 返回实例返回的错误，Is 和 IsXxx 可以直接判断 *ShardError 包装的错误。
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ScatterRows 代表跨实例查询合并后的结果。
+// 与 Rows 不同，ScatterRows 已经读取了所有数据，不需要担心连接泄露。
+type ScatterRows struct {
+	Errors []*ShardError // Errors 是各个实例上发生的错误，为空代表所有实例都查询成功。
+
+	ctx     context.Context
+	columns []string
+	types   []string // types 是每一列的数据库类型，driver 不支持时为空字符串。
+	rows    [][]interface{}
+	current int
+}
+
+// Scatter 在所有实例上并发执行同一个查询，并按照 opts 合并结果。
+// 如果设置了 Config.Instances，会查询所有实例，否则只查询默认实例。
+// 迁移模式下只会查询旧分片。
+func (f *Factory) Scatter(ctx context.Context, opts *ScatterOptions, query string, args ...interface{}) (rows *ScatterRows, err error) {
+	if f.unavailable {
+		return nil, errors.New("go-mysql: factory is not initialized")
+	}
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	conn := f.conn()
+
+	if conn == nil {
+		return nil, errors.New("go-mysql: factory is not connected")
+	}
+
+	if opts == nil {
+		opts = &ScatterOptions{}
+	}
+
+	instances := conn.Instances
+
+	if len(instances) == 0 {
+		if conn.Master == nil {
+			return nil, errors.New("go-mysql: no default master DSN")
+		}
+
+		instances = []*dbInstance{&conn.dbInstance}
+	}
+
+	results := make([]scatterResult, len(instances))
+	var wg sync.WaitGroup
+
+	for i, ins := range instances {
+		wg.Add(1)
+		go func(res *scatterResult, ins *dbInstance) {
+			defer wg.Done()
+			res.Query(ctx, ins, opts.UseMaster, query, args)
+		}(&results[i], ins)
+	}
+
+	wg.Wait()
+	rows = &ScatterRows{
+		ctx: ctx,
+	}
+
+	for i, res := range results {
+		if res.Err == nil && rows.columns != nil && len(res.Columns) != len(rows.columns) {
+			res.Err = fmt.Errorf("go-mysql: expect %v columns but got %v", len(rows.columns), len(res.Columns))
+		}
+
+		if res.Err != nil {
+			log.Errorf(ctx, "err=%v||shard=%v||query=%v||go-mysql: fail to query shard", res.Err, i, query)
+			rows.Errors = append(rows.Errors, &ShardError{
+				Shard: i,
+				Err:   res.Err,
+			})
+			continue
+		}
+
+		if rows.columns == nil {
+			rows.columns = res.Columns
+			rows.types = res.Types
+		}
+
+		rows.rows = append(rows.rows, res.Rows...)
+	}
+
+	if len(rows.Errors) == len(results) || len(rows.Errors) > 0 && opts.FailOnError {
+		return nil, rows.Errors[0]
+	}
+
+	if err = rows.merge(opts); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type scatterResult struct {
+	Columns []string
+	Types   []string
+	Rows    [][]interface{}
+	Err     error
+}
+
+func (res *scatterResult) Query(ctx context.Context, ins *dbInstance, useMaster bool, query string, args []interface{}) {
+	mysql := newMySQL(ctx, ins)
+
+	if useMaster {
+		mysql = mysql.UseMaster()
+	}
+
+	rs, err := mysql.Query(query, args...)
+
+	if err != nil {
+		res.Err = err
+		return
+	}
+
+	types, err := rs.ColumnTypes()
+
+	if err != nil {
+		rs.Close()
+		res.Err = err
+		return
+	}
+
+	for _, t := range types {
+		res.Types = append(res.Types, t.DatabaseTypeName())
+	}
+
+	res.Columns, res.Rows, res.Err = readValues(rs)
+}
+
+// readValues 读取 rs 中所有的结果并关闭 rs，每一行都是 Scan 到 *interface{} 中的值，可以用 convertAssign 转换。
+func readValues(rs *Rows) (columns []string, rows [][]interface{}, err error) {
+	defer rs.Close()
+
+	if columns, err = rs.Columns(); err != nil {
+		return
+	}
+
+	// 这里不调用 rs.Next，避免重复统计 selected rows。
+	for rs.rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(values))
+
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err = rs.Scan(dest...); err != nil {
+			return
+		}
+
+		rows = append(rows, values)
+	}
+
+	err = rs.Err()
+	return
+}
+
+func (rs *ScatterRows) column(name string) (int, error) {
+	for i, col := range rs.columns {
+		if strings.EqualFold(col, name) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("go-mysql: unknown column `%v` in scatter options", name)
+}
+
+func (rs *ScatterRows) merge(opts *ScatterOptions) (err error) {
+	if len(opts.Sum) > 0 {
+		if err = rs.aggregate(opts.Sum, opts.GroupBy); err != nil {
+			return
+		}
+	}
+
+	if len(opts.OrderBy) > 0 {
+		indice := make([]int, len(opts.OrderBy))
+		numeric := make([]bool, len(opts.OrderBy))
+
+		for i, order := range opts.OrderBy {
+			if indice[i], err = rs.column(order.Column); err != nil {
+				return
+			}
+
+			numeric[i] = rs.numeric(indice[i])
+		}
+
+		sort.SliceStable(rs.rows, func(i, j int) bool {
+			for k, order := range opts.OrderBy {
+				c := compareValues(rs.rows[i][indice[k]], rs.rows[j][indice[k]], numeric[k])
+
+				if c == 0 {
+					continue
+				}
+
+				return (c < 0) != order.Desc
+			}
+
+			return false
+		})
+	}
+
+	if opts.Offset > 0 {
+		if opts.Offset >= int64(len(rs.rows)) {
+			rs.rows = nil
+		} else {
+			rs.rows = rs.rows[opts.Offset:]
+		}
+	}
+
+	if opts.Limit > 0 && opts.Limit < int64(len(rs.rows)) {
+		rs.rows = rs.rows[:opts.Limit]
+	}
+
+	return
+}
+
+// aggregate 将 groupBy 相同的行合并成一行，sum 中的列求和，其他列使用第一行的值。
+func (rs *ScatterRows) aggregate(sum, groupBy []string) error {
+	sumIndice := make([]int, len(sum))
+	groupIndice := make([]int, len(groupBy))
+	var err error
+
+	for i, col := range sum {
+		if sumIndice[i], err = rs.column(col); err != nil {
+			return err
+		}
+	}
+
+	for i, col := range groupBy {
+		if groupIndice[i], err = rs.column(col); err != nil {
+			return err
+		}
+	}
+
+	groups := map[string][]interface{}{}
+	var merged [][]interface{}
+
+	for _, row := range rs.rows {
+		keys := make([]string, len(groupIndice))
+
+		for i, idx := range groupIndice {
+			if row[idx] == nil {
+				keys[i] = "\x00"
+			} else {
+				keys[i] = strconv.Quote(asString(row[idx]))
+			}
+		}
+
+		key := strings.Join(keys, ",")
+		group, ok := groups[key]
+
+		if !ok {
+			groups[key] = row
+			merged = append(merged, row)
+			continue
+		}
+
+		for i, idx := range sumIndice {
+			if group[idx], err = addValues(group[idx], row[idx]); err != nil {
+				return fmt.Errorf("go-mysql: fail to sum column `%v`: %v", sum[i], err)
+			}
+		}
+	}
+
+	// 所有实例都没有数据时，COUNT/SUM 依然应该返回一行。
+	if len(merged) == 0 && len(groupBy) == 0 {
+		row := make([]interface{}, len(rs.columns))
+
+		for _, idx := range sumIndice {
+			row[idx] = int64(0)
+		}
+
+		merged = append(merged, row)
+	}
+
+	rs.rows = merged
+	return nil
+}
+
+// parseNumber 将 v 转换成数字，整数返回 int64，其他返回 float64。
+func parseNumber(v interface{}) (interface{}, bool) {
+	switch n := v.(type) {
+	case int64, float64:
+		return n, true
+	case []byte, string:
+		s := asString(n)
+
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, true
+		}
+
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+func addValues(a, b interface{}) (interface{}, error) {
+	if b == nil {
+		return a, nil
+	}
+
+	nb, ok := parseNumber(b)
+
+	if !ok {
+		return nil, fmt.Errorf("go-mysql: value %v is not a number", asString(b))
+	}
+
+	if a == nil {
+		return nb, nil
+	}
+
+	na, ok := parseNumber(a)
+
+	if !ok {
+		return nil, fmt.Errorf("go-mysql: value %v is not a number", asString(a))
+	}
+
+	ia, aInt := na.(int64)
+	ib, bInt := nb.(int64)
+
+	if aInt && bInt {
+		return ia + ib, nil
+	}
+
+	return toFloat(na) + toFloat(nb), nil
+}
+
+func toFloat(n interface{}) float64 {
+	if i, ok := n.(int64); ok {
+		return float64(i)
+	}
+
+	return n.(float64)
+}
+
+// numeric 判断第 idx 列是否按照数字排序。
+// 每一列只判断一次，这样同一列的值总是用同一种方式比较，排序结果才是确定的。
+func (rs *ScatterRows) numeric(idx int) bool {
+	if idx < len(rs.types) && rs.types[idx] != "" {
+		return isNumericType(rs.types[idx])
+	}
+
+	// driver 没有提供列类型时，只有所有值都是数字才按照数字排序。
+	for _, row := range rs.rows {
+		if row[idx] == nil {
+			continue
+		}
+
+		if _, ok := parseNumber(row[idx]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareValues 比较同一列的两个值的大小，与 MySQL 一样 NULL 最小。
+// numeric 为 true 时按照数字比较，否则时间按照时间比较，其他值按照字节比较，
+// 与 MySQL 使用 binary 排序规则时的结果一致。
+func compareValues(a, b interface{}, numeric bool) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	if numeric {
+		na, aok := parseNumber(a)
+		nb, bok := parseNumber(b)
+
+		// 数字列里不应该出现不是数字的值，万一出现则排在所有数字后面，保证比较结果依然可以传递。
+		switch {
+		case aok && bok:
+			return compareNumbers(na, nb)
+		case aok:
+			return -1
+		case bok:
+			return 1
+		}
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+
+			return 0
+		}
+	}
+
+	return bytes.Compare([]byte(asString(a)), []byte(asString(b)))
+}
+
+func compareNumbers(na, nb interface{}) int {
+	ia, aInt := na.(int64)
+	ib, bInt := nb.(int64)
+
+	if aInt && bInt {
+		switch {
+		case ia < ib:
+			return -1
+		case ia > ib:
+			return 1
+		}
+
+		return 0
+	}
+
+	fa, fb := toFloat(na), toFloat(nb)
+
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+
+	return 0
+}
+
+// Close 释放资源。
+func (rs *ScatterRows) Close() error {
+	rs.rows = nil
+	return nil
+}
+
+// Columns 返回所有列名。
+func (rs *ScatterRows) Columns() ([]string, error) {
+	return rs.columns, nil
+}
+
+// Err 返回当前的错误，由于所有数据都已经读取完毕，这里永远返回 nil。
+// 各个实例上的错误见 ScatterRows.Errors。
+func (rs *ScatterRows) Err() error {
+	return nil
+}
+
+// Len 返回合并后的总行数。
+func (rs *ScatterRows) Len() int {
+	return len(rs.rows)
+}
+
+// Next 查询下一条结果，如果已经没有更多结果，返回 false。
+func (rs *ScatterRows) Next() bool {
+	if rs.current >= len(rs.rows) {
+		return false
+	}
+
+	rs.current++
+	statsForSelectedRows(rs.ctx, 1)
+	return true
+}
+
+// Scan 将当前行的数据设置到 dest 里面。
+func (rs *ScatterRows) Scan(dest ...interface{}) error {
+	if rs.current == 0 || rs.current > len(rs.rows) {
+		return errors.New("go-mysql: Scan called without calling Next")
+	}
+
+	row := rs.rows[rs.current-1]
+
+	if len(dest) != len(row) {
+		return fmt.Errorf("go-mysql: expected %v destination arguments in Scan, not %v", len(row), len(dest))
+	}
+
+	for i, v := range row {
+		if err := convertAssign(dest[i], v); err != nil {
+			return fmt.Errorf("go-mysql: fail to scan column %v: %v", rs.columns[i], err)
+		}
+	}
+
+	return nil
+}
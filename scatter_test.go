@@ -0,0 +1,152 @@
+package mysql
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/huandu/go-assert"
+)
+
+func memShardFactory(t *testing.T, shards int) *Factory {
+	c := &Config{
+		Mod: int64(shards),
+	}
+
+	for i := 0; i < shards; i++ {
+		name := testDB + "_shard" + strconv.Itoa(i)
+		memdb.Drop(name)
+		c.Instances = append(c.Instances, ConfigInstance{
+			DSN:     memdb.Scheme + name,
+			Buckets: []int64{int64(i)},
+		})
+	}
+
+	f := NewFactory(c)
+
+	if err := f.Conn(context.Background()); err != nil {
+		t.Fatalf("fail to connect memdb. [err:%v]", err)
+	}
+
+	return f
+}
+
+func TestScatter(t *testing.T) {
+	a := assert.New(t)
+	f := memShardFactory(t, 3)
+	defer f.Close()
+	ctx := context.Background()
+
+	for i := int64(0); i < 3; i++ {
+		initTable(WithIndex(ctx, i), t, f, "scatter", "id BIGINT PRIMARY KEY", "kind VARCHAR(16) NOT NULL", "score INT NOT NULL")
+	}
+
+	for id := int64(1); id <= 9; id++ {
+		m := f.New(WithIndex(ctx, id))
+		a.NilError(m.Exec("INSERT INTO scatter VALUES (?, ?, ?)", id, []string{"a", "b"}[id%2], id*10))
+	}
+
+	// 合并排序并分页。
+	rows, err := f.Scatter(ctx, &ScatterOptions{
+		OrderBy: []ScatterOrder{{Column: "score", Desc: true}},
+		Offset:  1,
+		Limit:   3,
+	}, "SELECT id, score FROM scatter ORDER BY score DESC LIMIT 4")
+	a.NilError(err)
+	a.Equal(rows.Len(), 3)
+	var ids []int64
+
+	for rows.Next() {
+		var id, score int64
+		a.NilError(rows.Scan(&id, &score))
+		ids = append(ids, id)
+	}
+
+	a.Equal(ids, []int64{8, 7, 6})
+
+	// 汇总 COUNT 和 SUM。
+	var cnt, sum int64
+	rows, err = f.Scatter(ctx, &ScatterOptions{
+		Sum: []string{"cnt", "total"},
+	}, "SELECT COUNT(*) AS cnt, SUM(score) AS total FROM scatter")
+	a.NilError(err)
+	a.Assert(rows.Next())
+	a.NilError(rows.Scan(&cnt, &sum))
+	a.Equal(cnt, int64(9))
+	a.Equal(sum, int64(450))
+	a.Assert(!rows.Next())
+
+	rows, err = f.Scatter(ctx, &ScatterOptions{
+		Sum:     []string{"cnt"},
+		GroupBy: []string{"kind"},
+		OrderBy: []ScatterOrder{{Column: "kind"}},
+	}, "SELECT kind, COUNT(*) AS cnt FROM scatter GROUP BY kind")
+	a.NilError(err)
+	counts := map[string]int{}
+
+	for rows.Next() {
+		var kind string
+		var cnt int
+		a.NilError(rows.Scan(&kind, &cnt))
+		counts[kind] = cnt
+	}
+
+	a.Equal(counts, map[string]int{"a": 4, "b": 5})
+
+	// 单个实例出错不影响其他实例的结果，除非设置了 FailOnError。
+	a.NilError(f.New(WithIndex(ctx, 1)).Exec("DROP TABLE scatter"))
+	rows, err = f.Scatter(ctx, nil, "SELECT id FROM scatter")
+	a.NilError(err)
+	a.Equal(rows.Len(), 6)
+	a.Equal(len(rows.Errors), 1)
+	a.Equal(rows.Errors[0].Shard, 1)
+
+	_, err = f.Scatter(ctx, &ScatterOptions{FailOnError: true}, "SELECT id FROM scatter")
+	a.NonNilError(err)
+
+	_, err = f.Scatter(ctx, nil, "SELECT id FROM no_such_table")
+	a.NonNilError(err)
+}
+
+func TestScatterStringOrder(t *testing.T) {
+	a := assert.New(t)
+	f := memShardFactory(t, 3)
+	defer f.Close()
+	ctx := context.Background()
+
+	for i := int64(0); i < 3; i++ {
+		initTable(WithIndex(ctx, i), t, f, "scatter_string", "id BIGINT PRIMARY KEY", "code VARCHAR(16) NOT NULL")
+	}
+
+	codes := []string{"9", "a", "10", "b10", "2", "05"}
+
+	for i, code := range codes {
+		id := int64(i + 1)
+		a.NilError(f.New(WithIndex(ctx, id)).Exec("INSERT INTO scatter_string VALUES (?, ?)", id, code))
+	}
+
+	// 字符串列即使看起来像数字也按照字节排序，与每个实例上 ORDER BY 的结果一致。
+	rows, err := f.Scatter(ctx, &ScatterOptions{
+		OrderBy: []ScatterOrder{{Column: "code"}},
+		Offset:  1,
+		Limit:   4,
+	}, "SELECT id, code FROM scatter_string ORDER BY code LIMIT 5")
+	a.NilError(err)
+	var merged []string
+
+	for rows.Next() {
+		var id int64
+		var code string
+		a.NilError(rows.Scan(&id, &code))
+		merged = append(merged, code)
+	}
+
+	a.Equal(merged, []string{"10", "2", "9", "a"})
+
+	// 数字列依然按照数字排序。
+	a.Equal(compareValues([]byte("10"), []byte("9"), true), 1)
+	a.Equal(compareValues([]byte("10"), []byte("9"), false), -1)
+	a.Equal(compareValues([]byte("a"), []byte("9"), true), 1)
+	a.Equal(compareValues(nil, []byte("9"), true), -1)
+}
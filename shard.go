@@ -0,0 +1,295 @@
+package mysql
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 分片策略。
+const (
+	ShardingMod            = "mod"             // ShardingMod 使用 idx%mod 计算 bucket，再通过 bucket 选择实例。
+	ShardingConsistentHash = "consistent_hash" // ShardingConsistentHash 使用带虚拟节点的一致性哈希选择实例。
+	ShardingRange          = "range"           // ShardingRange 根据 idx 所在的区间选择实例。
+)
+
+// DefaultVirtualNodes 代表一致性哈希中每个实例默认的虚拟节点数，当前设置为 160。
+const DefaultVirtualNodes = 160
+
+// ShardFunc 是自定义的分片函数，根据 idx 返回实例在 Config.Instances 中的下标，n 是实例总数。
+// 返回不合法的下标时，Factory#New 会 panic。
+type ShardFunc func(idx int64, n int) int
+
+var shardFuncs = struct {
+	sync.RWMutex
+	funcs map[string]ShardFunc
+}{
+	funcs: make(map[string]ShardFunc),
+}
+
+// RegisterShardFunc 注册一个自定义的分片函数，
+// 在 Config.Sharding 中设置 name 即可使用这个函数来选择实例。
+// name 不能与内置的分片策略重名。
+func RegisterShardFunc(name string, fn ShardFunc) {
+	switch name {
+	case "", ShardingMod, ShardingConsistentHash, ShardingRange:
+		panic(fmt.Errorf("go-mysql: invalid shard func name `%v`", name))
+	}
+
+	if fn == nil {
+		panic(errors.New("go-mysql: shard func must not be nil"))
+	}
+
+	shardFuncs.Lock()
+	defer shardFuncs.Unlock()
+	shardFuncs.funcs[name] = fn
+}
+
+func lookupShardFunc(name string) ShardFunc {
+	shardFuncs.RLock()
+	defer shardFuncs.RUnlock()
+	return shardFuncs.funcs[name]
+}
+
+// sharder 根据 idx 选择实例，返回实例在 Config.Instances 中的下标，找不到时返回 -1。
+type sharder interface {
+	Shard(idx int64) int
+}
+
+// newSharder 检查分片配置是否合法，并创建对应的 sharder。
+func newSharder(sharding string, mod int64, virtualNodes int, instances []ConfigInstance) (sharder, error) {
+	if len(instances) == 0 {
+		return nil, nil
+	}
+
+	switch sharding {
+	case "", ShardingMod:
+		return newModSharder(mod, instances)
+	case ShardingConsistentHash:
+		return newHashRing(virtualNodes, instances)
+	case ShardingRange:
+		return newRangeSharder(instances)
+	}
+
+	fn := lookupShardFunc(sharding)
+
+	if fn == nil {
+		return nil, fmt.Errorf("go-mysql: unknown sharding strategy `%v`", sharding)
+	}
+
+	return funcSharder{
+		fn: fn,
+		n:  len(instances),
+	}, nil
+}
+
+type modSharder struct {
+	mod     int64
+	buckets map[int64]int
+}
+
+// newModSharder 创建取模分片。
+// 必须设置合法的 mod，并且 buckets 需要能覆盖 mod 所有情况。
+func newModSharder(mod int64, instances []ConfigInstance) (*modSharder, error) {
+	if mod <= 0 {
+		return nil, errors.New("go-mysql: mod should not be 0 when instances are set")
+	}
+
+	buckets := map[int64]int{}
+
+	for i, ins := range instances {
+		for _, b := range ins.Buckets {
+			if b >= mod {
+				return nil, fmt.Errorf("go-mysql: invalid bucket index %v which is larger than mod %v", b, mod)
+			}
+
+			if _, ok := buckets[b]; ok {
+				return nil, fmt.Errorf("go-mysql: bucket index %v is defined more than once", b)
+			}
+
+			buckets[b] = i
+		}
+	}
+
+	if int64(len(buckets)) != mod {
+		missing := []int64{}
+
+		for i := int64(0); i < mod; i++ {
+			if _, ok := buckets[i]; !ok {
+				missing = append(missing, i)
+			}
+		}
+
+		return nil, fmt.Errorf("go-mysql: indice of buckets in instances are missing %v", missing)
+	}
+
+	return &modSharder{
+		mod:     mod,
+		buckets: buckets,
+	}, nil
+}
+
+func (s *modSharder) Shard(idx int64) int {
+	if i, ok := s.buckets[idx%s.mod]; ok {
+		return i
+	}
+
+	return -1
+}
+
+// hashRing 是一致性哈希环，每个实例在环上有多个虚拟节点，
+// 增加或者删除实例时只会影响相邻虚拟节点上的 idx。
+type hashRing struct {
+	points    []uint64
+	instances []int
+}
+
+func newHashRing(virtualNodes int, instances []ConfigInstance) (*hashRing, error) {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	ring := &hashRing{}
+	names := map[string]struct{}{}
+	owners := map[uint64]int{}
+
+	for i, ins := range instances {
+		name := instanceName(ins)
+
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("go-mysql: instance name `%v` is defined more than once", name)
+		}
+
+		names[name] = struct{}{}
+
+		for v := 0; v < virtualNodes; v++ {
+			point := hashBytes([]byte(name + "#" + strconv.Itoa(v)))
+
+			// 哈希冲突的概率极低，发生时保留第一个实例，保证结果稳定。
+			if _, ok := owners[point]; ok {
+				continue
+			}
+
+			owners[point] = i
+			ring.points = append(ring.points, point)
+		}
+	}
+
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i] < ring.points[j]
+	})
+	ring.instances = make([]int, len(ring.points))
+
+	for i, point := range ring.points {
+		ring.instances[i] = owners[point]
+	}
+
+	return ring, nil
+}
+
+func (ring *hashRing) Shard(idx int64) int {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(idx))
+	key := hashBytes(buf[:])
+
+	i := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i] >= key
+	})
+
+	if i == len(ring.points) {
+		i = 0
+	}
+
+	return ring.instances[i]
+}
+
+// instanceName 返回实例在一致性哈希环上的名字，默认使用 DSN 中的地址和数据库名。
+func instanceName(ins ConfigInstance) string {
+	if ins.Name != "" {
+		return ins.Name
+	}
+
+	return dsnName(ins.DSN)
+}
+
+// hashBytes 使用 md5 计算哈希，与 ketama 等常见的一致性哈希实现一样，保证连续的 idx 也能均匀分布。
+func hashBytes(b []byte) uint64 {
+	sum := md5.Sum(b)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+type rangeSharder struct {
+	ranges []shardRange
+}
+
+type shardRange struct {
+	Start, End int64
+	Instance   int
+}
+
+// newRangeSharder 创建区间分片，所有区间必须首尾相接，不能重叠也不能有空隙。
+// 落在空隙里的 idx 要等到请求时才会发现没有对应的实例，因此在加载配置时就拒绝这样的配置。
+func newRangeSharder(instances []ConfigInstance) (*rangeSharder, error) {
+	s := &rangeSharder{}
+
+	for i, ins := range instances {
+		if len(ins.Ranges) == 0 {
+			return nil, fmt.Errorf("go-mysql: ranges are not set for instance `%v`", instanceName(ins))
+		}
+
+		for _, r := range ins.Ranges {
+			if r.Start >= r.End {
+				return nil, fmt.Errorf("go-mysql: invalid range [%v, %v)", r.Start, r.End)
+			}
+
+			s.ranges = append(s.ranges, shardRange{
+				Start:    r.Start,
+				End:      r.End,
+				Instance: i,
+			})
+		}
+	}
+
+	sort.Slice(s.ranges, func(i, j int) bool {
+		return s.ranges[i].Start < s.ranges[j].Start
+	})
+
+	for i := 1; i < len(s.ranges); i++ {
+		prev, r := s.ranges[i-1], s.ranges[i]
+
+		if r.Start < prev.End {
+			return nil, fmt.Errorf("go-mysql: range [%v, %v) overlaps with range [%v, %v)", r.Start, r.End, prev.Start, prev.End)
+		}
+
+		if r.Start > prev.End {
+			return nil, fmt.Errorf("go-mysql: idx in [%v, %v) is not covered by any range", prev.End, r.Start)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *rangeSharder) Shard(idx int64) int {
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].End > idx
+	})
+
+	if i == len(s.ranges) || s.ranges[i].Start > idx {
+		return -1
+	}
+
+	return s.ranges[i].Instance
+}
+
+type funcSharder struct {
+	fn ShardFunc
+	n  int
+}
+
+func (s funcSharder) Shard(idx int64) int {
+	return s.fn(idx, s.n)
+}
@@ -0,0 +1,125 @@
+package mysql
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/huandu/go-assert"
+)
+
+func TestShardConsistentHash(t *testing.T) {
+	a := assert.New(t)
+	instances := []ConfigInstance{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	ring, err := newHashRing(DefaultVirtualNodes, instances)
+	a.NilError(err)
+
+	const total = 10000
+	counts := make([]int, len(instances))
+	before := make([]int, total)
+
+	for i := range before {
+		before[i] = ring.Shard(int64(i))
+		counts[before[i]]++
+	}
+
+	for _, cnt := range counts {
+		a.Assert(cnt > total/5)
+	}
+
+	// 增加一个实例后，只有新实例分走的 idx 会变化。
+	ring, err = newHashRing(DefaultVirtualNodes, append(instances, ConfigInstance{Name: "d"}))
+	a.NilError(err)
+	moved := 0
+
+	for i, old := range before {
+		if shard := ring.Shard(int64(i)); shard != old {
+			a.Equal(shard, 3)
+			moved++
+		}
+	}
+
+	a.Assert(moved > total/8 && moved < total*3/8)
+
+	_, err = newHashRing(DefaultVirtualNodes, []ConfigInstance{{Name: "a"}, {Name: "a"}})
+	a.NonNilError(err)
+}
+
+func TestShardRange(t *testing.T) {
+	a := assert.New(t)
+	s, err := newRangeSharder([]ConfigInstance{
+		{Ranges: []ConfigRange{{Start: 0, End: 100}, {Start: 200, End: 300}}},
+		{Ranges: []ConfigRange{{Start: 100, End: 200}}},
+	})
+	a.NilError(err)
+
+	cases := map[int64]int{
+		-1: -1, 0: 0, 99: 0, 100: 1, 199: 1, 200: 0, 299: 0, 300: -1,
+	}
+
+	for idx, expected := range cases {
+		a.Use(&idx, &expected)
+		a.Equal(s.Shard(idx), expected)
+	}
+
+	_, err = newRangeSharder([]ConfigInstance{
+		{Ranges: []ConfigRange{{Start: 0, End: 100}}},
+		{Ranges: []ConfigRange{{Start: 50, End: 200}}},
+	})
+	a.NonNilError(err)
+
+	_, err = newRangeSharder([]ConfigInstance{{Ranges: []ConfigRange{{Start: 100, End: 100}}}})
+	a.NonNilError(err)
+
+	// 区间之间不能有空隙。
+	_, err = newRangeSharder([]ConfigInstance{
+		{Ranges: []ConfigRange{{Start: 0, End: 100}}},
+		{Ranges: []ConfigRange{{Start: 101, End: 200}}},
+	})
+	a.NonNilError(err)
+
+	f := NewFactory(&Config{
+		Sharding: ShardingRange,
+		Instances: []ConfigInstance{
+			{DSN: memdb.Scheme + testDB, Ranges: []ConfigRange{{Start: 0, End: 100}, {Start: 200, End: 300}}},
+		},
+	})
+	a.NonNilError(f.Conn(context.Background()))
+}
+
+func TestFactoryShardFunc(t *testing.T) {
+	a := assert.New(t)
+	RegisterShardFunc("test_last_digit", func(idx int64, n int) int {
+		return int(idx%10) % n
+	})
+
+	var instances []ConfigInstance
+
+	for i := 0; i < 3; i++ {
+		name := testDB + "_shard" + strconv.Itoa(i)
+		memdb.Drop(name)
+		instances = append(instances, ConfigInstance{DSN: memdb.Scheme + name})
+	}
+
+	f := NewFactory(&Config{
+		Instances: instances,
+		Sharding:  "test_last_digit",
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	conn := f.conn()
+
+	for idx := int64(0); idx < 20; idx++ {
+		m := f.New(WithIndex(ctx, idx))
+		a.Equal(m.ins, conn.Instances[int(idx%10)%3])
+	}
+
+	f = NewFactory(&Config{
+		Instances: instances,
+		Sharding:  "no_such_func",
+	})
+	a.NonNilError(f.Conn(ctx))
+}
@@ -0,0 +1,373 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/altstory/go-log"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 从库负载均衡策略。
+const (
+	BalanceRoundRobin = "round_robin" // BalanceRoundRobin 按照权重轮流使用从库。
+	BalanceLeastConn  = "least_conn"  // BalanceLeastConn 使用当前连接数与权重之比最小的从库。
+)
+
+// errNotReplicating 代表 SHOW SLAVE STATUS 没有返回任何结果，数据库没有在复制主库的数据。
+var errNotReplicating = errors.New("go-mysql: slave is not replicating from any master")
+
+// unknownLag 代表无法获得从库延迟，比如复制已经停止，这时候从库会被当做延迟过高。
+const unknownLag = time.Duration(math.MaxInt64)
+
+// slavePool 是一组从库，负责按照权重选择从库，并定期检查从库是否可用。
+// 当所有从库都不可用或者延迟过高时，读请求会降级到主库。
+type slavePool struct {
+	master    *sql.DB
+	slaves    []*slave
+	balance   string
+	maxLag    time.Duration
+	heartbeat string
+
+	counter uint64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type slave struct {
+	DSN    string
+	Name   string // Name 是从库的地址，用于日志和监控，不包含密码等敏感信息。
+	DB     *sql.DB
+	Weight int
+
+	healthy int32
+	lag     int64
+}
+
+func (s *slave) Healthy() bool {
+	return atomic.LoadInt32(&s.healthy) != 0
+}
+
+// Lag 返回最近一次检查时从库的复制延迟。
+func (s *slave) Lag() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.lag))
+}
+
+func (s *slave) setLag(lag time.Duration) {
+	atomic.StoreInt64(&s.lag, int64(lag))
+}
+
+// Available 判断从库是否可以接收读请求。
+func (s *slave) Available(maxLag time.Duration) bool {
+	return s.Healthy() && (maxLag <= 0 || s.Lag() <= maxLag)
+}
+
+func (s *slave) setHealthy(healthy bool) (changed bool) {
+	var v int32
+
+	if healthy {
+		v = 1
+	}
+
+	return atomic.SwapInt32(&s.healthy, v) != v
+}
+
+func newSlavePool(master *sql.DB, balance string, maxLag time.Duration, heartbeat string) *slavePool {
+	return &slavePool{
+		master:    master,
+		balance:   balance,
+		maxLag:    maxLag,
+		heartbeat: heartbeat,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (p *slavePool) add(dsn string, db *sql.DB, weight int, healthy bool) {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	s := &slave{
+		DSN:    dsn,
+		Name:   dsnName(dsn),
+		DB:     db,
+		Weight: weight,
+	}
+	s.setHealthy(healthy)
+	p.slaves = append(p.slaves, s)
+}
+
+// Pick 选择一个可用的从库，如果所有从库都不可用则返回主库。
+func (p *slavePool) Pick() *sql.DB {
+	if s := p.pick(); s != nil {
+		return s.DB
+	}
+
+	return p.master
+}
+
+func (p *slavePool) pick() *slave {
+	switch len(p.slaves) {
+	case 0:
+		return nil
+	case 1:
+		if s := p.slaves[0]; s.Available(p.maxLag) {
+			return s
+		}
+
+		return nil
+	}
+
+	if p.balance == BalanceLeastConn {
+		return p.pickLeastConn()
+	}
+
+	return p.pickRoundRobin()
+}
+
+func (p *slavePool) pickRoundRobin() *slave {
+	total := 0
+
+	for _, s := range p.slaves {
+		if s.Available(p.maxLag) {
+			total += s.Weight
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	n := int(atomic.AddUint64(&p.counter, 1) % uint64(total))
+
+	for _, s := range p.slaves {
+		if !s.Available(p.maxLag) {
+			continue
+		}
+
+		if n < s.Weight {
+			return s
+		}
+
+		n -= s.Weight
+	}
+
+	return nil
+}
+
+func (p *slavePool) pickLeastConn() (picked *slave) {
+	var min float64
+
+	for _, s := range p.slaves {
+		if !s.Available(p.maxLag) {
+			continue
+		}
+
+		load := float64(s.DB.Stats().InUse) / float64(s.Weight)
+
+		if picked == nil || load < min {
+			picked = s
+			min = load
+		}
+	}
+
+	return
+}
+
+// Check 检查所有从库是否可用，并更新可用状态。
+// 如果设置了最大延迟，同时会检查从库的复制延迟。
+func (p *slavePool) Check(ctx context.Context, timeout time.Duration) {
+	for _, s := range p.slaves {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := s.DB.PingContext(pingCtx)
+
+		if err == nil {
+			p.checkLag(pingCtx, s)
+		}
+
+		cancel()
+
+		if !s.setHealthy(err == nil) {
+			continue
+		}
+
+		if err != nil {
+			log.Errorf(ctx, "err=%v||slave=%v||go-mysql: slave is unavailable and ejected from read pool", err, s.Name)
+		} else {
+			log.Tracef(ctx, "slave=%v||go-mysql: slave is available again", s.Name)
+		}
+	}
+}
+
+// CheckLag 检查所有从库的复制延迟。
+func (p *slavePool) CheckLag(ctx context.Context, timeout time.Duration) {
+	for _, s := range p.slaves {
+		lagCtx, cancel := context.WithTimeout(ctx, timeout)
+		p.checkLag(lagCtx, s)
+		cancel()
+	}
+}
+
+func (p *slavePool) checkLag(ctx context.Context, s *slave) {
+	if p.maxLag <= 0 {
+		return
+	}
+
+	lag, err := p.measureLag(ctx, s.DB)
+
+	if err != nil {
+		log.Errorf(ctx, "err=%v||slave=%v||go-mysql: fail to measure slave lag", err, s.Name)
+		lag = unknownLag
+	}
+
+	old := s.Lag()
+	s.setLag(lag)
+	statsForSlaveLag(s.Name, lag)
+
+	if (old > p.maxLag) == (lag > p.maxLag) {
+		return
+	}
+
+	if lag > p.maxLag {
+		log.Warnf(ctx, "slave=%v||lag=%v||max_lag=%v||go-mysql: slave lags too much and is removed from read pool", s.Name, lag, p.maxLag)
+	} else {
+		log.Tracef(ctx, "slave=%v||lag=%v||max_lag=%v||go-mysql: slave catches up and is back to read pool", s.Name, lag, p.maxLag)
+	}
+}
+
+// measureLag 获取从库的复制延迟。
+// 如果设置了心跳表，通过心跳表中最新的 ts 计算延迟，否则使用 SHOW SLAVE STATUS 的 Seconds_Behind_Master。
+func (p *slavePool) measureLag(ctx context.Context, db *sql.DB) (lag time.Duration, err error) {
+	if p.heartbeat != "" {
+		var ts mysql.NullTime
+
+		if err = db.QueryRowContext(ctx, "SELECT MAX(ts) FROM "+p.heartbeat).Scan(&ts); err != nil {
+			return
+		}
+
+		if !ts.Valid {
+			return unknownLag, nil
+		}
+
+		lag = time.Since(ts.Time)
+
+		if lag < 0 {
+			lag = 0
+		}
+
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	cols, err := rows.Columns()
+
+	if err != nil {
+		return
+	}
+
+	// 没有配置复制或者复制被 RESET 的数据库不会返回任何结果，这样的从库数据可能非常旧，不能当做没有延迟。
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errNotReplicating
+		}
+
+		return
+	}
+
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err = rows.Scan(dest...); err != nil {
+		return
+	}
+
+	for i, col := range cols {
+		if !strings.EqualFold(col, "Seconds_Behind_Master") {
+			continue
+		}
+
+		// Seconds_Behind_Master 为 NULL 代表复制已经停止。
+		if values[i] == nil {
+			return unknownLag, nil
+		}
+
+		seconds, e := strconv.ParseInt(string(values[i]), 10, 64)
+
+		if e != nil {
+			err = e
+			return
+		}
+
+		lag = time.Duration(seconds) * time.Second
+		return
+	}
+
+	err = errors.New("go-mysql: missing Seconds_Behind_Master in SHOW SLAVE STATUS")
+	return
+}
+
+// Start 启动后台健康检查，每隔 interval 检查一次所有从库。
+func (p *slavePool) Start(interval time.Duration) {
+	if len(p.slaves) == 0 || interval <= 0 {
+		return
+	}
+
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ctx := context.Background()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.Check(ctx, interval)
+			}
+		}
+	}()
+}
+
+// Close 停止健康检查并关闭所有从库连接。
+func (p *slavePool) Close() (err error) {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+
+	if p.done != nil {
+		<-p.done
+	}
+
+	for _, s := range p.slaves {
+		if s.DB == p.master {
+			continue
+		}
+
+		if e := s.DB.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return
+}
@@ -0,0 +1,119 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/driver"
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/huandu/go-assert"
+)
+
+func TestSlavePoolWeight(t *testing.T) {
+	a := assert.New(t)
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		Slaves: []ConfigSlave{
+			{DSN: memdb.Scheme + testDB + "_slave1", Weight: 1},
+			{DSN: memdb.Scheme + testDB + "_slave2", Weight: 3},
+		},
+		SlaveCheckInterval: -1,
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	pool := f.conn().Slaves
+	counts := map[*sql.DB]int{}
+
+	for i := 0; i < 400; i++ {
+		counts[pool.Pick()]++
+	}
+
+	a.Equal(counts[pool.slaves[0].DB], 100)
+	a.Equal(counts[pool.slaves[1].DB], 300)
+}
+
+func TestSlavePoolHealthCheck(t *testing.T) {
+	a := assert.New(t)
+	f := NewFactory(&Config{
+		DSN:          memdb.Scheme + testDB,
+		DSNSlave:     memdb.Scheme + testDB + "_slave1",
+		Slaves:       []ConfigSlave{{DSN: memdb.Scheme + testDB + "_slave2"}},
+		SlaveBalance: BalanceLeastConn,
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	conn := f.conn()
+	pool := conn.Slaves
+	a.Equal(len(pool.slaves), 2)
+
+	// 关闭的连接会导致 ping 失败，模拟从库宕机。
+	pool.slaves[0].DB.Close()
+	pool.Check(ctx, time.Second)
+	a.Assert(!pool.slaves[0].Healthy())
+
+	for i := 0; i < 10; i++ {
+		a.Equal(pool.Pick(), pool.slaves[1].DB)
+	}
+
+	// 所有从库都不可用时降级到主库。
+	pool.slaves[1].DB.Close()
+	pool.Check(ctx, time.Second)
+	a.Equal(pool.Pick(), conn.Master)
+	a.Equal(f.New(ctx).db(false), conn.Master)
+}
+
+func TestSlavePoolLag(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	slaves := []string{testDB + "_lag_slave1", testDB + "_lag_slave2"}
+	heartbeat := func(name string, ts time.Time) {
+		memdb.Drop(name)
+		db, err := sql.Open(driver.Name, memdb.Scheme+name)
+		a.NilError(err)
+		defer db.Close()
+		a.NilError(db.Exec("CREATE TABLE heartbeat (id INT PRIMARY KEY, ts DATETIME NOT NULL)"))
+		a.NilError(db.Exec("INSERT INTO heartbeat VALUES (1, ?)", ts))
+	}
+	heartbeat(slaves[0], time.Now().Add(-time.Hour))
+	heartbeat(slaves[1], time.Now())
+
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		Slaves: []ConfigSlave{
+			{DSN: memdb.Scheme + slaves[0]},
+			{DSN: memdb.Scheme + slaves[1]},
+		},
+		SlaveCheckInterval:  -1,
+		MaxSlaveLag:         10 * time.Second,
+		SlaveHeartbeatTable: "heartbeat",
+	})
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	conn := f.conn()
+	pool := conn.Slaves
+	a.Assert(pool.slaves[0].Lag() > time.Minute)
+	a.Assert(pool.slaves[1].Lag() < time.Minute)
+
+	for i := 0; i < 10; i++ {
+		a.Equal(pool.Pick(), pool.slaves[1].DB)
+	}
+
+	// 所有从库延迟都过高时降级到主库。
+	a.NilError(pool.slaves[1].DB.Exec("UPDATE heartbeat SET ts = ?", time.Now().Add(-time.Hour)))
+	pool.CheckLag(ctx, time.Second)
+	a.Equal(pool.Pick(), conn.Master)
+
+	// 没有心跳表时使用 SHOW SLAVE STATUS，内存数据库没有复制任何主库，延迟未知，不能用于读请求。
+	pool.heartbeat = ""
+	pool.CheckLag(ctx, time.Second)
+	a.Equal(pool.slaves[0].Lag(), unknownLag)
+	a.Equal(pool.slaves[1].Lag(), unknownLag)
+	a.Equal(pool.Pick(), conn.Master)
+}
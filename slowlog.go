@@ -0,0 +1,346 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+// DefaultSlowQueryTopN 是每个实例默认保留的最慢的语句数。
+const DefaultSlowQueryTopN = 100
+
+// explainTimeout 是对慢查询执行 EXPLAIN 的超时时间。
+const explainTimeout = 3 * time.Second
+
+// SlowQuery 是一条归一化之后的慢查询的统计。
+type SlowQuery struct {
+	Instance string        // Instance 是实例主库的地址和数据库名。
+	Query    string        // Query 是归一化之后的语句，所有字面量都被替换成了 ?，IN 和 VALUES 列表被合并。
+	Count    int64         // Count 是这条语句成为慢查询的次数。
+	Total    time.Duration // Total 是这条语句所有慢查询的总执行时间。
+	Max      time.Duration // Max 是这条语句最长的执行时间。
+	Last     time.Time     // Last 是最后一次慢查询结束的时间。
+}
+
+// slowLog 记录一个实例上的慢查询。
+//
+// 执行时间超过 Threshold 的语句会以 warn 级别记录日志，日志中的语句是归一化之后的，不包含任何参数和字面量。
+// 按照 ExplainRate 采样的慢 SELECT 会在后台使用从库执行 EXPLAIN 并记录执行计划，同一时间最多执行一个 EXPLAIN。
+// 最慢的 TopN 条归一化语句会保留在内存中，可以通过 Factory#SlowQueries 查询。
+type slowLog struct {
+	Name        string
+	Threshold   time.Duration
+	ExplainRate float64
+	TopN        int
+
+	ins        *dbInstance
+	explaining int32 // explaining 表示是否有正在执行的 EXPLAIN。
+
+	mu      sync.Mutex
+	queries map[string]*SlowQuery
+}
+
+func newSlowLog(ins *dbInstance, config ConfigSlowQuery) *slowLog {
+	if config.Threshold <= 0 {
+		return nil
+	}
+
+	l := &slowLog{
+		Name:        ins.Name,
+		Threshold:   config.Threshold,
+		ExplainRate: config.ExplainRate,
+		TopN:        config.TopN,
+		ins:         ins,
+		queries:     map[string]*SlowQuery{},
+	}
+
+	if l.TopN <= 0 {
+		l.TopN = DefaultSlowQueryTopN
+	}
+
+	return l
+}
+
+// Record 记录一条从 start 开始执行的语句，如果执行时间超过 Threshold 则记录慢查询。
+func (l *slowLog) Record(ctx context.Context, query string, args []interface{}, start time.Time) {
+	if l == nil {
+		return
+	}
+
+	elapsed := time.Since(start)
+
+	if elapsed < l.Threshold {
+		return
+	}
+
+	normalized := normalizeQuery(query)
+	statsForSlowQuery(ctx, l.Name, normalized, len(args), elapsed)
+	l.add(normalized, elapsed)
+
+	if l.ExplainRate > 0 && isSelect(query) && rand.Float64() < l.ExplainRate {
+		l.explain(ctx, query, normalized, args)
+	}
+}
+
+func (l *slowLog) add(normalized string, elapsed time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sq := l.queries[normalized]
+
+	if sq == nil {
+		if len(l.queries) >= l.TopN {
+			// 已经满了，替换掉最长执行时间最短的语句，新语句更快时直接丢弃。
+			var fastest *SlowQuery
+
+			for _, q := range l.queries {
+				if fastest == nil || q.Max < fastest.Max {
+					fastest = q
+				}
+			}
+
+			if elapsed <= fastest.Max {
+				return
+			}
+
+			delete(l.queries, fastest.Query)
+		}
+
+		sq = &SlowQuery{
+			Instance: l.Name,
+			Query:    normalized,
+		}
+		l.queries[normalized] = sq
+	}
+
+	sq.Count++
+	sq.Total += elapsed
+	sq.Last = time.Now()
+
+	if elapsed > sq.Max {
+		sq.Max = elapsed
+	}
+}
+
+// explain 在后台使用从库执行 EXPLAIN，不影响当前请求。
+func (l *slowLog) explain(ctx context.Context, query, normalized string, args []interface{}) {
+	if !atomic.CompareAndSwapInt32(&l.explaining, 0, 1) {
+		return
+	}
+
+	db := l.ins.Master
+
+	if s := l.ins.Slaves.pick(); s != nil {
+		db = s.DB
+	}
+
+	args = append([]interface{}(nil), args...)
+
+	go func() {
+		defer atomic.StoreInt32(&l.explaining, 0)
+
+		explainCtx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+		defer cancel()
+
+		plan, err := explainQuery(explainCtx, db, query, args)
+
+		if err != nil {
+			log.Errorf(ctx, "err=%v||query=%v||go-mysql: fail to explain slow query", err, normalized)
+			return
+		}
+
+		log.Warnf(ctx, "query=%v||plan=%v||go-mysql: explain slow query", normalized, plan)
+	}()
+}
+
+// explainQuery 执行 EXPLAIN 并将结果格式化成一行文本，每一行结果用 ; 分隔，每一列的格式是 name=value。
+func explainQuery(ctx context.Context, db *sql.DB, query string, args []interface{}) (plan string, err error) {
+	rs, err := db.QueryContext(ctx, "EXPLAIN "+query, args...)
+
+	if err != nil {
+		return
+	}
+
+	columns, rows, err := readValues(&Rows{ctx: ctx, rows: rs})
+
+	if err != nil {
+		return
+	}
+
+	lines := make([]string, 0, len(rows))
+
+	for _, row := range rows {
+		fields := make([]string, 0, len(row))
+
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+
+			if v == nil {
+				v = "NULL"
+			}
+
+			fields = append(fields, fmt.Sprintf("%v=%v", columns[i], v))
+		}
+
+		lines = append(lines, strings.Join(fields, ","))
+	}
+
+	plan = strings.Join(lines, ";")
+	return
+}
+
+// Queries 返回当前记录的所有慢查询。
+func (l *slowLog) Queries() []SlowQuery {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queries := make([]SlowQuery, 0, len(l.queries))
+
+	for _, sq := range l.queries {
+		queries = append(queries, *sq)
+	}
+
+	return queries
+}
+
+// SlowQueries 返回所有实例上最慢的语句，按照最长执行时间从大到小排序，每个实例最多保留 Config.SlowQuery.TopN 条。
+// 重新建立连接（Factory#Conn、Factory#Reload）后会重新统计。
+func (f *Factory) SlowQueries() []SlowQuery {
+	conn := f.conn()
+
+	if conn == nil {
+		return nil
+	}
+
+	var queries []SlowQuery
+
+	for _, ins := range conn.instances() {
+		queries = append(queries, ins.SlowLog.Queries()...)
+	}
+
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Max > queries[j].Max
+	})
+	return queries
+}
+
+func isSelect(query string) bool {
+	query = strings.TrimSpace(query)
+	return len(query) >= 6 && strings.EqualFold(query[:6], "SELECT")
+}
+
+var (
+	reInList     = regexp.MustCompile(`(?i)\bIN \(\?(, \?)*\)`)
+	reValuesList = regexp.MustCompile(`(\(\?(, \?)*\))(, \(\?(, \?)*\))+`)
+)
+
+// normalizeQuery 将 query 中的字符串和数字字面量替换成 ?，合并连续的空白，
+// 并将 IN 列表合并成 IN (...)，多行 VALUES 合并成第一行加上 ...，这样参数个数不同的同一条语句会得到一样的结果。
+func normalizeQuery(query string) string {
+	buf := &strings.Builder{}
+	space := false
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+			continue
+		}
+
+		if space && buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+
+		space = false
+
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i)
+			buf.WriteByte('?')
+
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+
+			if end < 0 {
+				buf.WriteString(query[i:])
+				i = len(query)
+				break
+			}
+
+			buf.WriteString(query[i : i+end+2])
+			i += end + 2
+
+		case isDigit(c) && (i == 0 || !isIdentChar(query[i-1])):
+			for i < len(query) && (isIdentChar(query[i]) || query[i] == '.') {
+				i++
+			}
+
+			buf.WriteByte('?')
+
+		case c == ',':
+			// 统一逗号后面的空白，方便合并列表。
+			buf.WriteString(", ")
+			i++
+
+			for i < len(query) && (query[i] == ' ' || query[i] == '\t' || query[i] == '\n' || query[i] == '\r') {
+				i++
+			}
+
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+
+	normalized := buf.String()
+	normalized = reInList.ReplaceAllString(normalized, "IN (...)")
+	normalized = reValuesList.ReplaceAllString(normalized, "$1, ...")
+	return normalized
+}
+
+// skipQuoted 跳过从 start 开始的字符串字面量，支持 \ 转义和连续两个引号，返回字面量之后的位置。
+func skipQuoted(query string, start int) int {
+	quote := query[start]
+
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+
+			return i + 1
+		}
+	}
+
+	return len(query)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/altstory/go-runner"
+	"github.com/huandu/go-assert"
+)
+
+func TestSlowQuery(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		SlowQuery: ConfigSlowQuery{
+			Threshold:   20 * time.Millisecond,
+			ExplainRate: 1,
+			TopN:        2,
+		},
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+	stats := &runner.Stats{}
+	ctx := runner.WithStats(context.Background(), stats)
+	m := initTable(ctx, t, f, "slow_query", "id BIGINT PRIMARY KEY", "name VARCHAR(64)")
+	a.Equal(len(f.SlowQueries()), 0)
+
+	// 快的语句不记录。
+	_, err := m.Exec("INSERT INTO slow_query VALUES (?, ?), (?, ?)", 1, "a", 2, "b")
+	a.NilError(err)
+	a.Equal(statsValue(stats, mysqlSlowQueryStatsKey), 0)
+
+	// 参数和字面量不同的同一条语句会合并。
+	var v int64
+	a.NilError(m.QueryScalar(&v, "SELECT SLEEP(?)", 0.06))
+	a.NilError(m.QueryScalar(&v, "SELECT  SLEEP(0.06)"))
+	a.NilError(m.Transaction(nil, func(tx *Tx) error {
+		_, err := tx.Exec("UPDATE slow_query SET name = 'c' WHERE SLEEP(0.015) = 0")
+		return err
+	}))
+	a.Equal(statsValue(stats, mysqlSlowQueryStatsKey), 3)
+
+	queries := f.SlowQueries()
+	a.Equal(len(queries), 2)
+	a.Equal(queries[0].Query, "SELECT SLEEP(?)")
+	a.Equal(queries[0].Instance, memdb.Scheme+testDB)
+	a.Equal(queries[0].Count, int64(2))
+	a.Assert(queries[0].Max >= 60*time.Millisecond)
+	a.Assert(queries[0].Total >= 120*time.Millisecond)
+	a.Equal(queries[1].Query, "UPDATE slow_query SET name = ? WHERE SLEEP(?) = ?")
+	a.Equal(queries[1].Count, int64(1))
+
+	// 超过 TopN 时淘汰最快的语句，比所有语句都快的新语句直接丢弃。
+	l := f.conn().SlowLog
+	l.add("SELECT 1", time.Second)
+	queries = f.SlowQueries()
+	a.Equal(len(queries), 2)
+	a.Equal(queries[0].Query, "SELECT 1")
+	a.Equal(queries[1].Query, "SELECT SLEEP(?)")
+	l.add("SELECT 2", time.Millisecond)
+	a.Equal(len(f.SlowQueries()), 2)
+
+	// 没有设置阈值时不记录慢查询。
+	f = memFactory(t)
+	defer f.Close()
+	a.NilError(f.New(ctx).QueryScalar(&v, "SELECT SLEEP(0.03)"))
+	a.Equal(len(f.SlowQueries()), 0)
+}
+
+func TestExplainQuery(t *testing.T) {
+	a := assert.New(t)
+	f := memFactory(t)
+	defer f.Close()
+	ctx := context.Background()
+	m := initTable(ctx, t, f, "explain_query", "id BIGINT PRIMARY KEY")
+	_, err := m.Exec("INSERT INTO explain_query VALUES (1), (2)")
+	a.NilError(err)
+
+	plan, err := explainQuery(ctx, f.conn().Master, "SELECT id FROM explain_query WHERE id = ?", []interface{}{1})
+	a.NilError(err)
+	a.Equal(plan, "id=1,select_type=SIMPLE,table=explain_query,type=ALL,possible_keys=NULL,key=NULL,rows=2,Extra=Using where")
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		Query, Normalized string
+	}{
+		{"SELECT * FROM t WHERE id = 123", "SELECT * FROM t WHERE id = ?"},
+		{"SELECT  *\n\tFROM t WHERE name = 'it''s' AND x = \"a\\\"b\"", "SELECT * FROM t WHERE name = ? AND x = ?"},
+		{"SELECT * FROM t1 WHERE `col 1` = -1.5e3", "SELECT * FROM t1 WHERE `col 1` = -?"},
+		{"SELECT * FROM t WHERE id IN (1,2, 3)", "SELECT * FROM t WHERE id IN (...)"},
+		{"SELECT * FROM t WHERE id in (?, ?)", "SELECT * FROM t WHERE id IN (...)"},
+		{"INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'),(3,'z')", "INSERT INTO t (a, b) VALUES (?, ?), ..."},
+		{"INSERT INTO t VALUES (?, ?)", "INSERT INTO t VALUES (?, ?)"},
+	}
+
+	for _, c := range cases {
+		a.Use(&c)
+		a.Equal(normalizeQuery(c.Query), c.Normalized)
+	}
+}
@@ -2,6 +2,8 @@ package mysql
 
 import (
 	"context"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -11,14 +13,72 @@ import (
 )
 
 const (
-	mysqlReadStatsKey         = "mysql_read"
-	mysqlWriteStatsKey        = "mysql_write"
-	mysqlAffectedRowsStatsKey = "mysql_affected_rows"
-	mysqlSelectedRowsStatsKey = "mysql_selected_rows"
+	mysqlReadStatsKey            = "mysql_read"
+	mysqlWriteStatsKey           = "mysql_write"
+	mysqlAffectedRowsStatsKey    = "mysql_affected_rows"
+	mysqlSelectedRowsStatsKey    = "mysql_selected_rows"
+	mysqlSlaveLagStatsKey        = "mysql_slave_lag"
+	mysqlSlaveLagUnknownStatsKey = "mysql_slave_lag_unknown"
+	mysqlRetryStatsKey           = "mysql_retry"
+	mysqlTimeoutStatsKey         = "mysql_timeout"
+	mysqlCursorBatchStatsKey     = "mysql_cursor_batch"
+	mysqlCursorRowsStatsKey      = "mysql_cursor_rows"
+	mysqlKillQueryStatsKey       = "mysql_kill_query"
+	mysqlBreakerStatsKey         = "mysql_breaker"
+	mysqlBreakerOpenStatsKey     = "mysql_breaker_open"
+	mysqlBreakerRejectStatsKey   = "mysql_breaker_reject"
+	mysqlSlowQueryStatsKey       = "mysql_slow_query"
+	mysqlReadLatencyStatsKey     = "mysql_read_latency"
+	mysqlWriteLatencyStatsKey    = "mysql_write_latency"
+	mysqlReadProctimeStatsKey    = "mysql_read_proctime"
+	mysqlWriteProctimeStatsKey   = "mysql_write_proctime"
+	mysqlReadMaxStatsKey         = "mysql_read_proctime_max"
+	mysqlWriteMaxStatsKey        = "mysql_write_proctime_max"
+	mysqlErrorStatsKey           = "mysql_error"
 )
 
+// latencyBuckets 是执行时间直方图的上界，超过最后一个上界的请求记录在 inf 中。
+// 每个桶只统计落在 (上一个上界, 上界] 之间的请求，不是 Prometheus 那样的累计值，
+// 因为 go-metrics 的 AddForTag 同时会增加不带标签的总数，累计计数会让总数翻倍。
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// latencyBucketTags 是 latencyBuckets 对应的标签，最后一个是 inf。
+var latencyBucketTags = func() []string {
+	tags := make([]string, 0, len(latencyBuckets)+1)
+
+	for _, b := range latencyBuckets {
+		tags = append(tags, "le_"+b.String())
+	}
+
+	return append(tags, "inf")
+}()
+
 var mysqlMetrics struct {
 	Read, Write, AffectedRows, SelectedRows *metrics.Metric
+	SlaveLag, SlaveLagUnknown               *metrics.Metric
+	Retry                                   *metrics.Metric
+	Timeout                                 *metrics.Metric
+	CursorBatch, CursorRows                 *metrics.Metric
+	KillQuery                               *metrics.Metric
+	Breaker, BreakerOpen, BreakerReject     *metrics.Metric
+	SlowQuery                               *metrics.Metric
+	ReadLatency, WriteLatency               *metrics.Metric
+	ReadProctime, WriteProctime             *metrics.Metric
+	ReadMax, WriteMax                       *metrics.Metric
+	Error                                   *metrics.Metric
 }
 
 var metricsOnce sync.Once
@@ -41,19 +101,142 @@ func initMetrics() {
 			Category: mysqlSelectedRowsStatsKey,
 			Method:   metrics.Sum,
 		})
+		mysqlMetrics.SlaveLag = metrics.Define(&metrics.Def{
+			Category: mysqlSlaveLagStatsKey,
+			Method:   metrics.Maximum,
+		})
+		mysqlMetrics.SlaveLagUnknown = metrics.Define(&metrics.Def{
+			Category: mysqlSlaveLagUnknownStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.Retry = metrics.Define(&metrics.Def{
+			Category: mysqlRetryStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.Timeout = metrics.Define(&metrics.Def{
+			Category: mysqlTimeoutStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.CursorBatch = metrics.Define(&metrics.Def{
+			Category: mysqlCursorBatchStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.CursorRows = metrics.Define(&metrics.Def{
+			Category: mysqlCursorRowsStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.KillQuery = metrics.Define(&metrics.Def{
+			Category: mysqlKillQueryStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.Breaker = metrics.Define(&metrics.Def{
+			Category: mysqlBreakerStatsKey,
+			Method:   metrics.Maximum,
+		})
+		mysqlMetrics.BreakerOpen = metrics.Define(&metrics.Def{
+			Category: mysqlBreakerOpenStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.BreakerReject = metrics.Define(&metrics.Def{
+			Category: mysqlBreakerRejectStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.SlowQuery = metrics.Define(&metrics.Def{
+			Category: mysqlSlowQueryStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.ReadLatency = metrics.Define(&metrics.Def{
+			Category: mysqlReadLatencyStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.WriteLatency = metrics.Define(&metrics.Def{
+			Category: mysqlWriteLatencyStatsKey,
+			Method:   metrics.Sum,
+		})
+		mysqlMetrics.ReadProctime = metrics.Define(&metrics.Def{
+			Category: mysqlReadProctimeStatsKey,
+			Method:   metrics.Average,
+		})
+		mysqlMetrics.WriteProctime = metrics.Define(&metrics.Def{
+			Category: mysqlWriteProctimeStatsKey,
+			Method:   metrics.Average,
+		})
+		mysqlMetrics.ReadMax = metrics.Define(&metrics.Def{
+			Category: mysqlReadMaxStatsKey,
+			Method:   metrics.Maximum,
+		})
+		mysqlMetrics.WriteMax = metrics.Define(&metrics.Def{
+			Category: mysqlWriteMaxStatsKey,
+			Method:   metrics.Maximum,
+		})
+		mysqlMetrics.Error = metrics.Define(&metrics.Def{
+			Category: mysqlErrorStatsKey,
+			Method:   metrics.Sum,
+		})
 	})
 }
 
-func statsForRead(ctx context.Context, query string, start time.Time) {
+// statsForRead 记录一次读请求，master 表示请求是否发给了主库。
+func statsForRead(ctx context.Context, ins *dbInstance, master bool, query string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	label := ins.label(master)
 	runner.StatsFromContext(ctx).Add(mysqlReadStatsKey, 1)
-	mysqlMetrics.Read.Add(1)
-	log.Tracef(ctx, "query=%v||proctime=%.6f||go-mysql: query rows", query, time.Now().Sub(start).Seconds())
+	mysqlMetrics.Read.AddForTag(label, 1)
+	statsForLatency(mysqlMetrics.ReadLatency, mysqlMetrics.ReadProctime, mysqlMetrics.ReadMax, label, elapsed)
+	statsForError(label, err)
+	log.Tracef(ctx, "query=%v||instance=%v||proctime=%.6f||go-mysql: query rows", query, label, elapsed.Seconds())
 }
 
-func statsForWrite(ctx context.Context, query string, start time.Time) {
+// statsForWrite 记录一次写请求，写请求总是发给主库。
+func statsForWrite(ctx context.Context, ins *dbInstance, query string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	label := ins.label(true)
 	runner.StatsFromContext(ctx).Add(mysqlWriteStatsKey, 1)
-	mysqlMetrics.Write.Add(1)
-	log.Tracef(ctx, "query=%v||proctime=%.6f||go-mysql: execute query", query, time.Now().Sub(start).Seconds())
+	mysqlMetrics.Write.AddForTag(label, 1)
+	statsForLatency(mysqlMetrics.WriteLatency, mysqlMetrics.WriteProctime, mysqlMetrics.WriteMax, label, elapsed)
+	statsForError(label, err)
+	log.Tracef(ctx, "query=%v||instance=%v||proctime=%.6f||go-mysql: execute query", query, label, elapsed.Seconds())
+}
+
+// statsForLatency 记录执行时间，histogram 按照 latencyBuckets 分桶计数（非累计），标签是 label:le_上界，
+// proctime 和 max 分别是平均和最长执行时间，单位是微秒。
+func statsForLatency(histogram, proctime, max *metrics.Metric, label string, elapsed time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool {
+		return elapsed <= latencyBuckets[i]
+	})
+	histogram.AddForTag(label+":"+latencyBucketTags[i], 1)
+
+	us := int64(elapsed / time.Microsecond)
+	proctime.AddForTag(label, us)
+	max.AddForTag(label, us)
+}
+
+// statsForError 按照错误号记录出错的请求，标签是 label:错误号。
+// 不是 MySQL 错误时，错误号分别是 timeout、canceled、conn_lost 和 unknown。
+func statsForError(label string, err error) {
+	if err == nil {
+		return
+	}
+
+	mysqlMetrics.Error.AddForTag(label+":"+errorCode(err), 1)
+}
+
+// errorCode 返回 err 在 mysql_error 中的错误号，与 Is 一样会逐层检查被包装的错误。
+func errorCode(err error) string {
+	if num := ErrorNumber(err); num != 0 {
+		return strconv.Itoa(int(num))
+	}
+
+	switch {
+	case Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case Is(err, context.Canceled):
+		return "canceled"
+	case IsConnectionLost(err):
+		return "conn_lost"
+	}
+
+	return "unknown"
 }
 
 func statsForAffectedRows(ctx context.Context, value int64) {
@@ -65,3 +248,64 @@ func statsForSelectedRows(ctx context.Context, value int64) {
 	runner.StatsFromContext(ctx).Add(mysqlSelectedRowsStatsKey, int(value))
 	mysqlMetrics.SelectedRows.Add(value)
 }
+
+// statsForSlaveLag 记录从库的延迟，延迟未知时只记录在 mysql_slave_lag_unknown 中，
+// 不能混入 mysql_slave_lag，否则会被取最大值的统计方法吞掉。
+func statsForSlaveLag(slave string, lag time.Duration) {
+	if lag == unknownLag {
+		mysqlMetrics.SlaveLagUnknown.AddForTag(slave, 1)
+		return
+	}
+
+	mysqlMetrics.SlaveLag.AddForTag(slave, int64(lag/time.Second))
+}
+
+func statsForRetry(ctx context.Context, query string, attempt int, err error) {
+	runner.StatsFromContext(ctx).Add(mysqlRetryStatsKey, 1)
+	mysqlMetrics.Retry.Add(1)
+	log.Warnf(ctx, "err=%v||query=%v||attempt=%v||go-mysql: retry query on transient error", err, query, attempt)
+}
+
+func statsForTimeout(ctx context.Context, query string, err error) {
+	runner.StatsFromContext(ctx).Add(mysqlTimeoutStatsKey, 1)
+	mysqlMetrics.Timeout.Add(1)
+	log.Warnf(ctx, "err=%v||query=%v||go-mysql: query timeout", err, query)
+}
+
+func statsForCursor(ctx context.Context, table string, rows int) {
+	stats := runner.StatsFromContext(ctx)
+	stats.Add(mysqlCursorBatchStatsKey, 1)
+	stats.Add(mysqlCursorRowsStatsKey, rows)
+	mysqlMetrics.CursorBatch.AddForTag(table, 1)
+	mysqlMetrics.CursorRows.AddForTag(table, int64(rows))
+	log.Tracef(ctx, "table=%v||rows=%v||go-mysql: fetch cursor batch", table, rows)
+}
+
+func statsForKillQuery(ctx context.Context, name string, id int64, query string) {
+	runner.StatsFromContext(ctx).Add(mysqlKillQueryStatsKey, 1)
+	mysqlMetrics.KillQuery.AddForTag(name, 1)
+	log.Warnf(ctx, "conn_id=%v||query=%v||go-mysql: kill query after context is done", id, query)
+}
+
+// statsForBreakerState 记录熔断器的状态变化，mysql_breaker 是状态变化后的状态（BreakerState 的值）。
+func statsForBreakerState(ctx context.Context, name string, from, to BreakerState) {
+	mysqlMetrics.Breaker.AddForTag(name, int64(to))
+
+	if to == BreakerOpen {
+		mysqlMetrics.BreakerOpen.AddForTag(name, 1)
+	}
+
+	log.Warnf(ctx, "instance=%v||from=%v||to=%v||go-mysql: circuit breaker state is changed", name, from, to)
+}
+
+func statsForBreakerReject(ctx context.Context, name string) {
+	runner.StatsFromContext(ctx).Add(mysqlBreakerRejectStatsKey, 1)
+	mysqlMetrics.BreakerReject.AddForTag(name, 1)
+}
+
+// statsForSlowQuery 记录一条慢查询，query 是归一化之后的语句，日志中只记录参数个数。
+func statsForSlowQuery(ctx context.Context, name, query string, args int, elapsed time.Duration) {
+	runner.StatsFromContext(ctx).Add(mysqlSlowQueryStatsKey, 1)
+	mysqlMetrics.SlowQuery.AddForTag(name, 1)
+	log.Warnf(ctx, "instance=%v||query=%v||args=%v||proctime=%.6f||go-mysql: slow query", name, query, args, elapsed.Seconds())
+}
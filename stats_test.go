@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-metrics"
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+// useTestMetric 将 *m 替换成一个可以直接读取的指标，返回读取指标并恢复原来指标的函数。
+func useTestMetric(m **metrics.Metric, def *metrics.Def) (read func() map[string]int64) {
+	old := *m
+	value := metrics.NewValue(time.Now(), def)
+	*m = metrics.NewMetric(value)
+
+	return func() map[string]int64 {
+		*m = old
+		values := map[string]int64{}
+
+		for _, entry := range value.Read(time.Now()) {
+			values[entry.Tag] = entry.Value
+		}
+
+		return values
+	}
+}
+
+func TestStatsMetrics(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		Mod: 4,
+		Instances: []ConfigInstance{
+			{
+				DSN:     memdb.Scheme + testDB,
+				Buckets: []int64{0, 1, 2, 3},
+				Slaves: []ConfigSlave{
+					{DSN: memdb.Scheme + testDB},
+				},
+			},
+		},
+		SlaveCheckInterval: -1,
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+	ctx := WithIndex(context.Background(), 1)
+	m := initTable(ctx, t, f, "stats_metrics", "id BIGINT PRIMARY KEY")
+
+	readRead := useTestMetric(&mysqlMetrics.Read, &metrics.Def{Category: mysqlReadStatsKey, Method: metrics.Sum})
+	readWrite := useTestMetric(&mysqlMetrics.Write, &metrics.Def{Category: mysqlWriteStatsKey, Method: metrics.Sum})
+	readLatency := useTestMetric(&mysqlMetrics.ReadLatency, &metrics.Def{Category: mysqlReadLatencyStatsKey, Method: metrics.Sum})
+	readMax := useTestMetric(&mysqlMetrics.ReadMax, &metrics.Def{Category: mysqlReadMaxStatsKey, Method: metrics.Maximum})
+	readError := useTestMetric(&mysqlMetrics.Error, &metrics.Def{Category: mysqlErrorStatsKey, Method: metrics.Sum})
+
+	_, err := m.Exec("INSERT INTO stats_metrics VALUES (1)")
+	a.NilError(err)
+	_, err = m.Exec("INSERT INTO stats_metrics VALUES (1)")
+	a.Assert(IsDuplicateKey(err))
+
+	var v int64
+	a.NilError(m.QueryScalar(&v, "SELECT SLEEP(0.06)"))
+	a.NilError(m.UseMaster().QueryScalar(&v, "SELECT id FROM stats_metrics"))
+	a.NilError(m.Transaction(nil, func(tx *Tx) error {
+		return tx.QueryScalar(&v, "SELECT id FROM stats_metrics")
+	}))
+
+	memdb.Inject(testDB, 1, mysql.ErrInvalidConn)
+	_, err = f.New(ctx).Query("SELECT id FROM stats_metrics")
+	a.Assert(IsConnectionLost(err))
+
+	a.Equal(readWrite(), map[string]int64{
+		"":         2,
+		"0:master": 2,
+	})
+	a.Equal(readRead(), map[string]int64{
+		"":         4,
+		"0:master": 2,
+		"0:slave":  2,
+	})
+
+	latency := readLatency()
+	a.Equal(latency[""], int64(4))
+	a.Equal(latency["0:slave:le_100ms"], int64(1))
+
+	max := readMax()
+	a.Assert(max["0:slave"] >= int64(60*time.Millisecond/time.Microsecond))
+	a.Assert(max["0:master"] < max["0:slave"])
+
+	a.Equal(readError(), map[string]int64{
+		"":                  2,
+		"0:master:1062":     1,
+		"0:slave:conn_lost": 1,
+	})
+}
+
+func TestStatsLabels(t *testing.T) {
+	a := assert.New(t)
+	f := memFactory(t)
+	defer f.Close()
+	a.Equal(f.conn().label(true), "default:master")
+	a.Equal(f.conn().label(false), "default:slave")
+
+	a.Equal(errorCode(&mysql.MySQLError{Number: 1213}), "1213")
+	a.Equal(errorCode(context.DeadlineExceeded), "timeout")
+	a.Equal(errorCode(context.Canceled), "canceled")
+	a.Equal(errorCode(mysql.ErrInvalidConn), "conn_lost")
+	a.Equal(errorCode(&testWrappedError{&mysql.MySQLError{Number: 1213}}), "1213")
+	a.Equal(errorCode(&testWrappedError{context.DeadlineExceeded}), "timeout")
+	a.Equal(errorCode(&testCausedError{context.Canceled}), "canceled")
+	a.Equal(errorCode(ErrDeadlock), "unknown")
+
+	a.Equal(len(latencyBucketTags), len(latencyBuckets)+1)
+	a.Equal(latencyBucketTags[0], "le_1ms")
+	a.Equal(latencyBucketTags[len(latencyBuckets)], "inf")
+
+	// 未知的从库延迟不会混入 mysql_slave_lag。
+	readLag := useTestMetric(&mysqlMetrics.SlaveLag, &metrics.Def{Category: mysqlSlaveLagStatsKey, Method: metrics.Maximum})
+	readUnknown := useTestMetric(&mysqlMetrics.SlaveLagUnknown, &metrics.Def{Category: mysqlSlaveLagUnknownStatsKey, Method: metrics.Sum})
+	statsForSlaveLag("slave1", 2*time.Second)
+	statsForSlaveLag("slave2", unknownLag)
+	a.Equal(readLag(), map[string]int64{"": 2, "slave1": 2})
+	a.Equal(readUnknown(), map[string]int64{"": 1, "slave2": 1})
+}
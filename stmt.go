@@ -1,6 +1,8 @@
 package mysql
 
 // Stmt 代表一个准备好的语句，可以绑定参数并执行。
+// 默认情况下 Stmt 只是记住了 query，每次执行都会把 query 发给服务器；
+// 设置了 Config.StmtCacheSize 后，不在事务中的 Stmt 会使用连接池缓存的服务端预处理语句执行。
 type Stmt struct {
 	db    db
 	query string
@@ -15,7 +17,7 @@ type db interface {
 
 // Close 关闭这条语句并释放资源。
 func (s *Stmt) Close() error {
-	// 由于 Go 标准库的问题，这里并没有真正使用 Stmt，也没有建立连接，所以什么都不用做。
+	// 由于 Go 标准库的问题，Stmt 并不直接持有预处理语句，缓存的预处理语句由连接池统一管理，所以什么都不用做。
 	return nil
 }
 
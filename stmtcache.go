@@ -0,0 +1,162 @@
+package mysql
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/altstory/go-log"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 需要丢弃缓存的预处理语句的 MySQL 错误号。
+const (
+	errNeedReprepare      = 1615 // ER_NEED_REPREPARE
+	errUnknownStmtHandler = 1243 // ER_UNKNOWN_STMT_HANDLER
+)
+
+// stmtCache 是一个 *sql.DB 上的预处理语句缓存，超过 size 时淘汰最久没有使用的语句。
+// database/sql 会在语句第一次用到某个连接时在这个连接上预处理，连接断开重连后也会重新预处理，
+// 因此缓存只需要按照 query 保存 *sql.Stmt。
+// size 限制的是语句的种类数，服务端的预处理语句数最多是 size 乘以连接池的最大连接数，
+// 淘汰语句时 *sql.Stmt#Close 会关闭它在所有连接上的服务端句柄，详见 Config.StmtCacheMaxPrepared。
+type stmtCache struct {
+	db   *sql.DB
+	size int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type stmtEntry struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int  // refs 是正在使用这个语句的调用数，淘汰的语句要等到没有人使用时才关闭。
+	evicted bool // evicted 表示语句已经不在缓存里。
+}
+
+func newStmtCache(db *sql.DB, size int) *stmtCache {
+	return &stmtCache{
+		db:    db,
+		size:  size,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get 返回 query 对应的预处理语句，不在缓存里时会预处理并放入缓存。
+// 使用完语句后必须调用 release。预处理失败时返回 nil，调用者应该直接执行 query。
+func (c *stmtCache) Get(ctx context.Context, query string) (stmt *sql.Stmt, release func()) {
+	if entry := c.acquire(query); entry != nil {
+		return entry.stmt, func() { c.release(entry) }
+	}
+
+	prepared, err := c.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		log.Tracef(ctx, "err=%v||sql=%v||go-mysql: fail to prepare statement and fallback to text protocol", err, query)
+		return
+	}
+
+	entry := c.add(query, prepared)
+	return entry.stmt, func() { c.release(entry) }
+}
+
+func (c *stmtCache) acquire(query string) *stmtEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[query]
+
+	if !ok {
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*stmtEntry)
+	entry.refs++
+	return entry
+}
+
+func (c *stmtCache) add(query string, stmt *sql.Stmt) *stmtEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 并发预处理同一个语句时以先放入缓存的为准。
+	if elem, ok := c.items[query]; ok {
+		stmt.Close()
+		c.lru.MoveToFront(elem)
+		entry := elem.Value.(*stmtEntry)
+		entry.refs++
+		return entry
+	}
+
+	entry := &stmtEntry{
+		query: query,
+		stmt:  stmt,
+		refs:  1,
+	}
+	c.items[query] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.size {
+		c.evict(c.lru.Back())
+	}
+
+	return entry
+}
+
+func (c *stmtCache) release(entry *stmtEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+
+	if entry.evicted && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// evict 将 elem 移出缓存，调用者必须持有 c.mu。
+func (c *stmtCache) evict(elem *list.Element) {
+	entry := elem.Value.(*stmtEntry)
+	c.lru.Remove(elem)
+	delete(c.items, entry.query)
+	entry.evicted = true
+
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// Invalidate 在语句执行出错时判断缓存的语句是否已经失效，失效的语句会被移出缓存，下次使用时重新预处理。
+func (c *stmtCache) Invalidate(query string, err error) {
+	e, ok := err.(*mysql.MySQLError)
+
+	if !ok || e.Number != errNeedReprepare && e.Number != errUnknownStmtHandler {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[query]; ok {
+		c.evict(elem)
+	}
+}
+
+// Len 返回缓存的语句数。
+func (c *stmtCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Close 关闭所有缓存的语句。
+func (c *stmtCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back())
+	}
+}
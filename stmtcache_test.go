@@ -0,0 +1,156 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/huandu/go-assert"
+)
+
+func TestStmtCache(t *testing.T) {
+	a := assert.New(t)
+	const table = "test_stmt_cache"
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN:           memdb.Scheme + testDB,
+		StmtCacheSize: 2,
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+
+	mysql := initTable(ctx, t, f, table,
+		"id bigint(20) NOT NULL PRIMARY KEY",
+		"v bigint(20) NOT NULL",
+	)
+	conn := f.conn()
+	cache := conn.Stmts[conn.Master]
+
+	// 不通过 Stmt 执行的语句不会被缓存。
+	a.Equal(cache.Len(), 0)
+
+	insert, err := mysql.Prepare("INSERT INTO " + table + " VALUES (?, ?)")
+	a.NilError(err)
+
+	for i := 1; i <= 3; i++ {
+		a.NilError(insert.Exec(i, i*10))
+	}
+
+	a.Equal(cache.Len(), 1)
+
+	sum, err := mysql.Prepare("SELECT SUM(v) FROM " + table + " WHERE id <= ?")
+	a.NilError(err)
+	row, err := sum.QueryRow(2)
+	a.NilError(err)
+	var v int
+	a.NilError(row.Scan(&v))
+	a.Equal(v, 30)
+
+	// 超过缓存大小后淘汰最久没有使用的语句，正在使用的语句在用完之后才关闭。
+	stmt, release := cache.Get(ctx, insert.query)
+	a.Assert(stmt != nil)
+	row, err = sum.QueryRow(1)
+	a.NilError(err)
+	a.NilError(row.Scan(&v))
+	list, err := mysql.Prepare("SELECT id FROM " + table + " ORDER BY id")
+	a.NilError(err)
+	rows, err := list.Query()
+	a.NilError(err)
+	a.Equal(cache.Len(), 2)
+	_, ok := cache.items[insert.query]
+	a.Assert(!ok)
+
+	var ids []int
+
+	for rows.Next() {
+		var id int
+		a.NilError(rows.Scan(&id))
+		ids = append(ids, id)
+	}
+
+	a.NilError(rows.Close())
+	a.Equal(ids, []int{1, 2, 3})
+	a.NilError(stmt.Exec(4, 40))
+	release()
+	_, err = stmt.Exec(5, 50)
+	a.NonNilError(err)
+
+	// 语句需要重新预处理时移出缓存。
+	cache.Invalidate(list.query, &mysqldriver.MySQLError{Number: errNeedReprepare})
+	a.Equal(cache.Len(), 1)
+	cache.Invalidate(sum.query, &mysqldriver.MySQLError{Number: 1062})
+	a.Equal(cache.Len(), 1)
+
+	// 无法预处理的语句直接执行，不会放入缓存。
+	bad, err := mysql.Prepare("SELECT * FROM")
+	a.NilError(err)
+	_, err = bad.Query()
+	a.NonNilError(err)
+	a.Equal(cache.Len(), 1)
+
+	// 重新建立连接后使用新的缓存。
+	a.NilError(f.Conn(ctx))
+	a.Assert(f.conn().Stmts[f.conn().Master] != cache)
+}
+
+func TestStmtCacheHandles(t *testing.T) {
+	a := assert.New(t)
+	const name = "test_stmt_cache_handles"
+	memdb.Drop(name)
+	f := NewFactory(&Config{
+		DSN:                  memdb.Scheme + name,
+		MaxOpenConns:         2,
+		StmtCacheSize:        10,
+		StmtCacheMaxPrepared: 4,
+	})
+	ctx := context.Background()
+	a.NilError(f.Conn(ctx))
+	defer f.Close()
+	m := initTable(ctx, t, f, "stmt_cache_handles", "id BIGINT PRIMARY KEY")
+	cache := f.conn().Stmts[f.conn().Master]
+
+	// 每个语句最多在 2 个连接上预处理，缓存大小被限制为 4 / 2。
+	a.Equal(cache.size, 2)
+	a.Equal(memdb.PreparedStmts(name), 0)
+
+	// 同时占用两个连接执行同一个语句，这个语句会在两个连接上分别预处理。
+	stmt, err := m.Prepare("SELECT id FROM stmt_cache_handles WHERE id > ?")
+	a.NilError(err)
+	rows1, err := stmt.Query(0)
+	a.NilError(err)
+	rows2, err := stmt.Query(0)
+	a.NilError(err)
+	a.Equal(memdb.PreparedStmts(name), 2)
+	a.NilError(rows1.Close())
+	a.NilError(rows2.Close())
+
+	for i := 0; i < 5; i++ {
+		s, err := m.Prepare(fmt.Sprintf("SELECT id FROM stmt_cache_handles WHERE id = ? + %v", i))
+		a.NilError(err)
+		var ids []int64
+		a.NilError(s.QueryColumn(&ids, 1))
+
+		// 淘汰的语句会关闭它在所有连接上的服务端句柄。
+		a.Equal(cache.Len(), 2)
+		a.Assert(memdb.PreparedStmts(name) <= 4)
+	}
+
+	_, ok := cache.items[stmt.query]
+	a.Assert(!ok)
+	a.Equal(memdb.PreparedStmts(name), 2)
+
+	// 关闭缓存后所有句柄都被关闭。
+	cache.Close()
+	a.Equal(memdb.PreparedStmts(name), 0)
+
+	// 限制总数时必须限制连接数。
+	f2 := NewFactory(&Config{
+		DSN:                  memdb.Scheme + name,
+		StmtCacheSize:        10,
+		StmtCacheMaxPrepared: 4,
+	})
+	a.NonNilError(f2.Conn(ctx))
+}
@@ -0,0 +1,34 @@
+package mysql
+
+import (
+	"context"
+	"time"
+)
+
+// withQueryTimeout 返回执行一条语句使用的 ctx，超时时间优先使用 WithQueryTimeout 的设置，其次是 timeout。
+// 没有设置超时时间时直接返回 ctx，返回的 cancel 总是可以调用。
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if d, ok := queryTimeoutFromContext(ctx); ok {
+		timeout = d
+	}
+
+	return withTimeout(ctx, timeout)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// checkTimeout 判断 err 是否是由 outer 派生出来的 ctx 上设置的超时时间造成的，如果是则记录超时统计。
+// outer 本身已经结束时，超时属于调用者设置的 deadline，不计入统计。
+func checkTimeout(outer, ctx context.Context, query string, err error) {
+	if err == nil || ctx == outer || ctx.Err() != context.DeadlineExceeded || outer.Err() != nil {
+		return
+	}
+
+	statsForTimeout(ctx, query, err)
+}
@@ -0,0 +1,75 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altstory/go-mysql/internal/memdb"
+	"github.com/altstory/go-runner"
+	"github.com/huandu/go-assert"
+)
+
+func statsValue(stats *runner.Stats, key string) int {
+	for _, info := range stats.Info() {
+		if info.Key == key {
+			return info.Value.(int)
+		}
+	}
+
+	return 0
+}
+
+func TestTimeout(t *testing.T) {
+	a := assert.New(t)
+	memdb.Drop(testDB)
+	f := NewFactory(&Config{
+		DSN: memdb.Scheme + testDB,
+		Timeout: ConfigTimeout{
+			Read:  20 * time.Millisecond,
+			Write: 20 * time.Millisecond,
+			Tx:    50 * time.Millisecond,
+		},
+	})
+	a.NilError(f.Conn(context.Background()))
+	defer f.Close()
+	stats := &runner.Stats{}
+	ctx := runner.WithStats(context.Background(), stats)
+	m := f.New(ctx)
+
+	// 默认的读写超时。
+	var v int64
+	row, err := m.QueryRow("SELECT SLEEP(1)")
+	a.NilError(err)
+	a.Equal(row.Scan(&v), context.DeadlineExceeded)
+	_, err = m.Query("SELECT SLEEP(1)")
+	a.Equal(err, context.DeadlineExceeded)
+	_, err = m.Exec("SELECT SLEEP(1)")
+	a.Equal(err, context.DeadlineExceeded)
+	a.Equal(statsValue(stats, mysqlTimeoutStatsKey), 3)
+
+	// 通过 ctx 修改超时时间。
+	a.NilError(f.New(WithQueryTimeout(ctx, 0)).QueryScalar(&v, "SELECT SLEEP(0.05)"))
+	a.Equal(v, int64(0))
+	a.NilError(f.New(WithQueryTimeout(ctx, time.Second)).QueryScalar(&v, "SELECT SLEEP(0.05)"))
+	a.Equal(f.New(WithQueryTimeout(ctx, time.Millisecond)).QueryScalar(&v, "SELECT SLEEP(0.01)"), context.DeadlineExceeded)
+	a.Equal(statsValue(stats, mysqlTimeoutStatsKey), 4)
+
+	// 调用者自己的 deadline 不计入超时统计。
+	deadline, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	a.Equal(f.New(WithQueryTimeout(deadline, 0)).QueryScalar(&v, "SELECT SLEEP(1)"), context.DeadlineExceeded)
+	a.Equal(statsValue(stats, mysqlTimeoutStatsKey), 4)
+
+	// 事务超时后会被回滚。
+	tx, err := m.BeginTx(nil)
+	a.NilError(err)
+	a.NilError(tx.QueryScalar(&v, "SELECT SLEEP(0.01)"))
+	time.Sleep(60 * time.Millisecond)
+	a.Equal(tx.Commit(), context.DeadlineExceeded)
+	a.Equal(statsValue(stats, mysqlTimeoutStatsKey), 5)
+
+	tx, err = m.BeginTx(nil)
+	a.NilError(err)
+	a.NilError(tx.Commit())
+}
@@ -3,23 +3,180 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // Tx 代表一个事务。
+// 通过 Tx#Begin 可以在事务中开启嵌套事务，嵌套事务使用 SAVEPOINT 实现，与外层事务共用同一个连接。
 type Tx struct {
 	ctx context.Context
-	tx  *sql.Tx
+	ins *dbInstance
+	tx  sqlTx
+
+	mirror *mirrorTx // mirror 是迁移时另一套分片上的事务。
+
+	outer  context.Context    // outer 是开启事务时的 ctx，用来区分事务超时和调用者设置的 deadline。
+	cancel context.CancelFunc // cancel 用来释放事务超时的 ctx，没有设置超时时为 nil。
+
+	killer *killer   // killer 用来在 ctx 结束时 KILL 事务中正在执行的语句，没有开启 Config.KillOnCancel 时为 nil。
+	conn   *sql.Conn // conn 是事务所在的连接，事务结束后需要关闭。
+	connID int64     // connID 是 conn 的 CONNECTION_ID()。
+
+	parent    *Tx    // parent 是嵌套事务的外层事务，最外层事务为 nil。
+	savepoint string // savepoint 是嵌套事务对应的 SAVEPOINT 名字。
+	done      bool   // done 表示嵌套事务已经提交或者回滚。
+	nested    int    // nested 是最外层事务已经开启过的嵌套事务数，用来生成 SAVEPOINT 名字。
+	wrote     bool   // wrote 表示最外层事务中是否执行过写请求，只读事务提交后不需要让后续的读请求走主库。
+}
+
+// sqlConn 是可以执行语句的单个数据库连接，比如 *sql.Conn 和 *sql.Tx。
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// sqlTx 是 Tx 底层的事务，一般是 *sql.Tx，XA 事务分支使用的是 xaConn。
+type sqlTx interface {
+	sqlConn
+	Commit() error
+	Rollback() error
+}
+
+func newTx(ctx context.Context, ins *dbInstance, sqltx sqlTx, parent *Tx) *Tx {
+	tx := &Tx{
+		ins:    ins,
+		tx:     sqltx,
+		parent: parent,
+		outer:  ctx,
+	}
+
+	// ctx 里记录当前事务，这样 MySQL#Transaction 可以发现外层事务并开启嵌套事务。
+	tx.ctx = context.WithValue(ctx, keyMySQLTx, tx)
+	return tx
+}
+
+// Context 返回事务使用的 ctx，ctx 中已经通过 WithTx 绑定了当前事务。
+// 通过这个 ctx 调用 New 得到的 MySQL 实例会在当前事务中执行，调用 MySQL#Transaction 会开启嵌套事务。
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+// Begin 在当前事务中开启一个嵌套事务。
+// 嵌套事务的 Commit 只会释放 SAVEPOINT，修改要等到最外层事务提交时才会生效；
+// 嵌套事务的 Rollback 只会回滚到 SAVEPOINT，不影响外层事务在此之前的修改。
+func (tx *Tx) Begin() (nested *Tx, err error) {
+	root := tx.root()
+	root.nested++
+	name := "go_mysql_sp_" + strconv.Itoa(root.nested)
+
+	if err = tx.Savepoint(name); err != nil {
+		return
+	}
+
+	nested = newTx(tx.ctx, tx.ins, tx.tx, tx)
+	nested.savepoint = name
+	return
+}
+
+// Transaction 在嵌套事务中执行 fn，fn 返回 nil 时释放 SAVEPOINT，返回错误或者 panic 时回滚到 SAVEPOINT，
+// panic 会在回滚后继续抛出。嵌套事务失败不会回滚外层事务，由调用者决定是否继续。
+func (tx *Tx) Transaction(fn func(tx *Tx) error) (err error) {
+	nested, err := tx.Begin()
+
+	if err != nil {
+		return
+	}
+
+	done := false
+	defer func() {
+		if !done {
+			nested.Rollback()
+		}
+	}()
+
+	if err = fn(nested); err != nil {
+		return
+	}
+
+	done = true
+	return nested.Commit()
+}
+
+// Savepoint 在事务中设置一个名为 name 的 SAVEPOINT。
+func (tx *Tx) Savepoint(name string) (err error) {
+	if err = tx.ctx.Err(); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	// SAVEPOINT 不修改数据，不需要标记写请求。
+	_, err = tx.exec("SAVEPOINT " + quoteIdent(name))
+	return
+}
+
+// RollbackTo 回滚到名为 name 的 SAVEPOINT，之后设置的 SAVEPOINT 都会被删除。
+func (tx *Tx) RollbackTo(name string) (err error) {
+	_, err = tx.exec("ROLLBACK TO SAVEPOINT " + quoteIdent(name))
+	return
+}
+
+// ReleaseSavepoint 删除名为 name 的 SAVEPOINT，不影响事务中的任何修改。
+func (tx *Tx) ReleaseSavepoint(name string) (err error) {
+	if err = tx.ctx.Err(); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	_, err = tx.exec("RELEASE SAVEPOINT " + quoteIdent(name))
+	return
+}
+
+func (tx *Tx) root() *Tx {
+	for tx.parent != nil {
+		tx = tx.parent
+	}
+
+	return tx
 }
 
-// Commit 提交事务。
+// Commit 提交事务，如果是嵌套事务则释放对应的 SAVEPOINT。
 func (tx *Tx) Commit() (err error) {
 	if err = tx.ctx.Err(); err != nil {
+		checkTimeout(tx.root().outer, tx.ctx, "COMMIT", err)
 		tx.Rollback()
 		return
 	}
 
-	return tx.tx.Commit()
+	if tx.parent != nil {
+		if tx.done {
+			return sql.ErrTxDone
+		}
+
+		tx.done = true
+		return tx.ReleaseSavepoint(tx.savepoint)
+	}
+
+	defer tx.release()
+
+	if err = tx.tx.Commit(); err != nil {
+		if tx.mirror != nil {
+			tx.mirror.Rollback()
+		}
+
+		return
+	}
+
+	if tx.mirror != nil {
+		tx.mirror.Commit(tx.ctx)
+	}
+
+	if tracker := writeTrackerFromContext(tx.ctx); tracker != nil && tx.wrote {
+		tracker.Wrote(tx.ctx, tx.ins)
+	}
+
+	return
 }
 
 // Exec 执行一条修改语句并返回结果。
@@ -29,14 +186,36 @@ func (tx *Tx) Exec(query string, args ...interface{}) (result Result, err error)
 		return
 	}
 
+	// 语句失败时也可能已经修改了部分数据（比如非事务引擎），保守起见都算作写请求。
+	tx.root().wrote = true
+	return tx.exec(query, args...)
+}
+
+func (tx *Tx) exec(query string, args ...interface{}) (result Result, err error) {
+	if tx.root().mirror != nil && nonDeterministic(query) {
+		err = errNonDeterministic
+		return
+	}
+
+	ctx, cancel := withQueryTimeout(tx.ctx, tx.ins.Timeout.Write)
+	defer cancel()
+
 	start := time.Now()
-	sqlresult, err := tx.tx.ExecContext(tx.ctx, query, args...)
-	statsForWrite(tx.ctx, query, start)
+	stop := tx.watch(ctx, query)
+	sqlresult, err := tx.tx.ExecContext(ctx, query, args...)
+	stop(err)
+	statsForWrite(tx.ctx, tx.ins, query, start, err)
+	tx.ins.SlowLog.Record(tx.ctx, query, args, start)
 
 	if err != nil {
+		checkTimeout(tx.root().outer, ctx, query, err)
 		return
 	}
 
+	if root := tx.root(); root.mirror != nil && !root.mirror.Exec(tx.ctx, sqlresult, query, args...) {
+		root.mirror = nil
+	}
+
 	affected, _ := sqlresult.RowsAffected()
 
 	if affected > 0 {
@@ -68,17 +247,26 @@ func (tx *Tx) Query(query string, args ...interface{}) (rows *Rows, err error) {
 		return
 	}
 
+	ctx, cancel := withQueryTimeout(tx.ctx, tx.ins.Timeout.Read)
 	start := time.Now()
-	sqlrows, err := tx.tx.QueryContext(tx.ctx, query, args...)
-	statsForRead(tx.ctx, query, start)
+	stop := tx.watch(ctx, query)
+	sqlrows, err := tx.tx.QueryContext(ctx, query, args...)
+	statsForRead(tx.ctx, tx.ins, true, query, start, err)
+	tx.ins.SlowLog.Record(tx.ctx, query, args, start)
 
 	if err != nil {
+		stop(err)
+		cancel()
+		checkTimeout(tx.root().outer, ctx, query, err)
 		return
 	}
 
 	rows = &Rows{
-		ctx:  tx.ctx,
-		rows: sqlrows,
+		ctx:      tx.ctx,
+		rows:     sqlrows,
+		unmapped: tx.ins.Unmapped,
+		cancel:   cancel,
+		release:  stop,
 	}
 	return
 }
@@ -91,21 +279,71 @@ func (tx *Tx) QueryRow(query string, args ...interface{}) (row *Row, err error)
 		return
 	}
 
+	ctx, cancel := withQueryTimeout(tx.ctx, tx.ins.Timeout.Read)
 	start := time.Now()
-	sqlrow := tx.tx.QueryRowContext(tx.ctx, query, args...)
-	statsForRead(tx.ctx, query, start)
+	stop := tx.watch(ctx, query)
+	sqlrows, e := tx.tx.QueryContext(ctx, query, args...)
+	statsForRead(tx.ctx, tx.ins, true, query, start, e)
+	tx.ins.SlowLog.Record(tx.ctx, query, args, start)
+	checkTimeout(tx.root().outer, ctx, query, e)
 	row = &Row{
-		ctx: tx.ctx,
-		row: sqlrow,
+		ctx:      tx.ctx,
+		rows:     sqlrows,
+		err:      e,
+		unmapped: tx.ins.Unmapped,
+		cancel:   cancel,
+		release:  stop,
+	}
+
+	if e != nil {
+		stop(e)
+		row.release = nil
 	}
 	return
 }
 
-// Rollback 回滚事务。
+// Rollback 回滚事务，如果是嵌套事务则回滚到对应的 SAVEPOINT。
 func (tx *Tx) Rollback() error {
+	if tx.parent != nil {
+		if tx.done {
+			return sql.ErrTxDone
+		}
+
+		tx.done = true
+		return tx.RollbackTo(tx.savepoint)
+	}
+
+	defer tx.release()
+
+	if tx.mirror != nil {
+		tx.mirror.Rollback()
+	}
+
 	return tx.tx.Rollback()
 }
 
+// release 释放事务超时的 ctx，并且归还事务所在的连接。
+func (tx *Tx) release() {
+	if tx.cancel != nil {
+		tx.cancel()
+	}
+
+	if tx.conn != nil {
+		tx.conn.Close()
+	}
+}
+
+// watch 在 ctx 结束时 KILL 事务中正在执行的 query，语句结束后必须调用 stop，详见 killer#Watch。
+func (tx *Tx) watch(ctx context.Context, query string) (stop func(err error)) {
+	root := tx.root()
+
+	if root.killer == nil {
+		return func(error) {}
+	}
+
+	return root.killer.Watch(ctx, root.connID, query)
+}
+
 // Stmt 将一个指定的 stmt 纳入到事务 tx 的管理范围内，使其受到 commit 和 rollback 的控制。
 func (tx *Tx) Stmt(stmt *Stmt) (txStmt *Stmt, err error) {
 	if err = tx.ctx.Err(); err != nil {
@@ -119,3 +357,7 @@ func (tx *Tx) Stmt(stmt *Stmt) (txStmt *Stmt, err error) {
 	}
 	return
 }
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
@@ -0,0 +1,603 @@
+package mysql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/altstory/go-log"
+)
+
+const (
+	xaPrefix        = "gomysql-" // xaPrefix 是所有由 go-mysql 发起的 XA 事务的 gtrid 前缀。
+	xaMaxNodeLength = 32         // gtrid 最长 64 字节，节点名过长时需要截断。
+	xaLogExt        = ".xa"
+	xaNodeFile      = "node" // xaNodeFile 是恢复日志目录中保存节点名的文件。
+)
+
+var errXABranch = errors.New("go-mysql: XA branch can only be committed or rolled back by XA")
+
+// XA 代表一个跨多个实例的分布式事务。
+// XA 在每个涉及到的实例上开启一个事务分支，提交时使用 MySQL 的 XA 两阶段提交，保证所有分支同时提交或者同时回滚。
+//
+// 所有分支 PREPARE 成功后，XA 会先在本地的恢复日志中记录提交的决定，然后再逐个提交分支。
+// 如果提交过程中服务崩溃，重启后 Factory#RecoverXA 会根据恢复日志提交或者回滚所有悬挂的分支。
+type XA struct {
+	ctx      context.Context
+	factory  *Factory
+	gtrid    string
+	logDir   string
+	branches []*xaBranch
+	done     bool
+}
+
+type xaBranch struct {
+	ins   *dbInstance
+	conn  *sql.Conn
+	bqual string
+	tx    *Tx
+
+	ended    bool
+	prepared bool
+}
+
+// xaConn 是 XA 事务分支独占的连接，分支只能通过 XA#Commit 和 XA#Rollback 结束。
+type xaConn struct {
+	*sql.Conn
+}
+
+func (xaConn) Commit() error {
+	return errXABranch
+}
+
+func (xaConn) Rollback() error {
+	return errXABranch
+}
+
+// xaRecord 是恢复日志中的一条记录，代表 gtrid 对应的 XA 事务已经决定提交。
+type xaRecord struct {
+	Gtrid     string    `json:"gtrid"`
+	Instances []string  `json:"instances"`
+	Time      time.Time `json:"time"`
+}
+
+// BeginXA 开始一个 XA 事务，必须设置了 Config.XA.LogDir 才能使用。
+// 通过 XA#Tx 获取每个实例上的事务分支，最后调用 XA#Commit 或者 XA#Rollback 结束事务。
+func (f *Factory) BeginXA(ctx context.Context) (xa *XA, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	opts := f.options()
+
+	if opts.xaLogDir == "" {
+		err = errors.New("go-mysql: XA log dir is not configured (forgot to set `xa.log_dir`?)")
+		return
+	}
+
+	node, err := xaNodeName(opts)
+
+	if err != nil {
+		return
+	}
+
+	id := make([]byte, 8)
+
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+
+	xa = &XA{
+		ctx:     ctx,
+		factory: f,
+		gtrid:   xaGtridPrefix(node) + hex.EncodeToString(id),
+		logDir:  opts.xaLogDir,
+	}
+	return
+}
+
+// XATransaction 在 XA 事务中执行 fn，fn 返回 nil 时提交事务，返回错误或者 panic 时回滚事务，panic 会在回滚后继续抛出。
+func (f *Factory) XATransaction(ctx context.Context, fn func(xa *XA) error) (err error) {
+	xa, err := f.BeginXA(ctx)
+
+	if err != nil {
+		return
+	}
+
+	done := false
+	defer func() {
+		if !done {
+			xa.Rollback()
+		}
+	}()
+
+	if err = fn(xa); err != nil {
+		return
+	}
+
+	done = true
+	return xa.Commit()
+}
+
+// Tx 返回 idx 对应实例上的事务分支，第一次访问一个实例时会在这个实例上执行 XA START。
+// 同一个实例上的所有 idx 共用一个分支。分支上可以使用 Tx 的所有方法，包括嵌套事务，
+// 但不能调用分支的 Commit 和 Rollback。迁移模式下分支不会双写到另一套分片。
+func (xa *XA) Tx(idx int64) (tx *Tx, err error) {
+	if xa.done {
+		err = sql.ErrTxDone
+		return
+	}
+
+	if err = xa.ctx.Err(); err != nil {
+		return
+	}
+
+	ins := xa.factory.route(WithIndex(xa.ctx, idx)).ins
+
+	for _, b := range xa.branches {
+		if b.ins == ins {
+			return b.tx, nil
+		}
+	}
+
+	conn, err := ins.Master.Conn(xa.ctx)
+
+	if err != nil {
+		return
+	}
+
+	b := &xaBranch{
+		ins:   ins,
+		conn:  conn,
+		bqual: strconv.Itoa(len(xa.branches) + 1),
+	}
+
+	if err = xa.exec(xa.ctx, b, "START"); err != nil {
+		conn.Close()
+		return
+	}
+
+	b.tx = newTx(xa.ctx, ins, xaConn{conn}, nil)
+	xa.branches = append(xa.branches, b)
+	tx = b.tx
+	return
+}
+
+// Commit 提交 XA 事务。
+// 如果所有分支都 PREPARE 成功，事务就一定会提交，这时候即使有分支提交失败，
+// Commit 也只是返回错误，失败的分支会在下次调用 Factory#RecoverXA 时提交。
+func (xa *XA) Commit() (err error) {
+	if xa.done {
+		return sql.ErrTxDone
+	}
+
+	if err = xa.ctx.Err(); err != nil {
+		xa.Rollback()
+		return
+	}
+
+	xa.done = true
+	defer xa.close()
+
+	for _, b := range xa.branches {
+		if err = xa.exec(xa.ctx, b, "END"); err != nil {
+			xa.rollback()
+			return
+		}
+
+		b.ended = true
+	}
+
+	switch len(xa.branches) {
+	case 0:
+		return
+	case 1:
+		// 只有一个分支时不需要两阶段提交。
+		b := xa.branches[0]
+
+		if err = xa.exec(xa.ctx, b, "COMMIT", "ONE PHASE"); err != nil {
+			xa.rollback()
+			return
+		}
+
+		xa.wrote()
+		return
+	}
+
+	for _, b := range xa.branches {
+		if err = xa.exec(xa.ctx, b, "PREPARE"); err != nil {
+			xa.rollback()
+			return
+		}
+
+		b.prepared = true
+	}
+
+	if err = xa.writeLog(); err != nil {
+		log.Errorf(xa.ctx, "err=%v||gtrid=%v||go-mysql: fail to write XA log", err, xa.gtrid)
+		xa.rollback()
+		return
+	}
+
+	// 提交的决定已经记录在日志中，即使 ctx 已经取消也要把所有分支提交完。
+	ctx := context.Background()
+
+	for _, b := range xa.branches {
+		if e := xa.exec(ctx, b, "COMMIT"); e != nil {
+			log.Errorf(xa.ctx, "err=%v||gtrid=%v||bqual=%v||instance=%v||go-mysql: fail to commit prepared XA branch", e, xa.gtrid, b.bqual, b.ins.Name)
+
+			if err == nil {
+				err = fmt.Errorf("go-mysql: XA transaction %v is committed but some branches are not; they will be committed by RecoverXA: %v", xa.gtrid, e)
+			}
+		}
+	}
+
+	xa.wrote()
+
+	if err != nil {
+		return
+	}
+
+	if e := xa.removeLog(); e != nil {
+		log.Errorf(xa.ctx, "err=%v||gtrid=%v||go-mysql: fail to remove XA log", e, xa.gtrid)
+	}
+
+	return
+}
+
+// Rollback 回滚 XA 事务。
+func (xa *XA) Rollback() (err error) {
+	if xa.done {
+		return sql.ErrTxDone
+	}
+
+	xa.done = true
+	defer xa.close()
+	return xa.rollback()
+}
+
+func (xa *XA) rollback() (err error) {
+	ctx := context.Background()
+
+	for _, b := range xa.branches {
+		if !b.ended {
+			xa.exec(ctx, b, "END")
+		}
+
+		if e := xa.exec(ctx, b, "ROLLBACK"); e != nil {
+			log.Errorf(xa.ctx, "err=%v||gtrid=%v||bqual=%v||instance=%v||go-mysql: fail to rollback XA branch", e, xa.gtrid, b.bqual, b.ins.Name)
+
+			if err == nil {
+				err = e
+			}
+		}
+	}
+
+	return
+}
+
+func (xa *XA) close() {
+	for _, b := range xa.branches {
+		b.conn.Close()
+	}
+}
+
+func (xa *XA) wrote() {
+	tracker := writeTrackerFromContext(xa.ctx)
+
+	if tracker == nil {
+		return
+	}
+
+	for _, b := range xa.branches {
+		tracker.Wrote(xa.ctx, b.ins)
+	}
+}
+
+func (xa *XA) exec(ctx context.Context, b *xaBranch, action string, suffix ...string) error {
+	// XA 语句不支持预处理，xid 只能拼接在语句里，gtrid 和 bqual 都只包含安全的字符。
+	query := "XA " + action + " '" + xa.gtrid + "','" + b.bqual + "'"
+
+	if len(suffix) > 0 {
+		query += " " + strings.Join(suffix, " ")
+	}
+
+	start := time.Now()
+	_, err := b.conn.ExecContext(ctx, query)
+	statsForWrite(xa.ctx, b.ins, query, start, err)
+	return err
+}
+
+func (xa *XA) writeLog() error {
+	record := &xaRecord{
+		Gtrid: xa.gtrid,
+		Time:  time.Now(),
+	}
+
+	for _, b := range xa.branches {
+		record.Instances = append(record.Instances, b.ins.Name)
+	}
+
+	data, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(xa.logDir, 0755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，保证恢复时不会读到写了一半的日志。
+	path := xaLogPath(xa.logDir, xa.gtrid)
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (xa *XA) removeLog() error {
+	return os.Remove(xaLogPath(xa.logDir, xa.gtrid))
+}
+
+// RecoverXA 处理所有实例上由当前节点发起、处于 PREPARED 状态的悬挂 XA 事务，
+// 恢复日志中记录为提交的事务会被提交，其他的事务会被回滚。
+// 所有实例都处理成功后，已经完成的恢复日志会被删除。
+//
+// RecoverXA 会回滚当前节点正在提交中的 XA 事务，因此只能在当前节点还没有开始任何 XA 事务时调用，
+// 一般不需要直接调用，设置了 Config.XA.LogDir 时 Register 会在启动时自动调用。
+func (f *Factory) RecoverXA(ctx context.Context) (err error) {
+	opts := f.options()
+
+	if opts.xaLogDir == "" {
+		return errors.New("go-mysql: XA log dir is not configured (forgot to set `xa.log_dir`?)")
+	}
+
+	conn := f.conn()
+
+	if conn == nil {
+		return errors.New("go-mysql: factory is not connected")
+	}
+
+	node, err := xaNodeName(opts)
+
+	if err != nil {
+		return
+	}
+
+	committed, err := readXALogs(opts.xaLogDir)
+
+	if err != nil {
+		return
+	}
+
+	prefix := xaGtridPrefix(node)
+
+	for _, ins := range conn.instances() {
+		if e := recoverXA(ctx, ins, prefix, committed); e != nil {
+			log.Errorf(ctx, "err=%v||instance=%v||go-mysql: fail to recover XA transactions", e, ins.Name)
+
+			if err == nil {
+				err = e
+			}
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	for gtrid := range committed {
+		if e := os.Remove(xaLogPath(opts.xaLogDir, gtrid)); e != nil {
+			log.Errorf(ctx, "err=%v||gtrid=%v||go-mysql: fail to remove XA log", e, gtrid)
+		}
+	}
+
+	return
+}
+
+func recoverXA(ctx context.Context, ins *dbInstance, prefix string, committed map[string]bool) error {
+	type xid struct {
+		gtrid, bqual string
+	}
+
+	rows, err := ins.Master.QueryContext(ctx, "XA RECOVER")
+
+	if err != nil {
+		return err
+	}
+
+	var xids []xid
+
+	for rows.Next() {
+		var formatID int64
+		var gtridLen, bqualLen int
+		var data string
+
+		if err = rows.Scan(&formatID, &gtridLen, &bqualLen, &data); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if formatID != 1 || gtridLen+bqualLen != len(data) || !strings.HasPrefix(data[:gtridLen], prefix) {
+			continue
+		}
+
+		xids = append(xids, xid{data[:gtridLen], data[gtridLen:]})
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, x := range xids {
+		action := "ROLLBACK"
+
+		if committed[x.gtrid] {
+			action = "COMMIT"
+		}
+
+		if _, err = ins.Master.ExecContext(ctx, "XA "+action+" '"+x.gtrid+"','"+x.bqual+"'"); err != nil {
+			return err
+		}
+
+		log.Warnf(ctx, "gtrid=%v||bqual=%v||instance=%v||action=%v||go-mysql: in-doubt XA transaction is resolved", x.gtrid, x.bqual, ins.Name, action)
+	}
+
+	return nil
+}
+
+// readXALogs 读取 dir 下所有的恢复日志，返回所有已经决定提交的 gtrid。
+func readXALogs(dir string) (committed map[string]bool, err error) {
+	committed = map[string]bool{}
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != xaLogExt {
+			continue
+		}
+
+		data, e := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+
+		if e != nil {
+			return nil, e
+		}
+
+		record := &xaRecord{}
+
+		if e := json.Unmarshal(data, record); e != nil {
+			return nil, fmt.Errorf("go-mysql: XA log %v is corrupted: %v", fi.Name(), e)
+		}
+
+		committed[record.Gtrid] = true
+	}
+
+	return
+}
+
+// xaNodes 缓存每个恢复日志目录中的节点名。
+var xaNodes sync.Map
+
+// xaNodeName 返回当前节点的名字，没有设置 Config.XA.Node 时使用恢复日志目录中保存的节点名，
+// 第一次使用这个目录时随机生成一个并保存下来，这样同一台机器上的多个进程只要使用不同的目录就不会互相干扰，
+// 进程重启后依然使用原来的名字，可以恢复自己发起的事务。
+func xaNodeName(opts factoryOptions) (node string, err error) {
+	if opts.xaNode != "" {
+		return opts.xaNode, nil
+	}
+
+	if v, ok := xaNodes.Load(opts.xaLogDir); ok {
+		return v.(string), nil
+	}
+
+	if node, err = loadXANode(opts.xaLogDir); err != nil {
+		return
+	}
+
+	v, _ := xaNodes.LoadOrStore(opts.xaLogDir, node)
+	node = v.(string)
+	return
+}
+
+func loadXANode(dir string) (node string, err error) {
+	path := filepath.Join(dir, xaNodeFile)
+
+	for {
+		var data []byte
+
+		if data, err = ioutil.ReadFile(path); err == nil {
+			if node = strings.TrimSpace(string(data)); node == "" {
+				err = fmt.Errorf("go-mysql: XA node file %v is empty", path)
+			}
+
+			return
+		}
+
+		if !os.IsNotExist(err) {
+			return
+		}
+
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return
+		}
+
+		id := make([]byte, 8)
+
+		if _, err = rand.Read(id); err != nil {
+			return
+		}
+
+		// 先写临时文件再链接过去，多个进程同时初始化时只有一个能成功，其他进程重新读取。
+		tmp := filepath.Join(dir, fmt.Sprintf(".%v.%v", xaNodeFile, hex.EncodeToString(id)))
+
+		if err = ioutil.WriteFile(tmp, []byte(hex.EncodeToString(id)+"\n"), 0644); err != nil {
+			return
+		}
+
+		err = os.Link(tmp, path)
+		os.Remove(tmp)
+
+		if err != nil && !os.IsExist(err) {
+			return
+		}
+	}
+}
+
+func xaLogPath(dir, gtrid string) string {
+	return filepath.Join(dir, gtrid+xaLogExt)
+}
+
+// xaGtridPrefix 返回 node 发起的 XA 事务的 gtrid 前缀，node 中只保留字母、数字、点和下划线。
+func xaGtridPrefix(node string) string {
+	buf := make([]byte, 0, len(node))
+
+	for i := 0; i < len(node) && len(buf) < xaMaxNodeLength; i++ {
+		c := node[i]
+
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' {
+			buf = append(buf, c)
+		} else {
+			buf = append(buf, '_')
+		}
+	}
+
+	return xaPrefix + string(buf) + "-"
+}
@@ -0,0 +1,163 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/huandu/go-assert"
+)
+
+func TestXA(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "go-mysql-xa")
+	a.NilError(err)
+	defer os.RemoveAll(dir)
+
+	f := memShardFactory(t, 2)
+	defer f.Close()
+	f.xaLogDir = dir
+	f.xaNode = "test"
+	ctx := context.Background()
+
+	for i := int64(0); i < 2; i++ {
+		initTable(WithIndex(ctx, i), t, f, "xa", "id BIGINT PRIMARY KEY")
+	}
+
+	count := func(idx int64) (cnt int) {
+		row, err := f.New(WithIndex(ctx, idx)).QueryRow("SELECT COUNT(*) FROM xa")
+		a.NilError(err)
+		a.NilError(row.Scan(&cnt))
+		return
+	}
+	insert := func(xa *XA, ids ...int64) {
+		for _, id := range ids {
+			tx, err := xa.Tx(id)
+			a.NilError(err)
+			a.NilError(tx.Exec("INSERT INTO xa VALUES (?)", id))
+		}
+	}
+
+	a.NilError(f.XATransaction(ctx, func(xa *XA) error {
+		insert(xa, 1, 2, 3)
+		return nil
+	}))
+	a.Equal(count(0), 1)
+	a.Equal(count(1), 2)
+
+	files, err := ioutil.ReadDir(dir)
+	a.NilError(err)
+	a.Equal(len(files), 0)
+
+	errFoo := errors.New("foo")
+	a.Equal(f.XATransaction(ctx, func(xa *XA) error {
+		insert(xa, 4, 5)
+		return errFoo
+	}), errFoo)
+	a.Equal(count(0), 1)
+	a.Equal(count(1), 2)
+
+	// 分支不能单独提交。
+	xa, err := f.BeginXA(ctx)
+	a.NilError(err)
+	insert(xa, 6)
+	tx, err := xa.Tx(6)
+	a.NilError(err)
+	a.Equal(tx.Commit(), errXABranch)
+	a.NilError(xa.Commit())
+	a.Equal(xa.Commit(), sql.ErrTxDone)
+	a.Equal(count(0), 2)
+
+	// 模拟提交过程中崩溃：crashed 已经记录了提交的决定，undecided 还没有。
+	prepare := func(xa *XA, logged bool) {
+		for _, b := range xa.branches {
+			a.NilError(xa.exec(ctx, b, "END"))
+			a.NilError(xa.exec(ctx, b, "PREPARE"))
+		}
+
+		if logged {
+			a.NilError(xa.writeLog())
+		}
+
+		xa.close()
+	}
+	crashed, err := f.BeginXA(ctx)
+	a.NilError(err)
+	insert(crashed, 10, 11)
+	prepare(crashed, true)
+
+	undecided, err := f.BeginXA(ctx)
+	a.NilError(err)
+	insert(undecided, 20, 21)
+	prepare(undecided, false)
+
+	// 其他节点发起的事务不受影响。
+	f.xaNode = "other"
+	other, err := f.BeginXA(ctx)
+	a.NilError(err)
+	insert(other, 30)
+	prepare(other, false)
+	f.xaNode = "test"
+
+	a.Equal(count(0), 2)
+	a.Equal(count(1), 2)
+
+	a.NilError(f.RecoverXA(ctx))
+	a.Equal(count(0), 3)
+	a.Equal(count(1), 3)
+
+	files, err = ioutil.ReadDir(dir)
+	a.NilError(err)
+	a.Equal(len(files), 0)
+
+	var n int
+	rows, err := f.New(WithIndex(ctx, 0)).Query("XA RECOVER")
+	a.NilError(err)
+
+	for rows.Next() {
+		n++
+	}
+
+	rows.Close()
+	a.Equal(n, 1)
+
+	// 没有设置日志目录时不能使用 XA。
+	f.xaLogDir = ""
+	_, err = f.BeginXA(ctx)
+	a.NonNilError(err)
+}
+
+func TestXANode(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "go-mysql-xa-node")
+	a.NilError(err)
+	defer os.RemoveAll(dir)
+	dir1, dir2 := filepath.Join(dir, "1"), filepath.Join(dir, "2")
+
+	// 没有设置节点名时，每个日志目录有独立的随机名字。
+	node1, err := xaNodeName(factoryOptions{xaLogDir: dir1})
+	a.NilError(err)
+	node2, err := xaNodeName(factoryOptions{xaLogDir: dir2})
+	a.NilError(err)
+	a.Assert(node1 != "")
+	a.Assert(node1 != node2)
+
+	// 重启后使用保存在目录中的名字。
+	xaNodes.Delete(dir1)
+	node, err := xaNodeName(factoryOptions{xaLogDir: dir1})
+	a.NilError(err)
+	a.Equal(node, node1)
+
+	// 节点名文件不是恢复日志。
+	committed, err := readXALogs(dir1)
+	a.NilError(err)
+	a.Equal(len(committed), 0)
+
+	node, err = xaNodeName(factoryOptions{xaLogDir: dir1, xaNode: "test"})
+	a.NilError(err)
+	a.Equal(node, "test")
+}